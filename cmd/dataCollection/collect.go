@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/cluster"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/config"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/container2"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/crq"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/node"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/nodegroup"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/resourcequota"
+)
+
+// runCollect collects the data of the included entities into ./data, logging to ./data/log.txt.
+func runCollect(args []string) {
+	fs := newFlagSet("collect", "[flags]", "Collect the data from Prometheus into the CSV files of ./data, logging to ./data/log.txt. This is the default command,\nrun when no command is given.")
+	flags := config.AddFlags(fs)
+	printConfig := fs.Bool("print-config", false, "Print the effective value of every setting and where it comes from, with the secrets redacted, then exit")
+	fs.Parse(args)
+
+	//Printing the settings neither collects nor logs anything, so it does not need ./data.
+	if *printConfig {
+		initParameters(flags, true, io.Discard)
+		os.Exit(0)
+	}
+
+	logFile, err := os.OpenFile("./data/log.txt", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Read in the command line and config file parameters and set the required variables.
+	initParameters(flags, false, logFile)
+	params.InfoLogger.Println("Version " + version)
+	fmt.Println("[INFO] Version " + version)
+
+	setCurrentTime(params)
+
+	//Stop the collection cleanly if the pod is terminated or the run deadline is reached; open files are closed and nothing more is queried.
+	ctx, stop := runContext(params)
+	defer stop()
+
+	connect(ctx, params)
+
+	//Find out once which metrics Prometheus has, so the collectors pick the queries matching the exporters.
+	common.DiscoverCapabilities(ctx, params)
+	exitIfStopped(ctx)
+
+	if includeContainer {
+		container2.Metrics(ctx, params)
+		exitIfStopped(ctx)
+	} else {
+		params.InfoLogger.Println("Skipping container data collection")
+		fmt.Println("[INFO] Skipping container data collection")
+	}
+	if includeNode {
+		node.Metrics(ctx, params)
+		exitIfStopped(ctx)
+	} else {
+		params.InfoLogger.Println("Skipping node data collection")
+		fmt.Println("[INFO] Skipping node data collection")
+	}
+	if includeNodeGroup {
+		nodegroup.Metrics(ctx, params)
+		exitIfStopped(ctx)
+	} else {
+		params.InfoLogger.Println("Skipping node group data collection")
+		fmt.Println("[INFO] Skipping node group data collection")
+	}
+	if includeCluster {
+		cluster.Metrics(ctx, params)
+		exitIfStopped(ctx)
+	} else {
+		params.InfoLogger.Println("Skipping cluster data collection")
+		fmt.Println("[INFO] Skipping cluster data collection")
+	}
+	if includeQuota {
+		crq.Metrics(ctx, params)
+		resourcequota.Metrics(ctx, params)
+		exitIfStopped(ctx)
+	} else {
+		params.InfoLogger.Println("Skipping quota data collection")
+		fmt.Println("[INFO] Skipping quota data collection")
+	}
+
+	common.WriteManifest(params)
+	common.WriteQueryStats(params)
+}
+
+// exitIfStopped ends the run with an error if it was interrupted or ran past its deadline, so the partial data is not treated as a complete collection.
+func exitIfStopped(ctx context.Context) {
+	if err := ctx.Err(); err != nil {
+		common.WriteManifest(params)
+		common.WriteQueryStats(params)
+		msg := fmt.Sprintf("Data collection stopped: %s\n", err.Error())
+		params.ErrorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
+	}
+}
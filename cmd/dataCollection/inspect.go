@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// inspectManifest and inspectQueryStats hold the parts of manifest.json and query_stats.json the inspect command reports.
+type inspectManifest struct {
+	GeneratedAt string `json:"generatedAt"`
+	Endpoints   []struct {
+		Source  string `json:"source"`
+		URL     string `json:"url"`
+		Healthy bool   `json:"healthy"`
+		Version string `json:"version"`
+	} `json:"endpoints"`
+	ThrottledQueries int    `json:"throttledQueries"`
+	ThrottledTime    string `json:"throttledTime"`
+}
+
+type inspectQueryStats struct {
+	Queries []struct {
+		Entity  string  `json:"entity"`
+		Metric  string  `json:"metric"`
+		Query   string  `json:"query"`
+		Cached  bool    `json:"cached"`
+		Seconds float64 `json:"seconds"`
+		Error   string  `json:"error"`
+	} `json:"queries"`
+}
+
+// runInspect summarizes the results of the last data collection: the Prometheus endpoints, the files of each entity, the
+// failed and the slowest queries and the errors logged.
+func runInspect(args []string) {
+	fs := newFlagSet("inspect", "[flags]", "Summarize the results of the last data collection: the Prometheus endpoints, the CSV files of each entity with their\nnumber of rows, the failed and the slowest queries and the number of errors and warnings logged.")
+	dataDir := fs.String("data", "./data", "Directory of the collected data")
+	top := fs.Int("top", 5, "Number of the slowest queries to list")
+	fs.Parse(args)
+
+	if fi, err := os.Stat(*dataDir); err != nil || !fi.IsDir() {
+		log.Fatalf("%s %s", "[ERROR]", fmt.Sprintf("No collected data in %s\n", *dataDir))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	var m inspectManifest
+	if ok := readJSON(filepath.Join(*dataDir, "manifest.json"), &m); ok {
+		fmt.Printf("Collected at %s\n\n", m.GeneratedAt)
+		fmt.Fprintln(w, "ENDPOINT\tSOURCE\tHEALTHY\tVERSION")
+		for _, ep := range m.Endpoints {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", ep.URL, ep.Source, ep.Healthy, ep.Version)
+		}
+		w.Flush()
+		if m.ThrottledQueries > 0 {
+			fmt.Printf("%d queries throttled for %s in total\n", m.ThrottledQueries, m.ThrottledTime)
+		}
+		fmt.Println()
+	}
+
+	// the CSV files of each entity, without rows if only the header was written
+	var empty []string
+	fmt.Fprintln(w, "ENTITY\tFILES\tROWS\tEMPTY FILES")
+	entries, _ := os.ReadDir(*dataDir)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(*dataDir, e.Name(), "*.csv"))
+		if len(files) == 0 {
+			continue
+		}
+		var rows, emptyFiles int
+		for _, f := range files {
+			n := csvRows(f)
+			rows += n
+			if n == 0 {
+				emptyFiles++
+				empty = append(empty, filepath.Join(e.Name(), filepath.Base(f)))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", e.Name(), len(files), rows, emptyFiles)
+	}
+	w.Flush()
+	if len(empty) > 0 {
+		fmt.Printf("Files without data: %s\n", strings.Join(empty, ", "))
+	}
+
+	var qs inspectQueryStats
+	if ok := readJSON(filepath.Join(*dataDir, "query_stats.json"), &qs); ok {
+		var failed, cached int
+		for _, q := range qs.Queries {
+			if q.Error != "" {
+				failed++
+			}
+			if q.Cached {
+				cached++
+			}
+		}
+		fmt.Printf("\n%d queries, %d failed, %d served from the cache\n", len(qs.Queries), failed, cached)
+		if failed > 0 {
+			fmt.Fprintln(w, "\nFAILED\tMETRIC\tERROR")
+			for _, q := range qs.Queries {
+				if q.Error != "" {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", q.Entity, q.Metric, q.Error)
+				}
+			}
+			w.Flush()
+		}
+		// the queries are sorted from the slowest
+		if n := len(qs.Queries); n > 0 && *top > 0 {
+			if n > *top {
+				n = *top
+			}
+			fmt.Fprintln(w, "\nSLOWEST\tENTITY\tMETRIC\tQUERY")
+			for _, q := range qs.Queries[:n] {
+				fmt.Fprintf(w, "%.3fs\t%s\t%s\t%s\n", q.Seconds, q.Entity, q.Metric, q.Query)
+			}
+			w.Flush()
+		}
+	}
+
+	if errors, warnings, ok := logCounts(filepath.Join(*dataDir, "log.txt")); ok {
+		fmt.Printf("\n%d errors and %d warnings logged in log.txt\n", errors, warnings)
+	}
+}
+
+// readJSON reads the JSON file into v, reporting whether it could; a missing file is not reported.
+func readJSON(file string, v interface{}) bool {
+	b, err := os.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("[WARNING] Cannot read %s: %s\n", file, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// csvRows returns the number of rows of the CSV file, without its header.
+func csvRows(file string) int {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	lines := bytes.Count(b, []byte("\n"))
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		lines++
+	}
+	if lines == 0 {
+		return 0
+	}
+	return lines - 1
+}
+
+// logCounts counts the errors and warnings in the log file.
+func logCounts(file string) (errors, warnings int, ok bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		switch {
+		case strings.HasPrefix(s.Text(), "[ERROR] "):
+			errors++
+		case strings.HasPrefix(s.Text(), "[WARN] "):
+			warnings++
+		}
+	}
+	return errors, warnings, true
+}
@@ -1,263 +1,207 @@
 // Package main collects data from Prometheus and formats the data into CSVs that will be sent to Densify through the Forwarder.
+//
+// The data collection is the collect command, which is also run when no command is given, so
+//
+//	dataCollection --file config --path ./config
+//
+// runs as it always has. The other commands check the setup before a cluster is onboarded and look into the results.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/cluster"
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
-	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/container2"
-	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/crq"
-	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/node"
-	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/nodegroup"
-	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/resourcequota"
-	"github.com/spf13/viper"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/config"
 )
 
-// Global structure used to store Forwarder instance parameters
-var params *common.Parameters
+// version of the data collection.
+const version = "3.0.4"
 
-// Parameters that allows user to control what levels they want to collect data on (cluster, node, container)
-var includeContainer, includeNode, includeNodeGroup, includeCluster, includeQuota bool
+// command is a subcommand of dataCollection; run parses its own flags from args.
+type command struct {
+	name, summary string
+	run           func(args []string)
+}
 
-// initParamters will look for settings defined on the command line or in config.properties file and update accordingly. Also defines the default values for these variables.
-// Note if the value is defined both on the command line and in the config.properties the value in the config.properties will be used.
-func initParameters() {
-	//Set default settings
-	var clusterName string
-	var promProtocol = "http"
-	var promAddr string
-	var promPort = "9090"
-	var interval = "hours"
-	var intervalSize = 1
-	var history = 1
-	var offset int
-	var debug = false
-	var configFile = "config"
-	var configPath = "./config"
-	var sampleRate = 5
-	var include = "container,node,cluster,nodegroup,quota"
-	var nodeGroupList = "label_cloud_google_com_gke_nodepool,label_eks_amazonaws_com_nodegroup,label_agentpool,label_pool_name,label_alpha_eksctl_io_nodegroup_name,label_kops_k8s_io_instancegroup"
-	var oAuthTokenPath = ""
-	var caCertPath = ""
-
-	//Temporary variables for procassing flags
-	var clusterNameTemp, promAddrTemp, promPortTemp, promProtocolTemp, intervalTemp, oAuthTokenPathTemp, caCertPathTemp, includeTemp, nodeGroupListTemp string
-	var intervalSizeTemp, historyTemp, offsetTemp, sampleRateTemp int
-	var debugTemp bool
-
-	//Set settings using environment variables
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_CLUSTER"); ok {
-		clusterName = tempEnvVar
-	}
+// commands are listed in the usage in this order. collect is the default command.
+var commands = []*command{
+	{"collect", "Collect the data from Prometheus into the CSV files of ./data (the default command)", runCollect},
+	{"preflight", "Check the connection to Prometheus and whether it has the metrics each entity needs", runPreflight},
+	{"validate-config", "Check the settings without connecting to Prometheus", runValidateConfig},
+	{"query", "Run a PromQL query through the configured connection and print the result", runQuery},
+	{"inspect", "Summarize the results of the last data collection in ./data", runInspect},
+	{"version", "Print the version", runVersion},
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_PROTOCOL"); ok {
-		promProtocol = tempEnvVar
+// main function. The first argument names the command; without one, or if it is a flag, the data is collected.
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		runCollect(args)
+		return
 	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_ADDRESS"); ok {
-		promAddr = tempEnvVar
+	if isHelp(args[0]) || args[0] == "help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				cmd.run([]string{"-h"})
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[1])
+			usage(os.Stderr)
+			os.Exit(2)
+		}
+		usage(os.Stdout)
+		return
 	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_PORT"); ok {
-		promPort = tempEnvVar
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		os.Exit(2)
 	}
+	cmd.run(args[1:])
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_INTERVAL"); ok {
-		interval = tempEnvVar
-	}
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_INTERVALSIZE"); ok {
-		intervalSizeTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			intervalSize = int(intervalSizeTemp)
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
 	}
+	return nil
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_SAMPLERATE"); ok {
-		sampleRateTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			sampleRate = int(sampleRateTemp)
-		}
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: dataCollection [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(w, "\nWithout a command, the data is collected. Run dataCollection help <command> for the flags of a command.\n")
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_HISTORY"); ok {
-		historyTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			history = int(historyTemp)
+// newFlagSet returns the flag set of the command, whose help starts with its usage line and description.
+func newFlagSet(name, usageLine, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: dataCollection %s %s\n\n%s\n", name, usageLine, description)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
 		}
 	}
+	return fs
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_OFFSET"); ok {
-		offsetTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			offset = int(offsetTemp)
-		}
-	}
+// runVersion prints the version.
+func runVersion(args []string) {
+	fs := newFlagSet("version", "", "Print the version of the data collection.")
+	fs.Parse(args)
+	fmt.Println("dataCollection version " + version)
+}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_DEBUG"); ok {
-		debugTemp, err := strconv.ParseBool(tempEnvVar)
-		if err == nil {
-			debug = debugTemp
-		}
-	}
+// Global structure used to store Forwarder instance parameters
+var params *common.Parameters
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_CONFIGFILE"); ok {
-		configFile = tempEnvVar
-	}
+// Parameters that allows user to control what levels they want to collect data on (cluster, node, container)
+var includeContainer, includeNode, includeNodeGroup, includeCluster, includeQuota bool
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_CONFIGPATH"); ok {
-		configPath = tempEnvVar
-	}
+// initParamters will look for settings defined in the environment, in config.properties file and on the command line and update accordingly.
+// Note if the value is defined in more than one place, the command line wins over config.properties, which wins over the environment.
+// The flags of the command must be parsed. If printConfig is set, the effective settings are printed; the run ends if any is invalid.
+func initParameters(flags *config.Flags, printConfig bool, logFile io.Writer) {
+	//Settings are layered: defaults, environment variables, config file and command line, each overriding the ones before it.
+	cfg, errs := config.Load(flags)
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_INCLUDE"); ok {
-		include = tempEnvVar
-	}
+	var infoLogger, warnLogger, errorLogger, debugLogger *log.Logger
 
-	if tempEnvVar, ok := os.LookupEnv("NODE_GROUP_LIST"); ok {
-		nodeGroupList = tempEnvVar
+	infoLogger = log.New(logFile, "[INFO] ", log.Ldate|log.Ltime|log.Lshortfile)
+	warnLogger = log.New(logFile, "[WARN] ", log.Ldate|log.Ltime|log.Lshortfile)
+	errorLogger = log.New(logFile, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
+	debugLogger = log.New(logFile, "[DEBUG] ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if cfg.File() == "" && cfg.Origin("config_file") == config.OriginDefault && cfg.Origin("config_path") == config.OriginDefault {
+		fmt.Printf("[INFO] No config file %s found in %s, using the environment variables and command line only\n", cfg.ConfigFile, cfg.ConfigPath)
+		infoLogger.Printf("No config file %s found in %s, using the environment variables and command line only\n", cfg.ConfigFile, cfg.ConfigPath)
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("OAUTH_TOKEN"); ok {
-		oAuthTokenPath = tempEnvVar
+	//Check every setting before anything is queried, the invalid ones are all reported at once.
+	errs = append(errs, cfg.Validate()...)
+
+	headerMap, err := common.ParseHeaders(cfg.Headers)
+	if err != nil {
+		errs.Addf("prometheus_headers: %v", err)
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("CA_CERT"); ok {
-		caCertPath = tempEnvVar
+	extraQueryParams, err := common.ParseQueryParams(cfg.QueryPreset, cfg.QueryParams)
+	if err != nil {
+		errs.Addf("prometheus_query_params: %v", err)
 	}
 
-	//Get the settings passed in from the command line and update the variables as required.
-	flag.StringVar(&clusterNameTemp, "clusterName", clusterName, "Name of the cluster to show in Densify")
-	flag.StringVar(&promProtocolTemp, "protocol", promProtocol, "Which protocol to use http|https")
-	flag.StringVar(&promAddrTemp, "address", promAddr, "Name of the Prometheus Server")
-	flag.StringVar(&promPortTemp, "port", promPort, "Prometheus Port")
-	flag.StringVar(&intervalTemp, "interval", interval, "Interval to use for data collection. Can be days, hours or minutes")
-	flag.IntVar(&intervalSizeTemp, "intervalSize", intervalSize, "Interval size to be used for querying. eg. default of 1 with default interval of hours queries 1 last hour of info")
-	flag.IntVar(&historyTemp, "history", history, "Amount of time to go back for data collection works with the interval and intervalSize settings")
-	flag.IntVar(&offsetTemp, "offset", offset, "Amount of units (based on interval value) to offset the data collection backwards in time")
-	flag.IntVar(&sampleRateTemp, "sampleRate", sampleRate, "Rate of sample points to collect. default is 5 for 1 sample for every 5 minutes.")
-	flag.BoolVar(&debugTemp, "debug", debug, "Enable debug logging")
-	flag.StringVar(&configFile, "file", configFile, "Name of the config file without extension. Default config")
-	flag.StringVar(&configPath, "path", configPath, "Path to where the config file is stored")
-	flag.StringVar(&includeTemp, "includeList", include, "Comma separated list of data to include in collection (cluster, node, container, nodegroup, quota) Ex: \"node,cluster\"")
-	flag.StringVar(&nodeGroupListTemp, "nodeGroupList", nodeGroupList, "Comma separated list of labels to check for building node groups Ex: \"label_cloud_google_com_gke_nodepool,label_eks_amazonaws_com_nodegroup,label_agentpool,label_pool_name\"")
-	flag.StringVar(&oAuthTokenPathTemp, "oAuthToken", oAuthTokenPath, "Path to oAuth token file required to authenticate with the Cluster where Prometheus is running.")
-	flag.StringVar(&caCertPathTemp, "caCert", caCertPath, "Path to CA certificate required to pass certificate validation if using HTTPS")
-	flag.Parse()
-
-	//Set defaults for viper to use if setting not found in the config.properties file.
-	if configFile != "" {
-
-		viper.SetDefault("cluster_name", clusterName)
-		viper.SetDefault("prometheus_protocol", promProtocol)
-		viper.SetDefault("prometheus_address", promAddr)
-		viper.SetDefault("prometheus_port", promPort)
-		viper.SetDefault("interval", interval)
-		viper.SetDefault("interval_size", intervalSize)
-		viper.SetDefault("sample_rate", sampleRate)
-		viper.SetDefault("history", history)
-		viper.SetDefault("offset", offset)
-		viper.SetDefault("debug", debug)
-		viper.SetDefault("include_list", include)
-		viper.SetDefault("node_group_list", nodeGroupList)
-		viper.SetDefault("prometheus_oauth_token", oAuthTokenPath)
-		viper.SetDefault("ca_certificate", caCertPath)
-		// Config import setup.
-		viper.SetConfigName(configFile)
-		viper.AddConfigPath(configPath)
-		err := viper.ReadInConfig()
-		if err == nil {
-
-			//Process the config.properties file update the variables as required.
-			clusterName = viper.GetString("cluster_name")
-			promProtocol = viper.GetString("prometheus_protocol")
-			promAddr = viper.GetString("prometheus_address")
-			promPort = viper.GetString("prometheus_port")
-			interval = viper.GetString("interval")
-			intervalSize = viper.GetInt("interval_size")
-			sampleRate = viper.GetInt("sample_rate")
-			history = viper.GetInt("history")
-			offset = viper.GetInt("offset")
-			debug = viper.GetBool("debug")
-			include = viper.GetString("include_list")
-			nodeGroupList = viper.GetString("node_group_list")
-			oAuthTokenPath = viper.GetString("prometheus_oauth_token")
-			caCertPath = viper.GetString("ca_certificate")
+	// The full URL takes precedence over the protocol, address and port, the cluster name is still derived from its host
+	promAddr := cfg.Address
+	promURL := cfg.Protocol + "://" + cfg.Address + ":" + cfg.Port
+	if cfg.URL != "" {
+		u, err := common.ParsePrometheusURL(cfg.URL)
+		if err != nil {
+			errs.Addf("prometheus_url: %v", err)
+		} else {
+			promURL = u.String()
+			promAddr = u.Hostname()
 		}
 	}
 
-	visitor := func(a *flag.Flag) {
-		switch a.Name {
-		case "clusterName":
-			clusterName = clusterNameTemp
-		case "protocol":
-			promProtocol = promProtocolTemp
-		case "address":
-			promAddr = promAddrTemp
-		case "port":
-			promPort = promPortTemp
-		case "interval":
-			interval = intervalTemp
-		case "intervalSize":
-			intervalSize = intervalSizeTemp
-		case "sampleRate":
-			sampleRate = sampleRateTemp
-		case "history":
-			history = historyTemp
-		case "offset":
-			offset = offsetTemp
-		case "debug":
-			debug = debugTemp
-		case "includeList":
-			include = includeTemp
-		case "nodeGroupList":
-			nodeGroupList = nodeGroupListTemp
-		case "oAuthToken":
-			oAuthTokenPath = oAuthTokenPathTemp
-		case "caCert":
-			caCertPath = caCertPathTemp
+	promURLs := []string{promURL}
+	for _, f := range strings.Split(cfg.FailoverURLs, ",") {
+		if strings.TrimSpace(f) == "" {
+			continue
 		}
+		u, err := common.ParsePrometheusURL(f)
+		if err != nil {
+			errs.Addf("prometheus_failover_urls: %v", err)
+			continue
+		}
+		promURLs = append(promURLs, u.String())
 	}
 
-	flag.Visit(visitor)
-
-	promURL := promProtocol + "://" + promAddr + ":" + promPort
-
-	logFile, err := os.OpenFile("./data/log.txt", os.O_WRONLY|os.O_CREATE, 0644)
+	namespaces, err := common.ParseNamespaceFilter(cfg.IncludeNamespaces, cfg.ExcludeNamespaces)
 	if err != nil {
-		log.Fatal(err)
+		errs.Addf("include_namespaces or exclude_namespaces: %v", err)
 	}
 
-	var infoLogger, warnLogger, errorLogger, debugLogger *log.Logger
-
-	infoLogger = log.New(logFile, "[INFO] ", log.Ldate|log.Ltime|log.Lshortfile)
-	warnLogger = log.New(logFile, "[WARN] ", log.Ldate|log.Ltime|log.Lshortfile)
-	errorLogger = log.New(logFile, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
-	debugLogger = log.New(logFile, "[DEBUG] ", log.Ldate|log.Ltime|log.Lshortfile)
-
-	// Check if token and certificate are missing
-	if oAuthTokenPath != "" {
-		if _, err := os.Stat(oAuthTokenPath); os.IsNotExist(err) {
-			fmt.Printf("[INFO] %s does not exist. Attempting to execute without using oAuth token!\n", oAuthTokenPath)
-			infoLogger.Printf("%s does not exist. Attempting to execute without using oAuth token!\n", oAuthTokenPath)
-			oAuthTokenPath = ""
-		}
+	var promRoutes map[string]string
+	promSources, err := common.ParseSources(cfg.Sources)
+	if err != nil {
+		errs.Addf("prometheus_sources: %v", err)
+	} else if promRoutes, err = common.ParseRoutes(cfg.Routes, promSources); err != nil {
+		errs.Addf("prometheus_routes: %v", err)
 	}
 
-	if caCertPath != "" {
-		if _, err := os.Stat(caCertPath); os.IsNotExist(err) {
-			fmt.Printf("[INFO] %s does not exist. Attempting to execute without trusted CA Certificate configuration!\n", caCertPath)
-			infoLogger.Printf("%s does not exist. Attempting to execute without trusted CA Certificate configuration!\n", caCertPath)
-			caCertPath = ""
-		}
+	if printConfig {
+		cfg.Print(os.Stdout)
+	}
+	if err := errs.Err(); err != nil {
+		msg := fmt.Sprintf("Invalid configuration, %s\n", err.Error())
+		errorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
 	}
 
+	clusterName := cfg.ClusterName
 	if clusterName == "" {
 		clusterName = promAddr
 	}
@@ -267,51 +211,75 @@ func initParameters() {
 
 	params = &common.Parameters{
 
-		ClusterName:      &clusterName,
-		PromAddress:      &promAddr,
-		PromURL:          &promURL,
-		Interval:         &interval,
-		IntervalSize:     &intervalSize,
-		History:          &history,
-		Offset:           &offset,
-		Debug:            debug,
-		InfoLogger:       infoLogger,
-		WarnLogger:       warnLogger,
-		ErrorLogger:      errorLogger,
-		DebugLogger:      debugLogger,
-		SampleRate:       sampleRate,
-		SampleRateString: strconv.Itoa(sampleRate),
-		NodeGroupList:    nodeGroupList,
-		OAuthTokenPath:   oAuthTokenPath,
-		CaCertPath:       caCertPath,
-	}
-	parseIncludeParam(include)
-}
-
-func parseIncludeParam(param string) {
-	// cluster should always be included, regardless of the configuration
-	includeCluster = true
-	for _, elem := range strings.Split(param, ",") {
-		if strings.EqualFold(elem, "node") {
-			includeNode = true
-		} else if strings.EqualFold(elem, "container") {
-			includeContainer = true
-		} else if strings.EqualFold(elem, "nodegroup") {
-			includeNodeGroup = true
-		} else if strings.EqualFold(elem, "quota") {
-			includeQuota = true
-		}
+		ClusterName:            &clusterName,
+		PromAddress:            &promAddr,
+		PromURL:                &promURL,
+		PromURLs:               promURLs,
+		PromSources:            promSources,
+		PromRoutes:             promRoutes,
+		Interval:               &cfg.Interval,
+		IntervalSize:           &cfg.IntervalSize,
+		History:                &cfg.History,
+		Offset:                 &cfg.Offset,
+		Debug:                  cfg.Debug,
+		InfoLogger:             infoLogger,
+		WarnLogger:             warnLogger,
+		ErrorLogger:            errorLogger,
+		DebugLogger:            debugLogger,
+		SampleRate:             cfg.SampleRate,
+		SampleRateString:       strconv.Itoa(cfg.SampleRate),
+		NodeGroupList:          cfg.NodeGroupList,
+		OAuthTokenPath:         cfg.OAuthToken,
+		CaCertPath:             cfg.CACert,
+		DialTimeout:            cfg.DialTimeout,
+		TLSHandshakeTimeout:    cfg.TLSHandshakeTimeout,
+		IdleConnTimeout:        cfg.IdleConnTimeout,
+		Retries:                cfg.Retries,
+		RetryBackoff:           cfg.RetryBackoff,
+		RetryMaxBackoff:        cfg.RetryMaxBackoff,
+		QueryTimeout:           cfg.QueryTimeout,
+		RunTimeout:             cfg.RunTimeout,
+		ClientCertPath:         cfg.ClientCert,
+		ClientKeyPath:          cfg.ClientKey,
+		TLSServerName:          cfg.TLSServerName,
+		TLSMinVersion:          cfg.TLSMinVersion,
+		InsecureSkipVerify:     cfg.InsecureSkipVerify,
+		BasicAuthUsername:      cfg.Username,
+		BasicAuthPassword:      cfg.Password,
+		BasicAuthPasswordFile:  cfg.PasswordFile,
+		Headers:                headerMap,
+		SigV4Region:            cfg.SigV4Region,
+		SigV4Service:           cfg.SigV4Service,
+		SigV4AccessKey:         cfg.SigV4AccessKey,
+		SigV4SecretKey:         cfg.SigV4SecretKey,
+		OAuth2TokenURL:         cfg.OAuth2TokenURL,
+		OAuth2ClientID:         cfg.OAuth2ClientID,
+		OAuth2ClientSecret:     cfg.OAuth2ClientSecret,
+		OAuth2ClientSecretFile: cfg.OAuth2ClientSecretFile,
+		OAuth2Scopes:           cfg.OAuth2Scopes,
+		ProxyHost:              cfg.ProxyHost,
+		ProxyPort:              cfg.ProxyPort,
+		ProxyProtocol:          cfg.ProxyProtocol,
+		ProxyUser:              cfg.ProxyUser,
+		ProxyPassword:          cfg.ProxyPassword,
+		Concurrency:            cfg.Concurrency,
+		MaxQPS:                 cfg.MaxQPS,
+		MaxInFlight:            cfg.MaxInFlight,
+		CacheTTL:               cfg.CacheTTL,
+		CacheDir:               cfg.CacheDir,
+		RemoteRead:             cfg.RemoteRead,
+		InstantLookback:        cfg.InstantLookback,
+		QueryParams:            extraQueryParams,
+		Namespaces:             namespaces,
 	}
+	include := cfg.Include()
+	includeCluster, includeNode, includeContainer = include["cluster"], include["node"], include["container"]
+	includeNodeGroup, includeQuota = include["nodegroup"], include["quota"]
 }
 
-// main function.
-func main() {
-
-	//Read in the command line and config file parameters and set the required variables.
-	initParameters()
-	params.InfoLogger.Println("Version 3.0.4")
-	fmt.Println("[INFO] Version 3.0.4")
-
+// setCurrentTime sets the time all the queries are relative to: the current time in UTC, truncated to the interval and moved
+// back by the offset.
+func setCurrentTime(params *common.Parameters) {
 	//Get the current time in UTC and format it. The script uses this time for all the queries this way if you have a large environment we are collecting the data as a snapshot of a specific time and not potentially getting a misaligned set of data.
 	var t time.Time
 	t = time.Now().UTC()
@@ -324,8 +292,30 @@ func main() {
 		currentTime = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()-*params.Offset, 0, 0, t.Location())
 	}
 	params.CurrentTime = &currentTime
+}
+
+// runContext returns the context of the run, which is stopped when the process is terminated or the run timeout is reached.
+func runContext(params *common.Parameters) (ctx context.Context, stop func()) {
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if params.RunTimeout <= 0 {
+		return ctx, stopSignals
+	}
+	ctx, cancel := context.WithTimeout(ctx, params.RunTimeout)
+	return ctx, func() {
+		cancel()
+		stopSignals()
+	}
+}
 
-	if ver, err := common.GetVersion(params); err == nil {
+// connect creates the Prometheus client and checks the connection, ending the run if Prometheus cannot be reached.
+func connect(ctx context.Context, params *common.Parameters) {
+	if err := common.InitPromApi(params); err != nil {
+		msg := fmt.Sprintf("Failed to create Prometheus client: %s\n", err.Error())
+		params.ErrorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
+	}
+
+	if ver, err := common.GetVersion(ctx, params); err == nil {
 		fmt.Printf("[INFO] Detected Prometheus version %s\n", ver)
 		params.InfoLogger.Printf("Detected Prometheus version %s\n", ver)
 	} else {
@@ -333,36 +323,4 @@ func main() {
 		params.ErrorLogger.Printf(msg)
 		log.Fatalf("%s %s", "[ERROR]", msg)
 	}
-
-	if includeContainer {
-		container2.Metrics(params)
-	} else {
-		params.InfoLogger.Println("Skipping container data collection")
-		fmt.Println("[INFO] Skipping container data collection")
-	}
-	if includeNode {
-		node.Metrics(params)
-	} else {
-		params.InfoLogger.Println("Skipping node data collection")
-		fmt.Println("[INFO] Skipping node data collection")
-	}
-	if includeNodeGroup {
-		nodegroup.Metrics(params)
-	} else {
-		params.InfoLogger.Println("Skipping node group data collection")
-		fmt.Println("[INFO] Skipping node group data collection")
-	}
-	if includeCluster {
-		cluster.Metrics(params)
-	} else {
-		params.InfoLogger.Println("Skipping cluster data collection")
-		fmt.Println("[INFO] Skipping cluster data collection")
-	}
-	if includeQuota {
-		crq.Metrics(params)
-		resourcequota.Metrics(params)
-	} else {
-		params.InfoLogger.Println("Skipping quota data collection")
-		fmt.Println("[INFO] Skipping quota data collection")
-	}
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/config"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// requirement is a metric an entity needs, or several if all of them are. With kube-state-metrics 1, the ksm1 metrics are
+// needed instead, as the data collection picks the query variant matching the version of the exporter.
+type requirement struct {
+	metrics, ksm1 []string
+	optional      bool
+}
+
+func required(metrics ...string) requirement {
+	return requirement{metrics: metrics}
+}
+
+func optional(metrics ...string) requirement {
+	return requirement{metrics: metrics, optional: true}
+}
+
+// The metrics of kube-state-metrics 2 whose names differ in kube-state-metrics 1.
+var (
+	ksmLimits = requirement{
+		metrics: []string{"kube_pod_container_resource_limits"},
+		ksm1:    []string{"kube_pod_container_resource_limits_cpu_cores", "kube_pod_container_resource_limits_memory_bytes"},
+	}
+	ksmRequests = requirement{
+		metrics: []string{"kube_pod_container_resource_requests"},
+		ksm1:    []string{"kube_pod_container_resource_requests_cpu_cores", "kube_pod_container_resource_requests_memory_bytes"},
+	}
+	ksmCapacity = requirement{
+		metrics: []string{"kube_node_status_capacity"},
+		ksm1:    []string{"kube_node_status_capacity_cpu_cores", "kube_node_status_capacity_memory_bytes"},
+	}
+	ksmAllocatable = requirement{
+		metrics:  []string{"kube_node_status_allocatable"},
+		ksm1:     []string{"kube_node_status_allocatable_cpu_cores", "kube_node_status_allocatable_memory_bytes"},
+		optional: true,
+	}
+	ksmHPA = requirement{
+		metrics:  []string{"kube_horizontalpodautoscaler_labels"},
+		ksm1:     []string{"kube_hpa_labels"},
+		optional: true,
+	}
+)
+
+// nodeExporterWorkload are the metrics of Node Exporter the node, node group and cluster workloads are computed from, besides
+// the CPU and memory.
+var nodeExporterWorkload = optional("node_memory_Cached_bytes", "node_memory_Buffers_bytes", "node_disk_read_bytes_total",
+	"node_disk_written_bytes_total", "node_disk_io_time_seconds_total", "node_network_receive_bytes_total",
+	"node_network_transmit_bytes_total", "node_network_receive_packets_total", "node_network_transmit_packets_total")
+
+// readiness lists the metrics each entity of the include list needs. Without a required metric, the data of the entity is
+// not usable; without an optional one, part of it is missing.
+var readiness = []struct {
+	entity       string
+	requirements []requirement
+}{
+	{"container", []requirement{
+		required("kube_pod_info", "kube_pod_container_info", "kube_pod_owner"),
+		ksmLimits, ksmRequests,
+		required("container_cpu_usage_seconds_total", "container_memory_usage_bytes", "container_spec_memory_limit_bytes"),
+		optional("container_memory_rss", "container_fs_usage_bytes", "container_spec_cpu_shares"),
+		optional("kube_pod_labels", "kube_namespace_labels", "kube_replicaset_owner", "kube_job_owner"),
+		optional("kube_pod_container_status_restarts_total", "kube_pod_container_status_terminated_reason"),
+		ksmHPA,
+	}},
+	{"node", []requirement{
+		required("kube_node_info", "kube_node_labels"),
+		ksmCapacity, ksmAllocatable,
+		required("node_cpu_seconds_total", "node_memory_MemTotal_bytes", "node_memory_MemFree_bytes"),
+		nodeExporterWorkload,
+		optional("node_network_speed_bytes"),
+		{metrics: ksmLimits.metrics, ksm1: ksmLimits.ksm1, optional: true},
+		{metrics: ksmRequests.metrics, ksm1: ksmRequests.ksm1, optional: true},
+	}},
+	{"nodegroup", []requirement{
+		required("kube_node_labels", "kube_pod_info"),
+		ksmCapacity,
+		required("node_cpu_seconds_total", "node_memory_MemTotal_bytes", "node_memory_MemFree_bytes"),
+		nodeExporterWorkload,
+		{metrics: ksmLimits.metrics, ksm1: ksmLimits.ksm1, optional: true},
+		{metrics: ksmRequests.metrics, ksm1: ksmRequests.ksm1, optional: true},
+	}},
+	{"cluster", []requirement{
+		ksmCapacity,
+		required("node_cpu_seconds_total", "node_memory_MemTotal_bytes", "node_memory_MemFree_bytes"),
+		nodeExporterWorkload,
+		{metrics: ksmLimits.metrics, ksm1: ksmLimits.ksm1, optional: true},
+		{metrics: ksmRequests.metrics, ksm1: ksmRequests.ksm1, optional: true},
+	}},
+	{"quota", []requirement{
+		required("kube_resourcequota"),
+		optional("kube_resourcequota_created"),
+	}},
+}
+
+// Readiness of an entity.
+const (
+	statusReady    = "ready"
+	statusPartial  = "partial"
+	statusNotReady = "not ready"
+	statusUnknown  = "unknown"
+	statusSkipped  = "skipped"
+)
+
+// resultUnknown is the result of the checks of the metrics whose names could not be discovered.
+const resultUnknown = "unknown, the metric names could not be discovered"
+
+// runPreflight checks, before a cluster is onboarded, whether the data collection can connect to Prometheus and which of the
+// metrics it needs Prometheus has, and prints the readiness of each entity. It exits with an error if Prometheus cannot be
+// reached or an included entity is not ready.
+func runPreflight(args []string) {
+	fs := newFlagSet("preflight", "[flags]", "Check the connection to every Prometheus endpoint, including the authentication, and the Prometheus version, then\ndetect kube-state-metrics and its major version, Node Exporter, the labels of cAdvisor and openshift-state-metrics and\nprint the readiness of each entity, with the metrics missing. Exits with an error if Prometheus cannot be reached or an\nincluded entity is not ready.")
+	flags := config.AddFlags(fs)
+	fs.Parse(args)
+
+	initParameters(flags, false, io.Discard)
+	setCurrentTime(params)
+	ctx, stop := runContext(params)
+	defer stop()
+
+	if err := common.InitPromApi(params); err != nil {
+		msg := fmt.Sprintf("Failed to create Prometheus client: %s\n", err.Error())
+		params.ErrorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
+	}
+
+	notReady, err := preflight(ctx, os.Stdout)
+	if err != nil {
+		msg := fmt.Sprintf("Preflight failed, cannot connect to Prometheus: %s\n", err.Error())
+		if ctx.Err() != nil {
+			msg = fmt.Sprintf("Preflight stopped: %s\n", ctx.Err().Error())
+		}
+		params.ErrorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
+	}
+	if len(notReady) > 0 {
+		msg := fmt.Sprintf("Preflight failed, %s not ready\n", strings.Join(notReady, ", "))
+		params.ErrorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
+	}
+}
+
+// preflight runs the checks and prints their results and the readiness of the entities to out. It returns the included
+// entities which are not ready, or the error if Prometheus cannot be reached or the run is stopped.
+func preflight(ctx context.Context, out io.Writer) (notReady []string, err error) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT")
+	ver, err := common.GetVersion(ctx, params)
+	if err != nil {
+		if isAuthError(err) {
+			fmt.Fprintf(w, "Prometheus\treachable\n")
+			fmt.Fprintf(w, "Authentication\trejected: %s\n", err.Error())
+		} else {
+			fmt.Fprintf(w, "Prometheus\tunreachable: %s\n", err.Error())
+		}
+		w.Flush()
+		return nil, err
+	}
+	common.DiscoverCapabilities(ctx, params)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(w, "Prometheus\treachable, version %s\n", ver)
+	fmt.Fprintf(w, "Authentication\taccepted\n")
+	ksmMajor, ksmResult := kubeStateMetrics()
+	fmt.Fprintf(w, "kube-state-metrics\t%s\n", ksmResult)
+	fmt.Fprintf(w, "node-exporter\t%s\n", nodeExporter())
+	fmt.Fprintf(w, "cAdvisor\t%s\n", cAdvisor())
+	fmt.Fprintf(w, "openshift-state-metrics\t%s\n", detect("found", "not found, only needed for the cluster resource quotas of OpenShift", "openshift_clusterresourcequota_created"))
+	w.Flush()
+	fmt.Fprintln(out)
+
+	include := map[string]bool{"cluster": includeCluster, "node": includeNode, "container": includeContainer, "nodegroup": includeNodeGroup, "quota": includeQuota}
+	fmt.Fprintln(w, "ENTITY\tSTATUS\tMISSING")
+	for _, r := range readiness {
+		if !include[r.entity] {
+			fmt.Fprintf(w, "%s\t%s\tnot in include_list\n", r.entity, statusSkipped)
+			continue
+		}
+		status, missing := entityReadiness(r.requirements, ksmMajor)
+		if status == statusNotReady {
+			notReady = append(notReady, r.entity)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.entity, status, strings.Join(missing, ", "))
+	}
+	w.Flush()
+	return notReady, nil
+}
+
+// has reports whether Prometheus has the metric; known is false if its metric names could not be discovered.
+func has(metric string) (ok, known bool) {
+	if !common.Discovered(params, metric) {
+		return false, false
+	}
+	return common.Available(params, metric), true
+}
+
+// hasAny reports whether Prometheus has any of the metrics; known is false if none was found and the metric names of some
+// could not be discovered.
+func hasAny(metrics ...string) (ok, known bool) {
+	known = true
+	for _, metric := range metrics {
+		found, k := has(metric)
+		if found {
+			return true, true
+		}
+		known = known && k
+	}
+	return false, known
+}
+
+// detect returns found if Prometheus has any of the metrics, otherwise missing or, if that is not known, unknown.
+func detect(found, missing string, metrics ...string) string {
+	ok, known := hasAny(metrics...)
+	switch {
+	case ok:
+		return found
+	case !known:
+		return resultUnknown
+	}
+	return missing
+}
+
+// kubeStateMetrics detects kube-state-metrics and its major version, 0 if it is not known: version 1 has the resource
+// metrics ending in _cpu_cores and _memory_bytes and the kube_hpa metrics, version 2 the resource metrics with a resource
+// label and the kube_horizontalpodautoscaler metrics.
+func kubeStateMetrics() (major int, result string) {
+	if ok, known := hasAny("kube_pod_info", "kube_node_info", "kube_pod_container_info"); !ok {
+		if !known {
+			return 0, resultUnknown
+		}
+		return 0, "not found, needed for the container, node, node group and quota data"
+	}
+	var naming []string
+	v1Resources, _ := hasAny("kube_pod_container_resource_limits_cpu_cores", "kube_pod_container_resource_requests_cpu_cores", "kube_node_status_capacity_cpu_cores")
+	v2Resources, _ := hasAny("kube_pod_container_resource_limits", "kube_pod_container_resource_requests")
+	switch {
+	case v1Resources:
+		naming = append(naming, "resource metrics ending in _cpu_cores and _memory_bytes")
+	case v2Resources:
+		naming = append(naming, "kube_pod_container_resource_limits and _requests with a resource label")
+	}
+	v1HPA, _ := has("kube_hpa_labels")
+	v2HPA, _ := has("kube_horizontalpodautoscaler_labels")
+	switch {
+	case v1HPA:
+		naming = append(naming, "kube_hpa metrics")
+	case v2HPA:
+		naming = append(naming, "kube_horizontalpodautoscaler metrics")
+	default:
+		naming = append(naming, "no HPA metrics")
+	}
+	switch {
+	case v1Resources || v1HPA:
+		major = 1
+	case v2Resources || v2HPA:
+		major = 2
+	default:
+		return 0, "found, version unknown, " + strings.Join(naming, ", ")
+	}
+	return major, fmt.Sprintf("found, version %d (%s)", major, strings.Join(naming, ", "))
+}
+
+// nodeExporter detects Node Exporter; versions before 0.16 named the metrics differently and are not supported.
+func nodeExporter() string {
+	if old, _ := has("node_cpu"); old {
+		if ok, _ := has("node_cpu_seconds_total"); !ok {
+			return "found, but a version before 0.16 whose metric names are not supported"
+		}
+	}
+	return detect("found", "not found, needed for the node, node group and cluster workloads", "node_cpu_seconds_total", "node_memory_MemTotal_bytes")
+}
+
+// cAdvisor detects the container metrics of cAdvisor and the labels of the containers, which are pod_name and container_name
+// before Kubernetes 1.16.
+func cAdvisor() string {
+	result := detect("found", "not found, needed for the container workloads", "container_cpu_usage_seconds_total", "container_spec_memory_limit_bytes")
+	if result != "found" {
+		return result
+	}
+	if err := common.LabelsError(params); err != nil {
+		return "found, the labels of the containers could not be discovered: " + err.Error()
+	}
+	if params.LabelSuffix == "_name" {
+		return "found, containers labelled with pod_name and container_name"
+	}
+	return "found, containers labelled with pod and container"
+}
+
+// entityReadiness returns the readiness of an entity and the metrics it misses, the optional ones marked as such.
+func entityReadiness(requirements []requirement, ksmMajor int) (status string, missing []string) {
+	var requiredMissing, optionalMissing, unknown bool
+	for _, r := range requirements {
+		metrics := r.metrics
+		if r.ksm1 != nil {
+			switch ksmMajor {
+			case 1:
+				metrics = r.ksm1
+			case 0:
+				// either variant will do if the version is not known
+				if m, _ := missingMetrics(r.ksm1); len(m) == 0 {
+					metrics = r.ksm1
+				}
+			}
+		}
+		m, known := missingMetrics(metrics)
+		unknown = unknown || !known
+		for _, metric := range m {
+			if r.optional {
+				optionalMissing = true
+				missing = append(missing, metric+" (optional)")
+			} else {
+				requiredMissing = true
+				missing = append(missing, metric)
+			}
+		}
+	}
+	switch {
+	case requiredMissing:
+		return statusNotReady, missing
+	case unknown:
+		return statusUnknown, missing
+	case optionalMissing:
+		return statusPartial, missing
+	}
+	return statusReady, missing
+}
+
+// missingMetrics returns the metrics Prometheus does not have; known is false if the metric names of some could not be
+// discovered.
+func missingMetrics(metrics []string) (missing []string, known bool) {
+	known = true
+	for _, metric := range metrics {
+		ok, k := has(metric)
+		if !k {
+			known = false
+		} else if !ok {
+			missing = append(missing, metric)
+		}
+	}
+	return
+}
+
+// isAuthError reports whether Prometheus, or a proxy in front of it, rejected the credentials.
+func isAuthError(err error) bool {
+	var apiErr *v1.Error
+	if !errors.As(err, &apiErr) || apiErr.Type != v1.ErrClient {
+		return false
+	}
+	return strings.HasSuffix(apiErr.Msg, " 401") || strings.HasSuffix(apiErr.Msg, " 403")
+}
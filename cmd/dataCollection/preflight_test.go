@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/config"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// metrics of a cluster with kube-state-metrics 2, Node Exporter and cAdvisor, without the optional metrics
+var (
+	ksmMetrics = []string{"kube_pod_info", "kube_pod_container_info", "kube_pod_owner", "kube_node_info", "kube_node_labels",
+		"kube_pod_container_resource_limits", "kube_pod_container_resource_requests", "kube_node_status_capacity", "kube_resourcequota"}
+	nodeExporterMetrics = []string{"node_cpu_seconds_total", "node_memory_MemTotal_bytes", "node_memory_MemFree_bytes"}
+	cAdvisorMetrics     = []string{"container_cpu_usage_seconds_total", "container_memory_usage_bytes", "container_spec_memory_limit_bytes"}
+)
+
+// preflightServer serves Prometheus with the metric names and the labels of cAdvisor, rejecting the requests with the status
+// if it is set. The labels probe fails if the labels are nil.
+func preflightServer(t *testing.T, status int, names, labels []string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		var data interface{}
+		switch r.URL.Path {
+		case "/api/v1/status/buildinfo":
+			data = map[string]string{"version": "2.45.0"}
+		case "/api/v1/label/__name__/values":
+			data = names
+		case "/api/v1/labels":
+			if labels != nil {
+				data = labels
+			}
+		}
+		if data == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"status":"error","errorType":"bad_data","error":"not supported"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// runPreflightChecks runs the checks against the Prometheus at the URL and returns what they print.
+func runPreflightChecks(t *testing.T, promURL string) (string, []string, error) {
+	// no config file is found in the working directory, the settings are the flags
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	fs := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	flags := config.AddFlags(fs)
+	if err := fs.Parse([]string{"-url", promURL, "-clusterName", "test", "-retries", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	initParameters(flags, false, io.Discard)
+	setCurrentTime(params)
+	if err := common.InitPromApi(params); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	notReady, err := preflight(context.Background(), &out)
+	return out.String(), notReady, err
+}
+
+// row returns the result printed for the check or entity.
+func row(out, name string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, name))
+		}
+	}
+	return ""
+}
+
+func TestPreflight(t *testing.T) {
+	all := append(append(append([]string{}, ksmMetrics...), nodeExporterMetrics...), cAdvisorMetrics...)
+	podLabels := []string{"__name__", "container", "namespace", "pod"}
+	tests := []struct {
+		name     string
+		names    []string
+		labels   []string
+		rows     map[string]string
+		notReady []string
+	}{
+		{
+			name:   "ready",
+			names:  all,
+			labels: podLabels,
+			rows: map[string]string{
+				"kube-state-metrics": "found, version 2 (kube_pod_container_resource_limits and _requests with a resource label, no HPA metrics)",
+				"node-exporter":      "found",
+				"cAdvisor":           "found, containers labelled with pod and container",
+				"cluster":            "partial  node_memory_Cached_bytes (optional)",
+				"quota":              "partial  kube_resourcequota_created (optional)",
+			},
+		},
+		{
+			name:   "missing kube-state-metrics",
+			names:  append(append([]string{}, nodeExporterMetrics...), cAdvisorMetrics...),
+			labels: podLabels,
+			rows: map[string]string{
+				"kube-state-metrics": "not found, needed for the container, node, node group and quota data",
+				"node-exporter":      "found",
+				"quota":              "not ready  kube_resourcequota, kube_resourcequota_created (optional)",
+			},
+			notReady: []string{"container", "node", "nodegroup", "cluster", "quota"},
+		},
+		{
+			name:   "missing node-exporter",
+			names:  append(append([]string{}, ksmMetrics...), cAdvisorMetrics...),
+			labels: podLabels,
+			rows: map[string]string{
+				"node-exporter": "not found, needed for the node, node group and cluster workloads",
+				"cAdvisor":      "found, containers labelled with pod and container",
+			},
+			notReady: []string{"node", "nodegroup", "cluster"},
+		},
+		{
+			name:   "cAdvisor labels not discovered",
+			names:  all,
+			labels: nil,
+			rows: map[string]string{
+				"cAdvisor": "found, the labels of the containers could not be discovered: bad_data: not supported",
+			},
+		},
+		{
+			name:   "older cAdvisor labels",
+			names:  all,
+			labels: []string{"__name__", "container_name", "namespace", "pod_name"},
+			rows: map[string]string{
+				"cAdvisor": "found, containers labelled with pod_name and container_name",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, notReady, err := runPreflightChecks(t, preflightServer(t, 0, tt.names, tt.labels).URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row(out, "Prometheus") != "reachable, version 2.45.0" || row(out, "Authentication") != "accepted" {
+				t.Errorf("connection checks:\n%s", out)
+			}
+			for name, want := range tt.rows {
+				// the columns are aligned with spaces
+				got := strings.Join(strings.Fields(row(out, name)), " ")
+				if want = strings.Join(strings.Fields(want), " "); !strings.HasPrefix(got, want) {
+					t.Errorf("%s: %q, want %q", name, got, want)
+				}
+			}
+			if !reflect.DeepEqual(notReady, tt.notReady) {
+				t.Errorf("not ready %q, want %q\n%s", notReady, tt.notReady, out)
+			}
+		})
+	}
+}
+
+func TestPreflightRejected(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		out, _, err := runPreflightChecks(t, preflightServer(t, status, nil, nil).URL)
+		if !isAuthError(err) {
+			t.Errorf("%d: error %v, want an authentication error", status, err)
+		}
+		want := fmt.Sprintf("rejected: client_error: client error: %d", status)
+		if row(out, "Prometheus") != "reachable" || row(out, "Authentication") != want {
+			t.Errorf("%d: printed\n%s\nwant the authentication %s", status, out, want)
+		}
+	}
+
+	out, _, err := runPreflightChecks(t, preflightServer(t, http.StatusNotFound, nil, nil).URL)
+	if err == nil || isAuthError(err) {
+		t.Errorf("404: error %v", err)
+	}
+	if !strings.HasPrefix(row(out, "Prometheus"), "unreachable: ") || row(out, "Authentication") != "" {
+		t.Errorf("404: printed\n%s", out)
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unauthorized", &v1.Error{Type: v1.ErrClient, Msg: "client error: 401"}, true},
+		{"forbidden", &v1.Error{Type: v1.ErrClient, Msg: "client error: 403"}, true},
+		{"wrapped", fmt.Errorf("none of the 2 Prometheus endpoints is healthy, last error: %w", &v1.Error{Type: v1.ErrClient, Msg: "client error: 403"}), true},
+		{"not found", &v1.Error{Type: v1.ErrClient, Msg: "client error: 404"}, false},
+		{"server error", &v1.Error{Type: v1.ErrServer, Msg: "server error: 401"}, false},
+		{"bad data", &v1.Error{Type: v1.ErrBadData, Msg: "401"}, false},
+		{"other", errors.New("client error: 401"), false},
+		{"none", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isAuthError(tt.err); got != tt.want {
+			t.Errorf("%s: isAuthError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
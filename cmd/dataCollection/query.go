@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/config"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// queryEntity is the entity kind the queries of the query command are logged with.
+const queryEntity = "query"
+
+// runQuery runs a PromQL query the way the data collection does, through the same connection, routes and query parameters,
+// and prints its result.
+func runQuery(args []string) {
+	fs := newFlagSet("query", "[flags] <promql>", "Run a PromQL query through the connection the data collection uses, with its authentication, routes, failover and\nquery parameters, and print the result. By default the query is an instant query at the current time of the\ncollection; with -range, it is a range query over the collection interval.")
+	flags := config.AddFlags(fs)
+	rangeQuery := fs.Bool("range", false, "Query the last collection interval, at the sample rate, instead of the current time")
+	fs.Parse(args)
+	query := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if query == "" {
+		fmt.Fprintf(fs.Output(), "No query given\n\n")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	initParameters(flags, false, io.Discard)
+	setCurrentTime(params)
+	ctx, stop := runContext(params)
+	defer stop()
+	connect(ctx, params)
+
+	var r v1.Range
+	if *rangeQuery {
+		r = common.TimeRange(params, 0)
+	} else {
+		r = v1.Range{Start: *params.CurrentTime, End: *params.CurrentTime}
+	}
+	value, err := common.Query(ctx, params, query, r, queryEntity, queryEntity)
+	if err == nil {
+		err = printResult(os.Stdout, value)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Query failed: %s\n", err.Error())
+		params.ErrorLogger.Printf(msg)
+		log.Fatalf("%s %s", "[ERROR]", msg)
+	}
+}
+
+// printResult prints the result of a query: the series of a range query with their samples, the series of an instant query
+// with their sample, or the value of a scalar or string.
+func printResult(w io.Writer, value model.Value) error {
+	switch v := value.(type) {
+	case model.Matrix:
+		for _, s := range v {
+			fmt.Fprintln(w, s.Metric.String())
+			for _, p := range s.Values {
+				fmt.Fprintf(w, "  %s %s\n", common.FormatTime(p.Timestamp), p.Value)
+			}
+		}
+		fmt.Fprintf(w, "[INFO] %d series\n", len(v))
+	case model.Vector:
+		for _, s := range v {
+			fmt.Fprintln(w, s.Metric.String())
+			fmt.Fprintf(w, "  %s %s\n", common.FormatTime(s.Timestamp), s.Value)
+		}
+		fmt.Fprintf(w, "[INFO] %d series\n", len(v))
+	case *model.Scalar:
+		fmt.Fprintln(w, "scalar")
+		fmt.Fprintf(w, "  %s %s\n", common.FormatTime(v.Timestamp), v.Value)
+	case *model.String:
+		fmt.Fprintln(w, "string")
+		fmt.Fprintf(w, "  %s %q\n", common.FormatTime(v.Timestamp), v.Value)
+	default:
+		return fmt.Errorf("the query returned a %T, which cannot be printed", value)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestRunQueryScalar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/status/buildinfo":
+			io.WriteString(w, `{"status":"success","data":{"version":"2.45.0"}}`)
+		case "/api/v1/query":
+			r.ParseForm()
+			if q := r.Form.Get("query"); q != "1+1" {
+				t.Errorf("query %q sent, want 1+1", q)
+			}
+			io.WriteString(w, `{"status":"success","data":{"resultType":"scalar","result":[1704164400,"2"]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// no config file is found in the working directory, the settings are the flags
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+	runQuery([]string{"-url", srv.URL, "-clusterName", "test", "1+1"})
+	os.Stdout = stdout
+	w.Close()
+
+	if got, want := <-out, "scalar\n  2024-01-02T03:00:00Z 2\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("printed %q, want it to end with %q", got, want)
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		name  string
+		value model.Value
+		want  string
+		err   string
+	}{
+		{"matrix", model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1704164400000, Value: 1}, {Timestamp: 1704164700000, Value: 2}}}},
+			"{job=\"a\"}\n  2024-01-02T03:00:00Z 1\n  2024-01-02T03:05:00Z 2\n[INFO] 1 series\n", ""},
+		{"vector", model.Vector{{Metric: model.Metric{"job": "a"}, Timestamp: 1704164400000, Value: 1.5}},
+			"{job=\"a\"}\n  2024-01-02T03:00:00Z 1.5\n[INFO] 1 series\n", ""},
+		{"empty vector", model.Vector{}, "[INFO] 0 series\n", ""},
+		{"scalar", &model.Scalar{Timestamp: 1704164400000, Value: 2}, "scalar\n  2024-01-02T03:00:00Z 2\n", ""},
+		{"string", &model.String{Timestamp: 1704164400000, Value: "up"}, "string\n  2024-01-02T03:00:00Z \"up\"\n", ""},
+		{"none", nil, "", "the query returned a <nil>, which cannot be printed"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := printResult(&buf, test.value)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || buf.String() != test.want {
+			t.Errorf("%s: printed %q, %v, want %q", test.name, buf.String(), err, test.want)
+		}
+	}
+}
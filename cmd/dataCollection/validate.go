@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/config"
+)
+
+// runValidateConfig checks the settings as the data collection would, without connecting to Prometheus.
+func runValidateConfig(args []string) {
+	fs := newFlagSet("validate-config", "[flags]", "Check the settings from the environment, the config file and the command line, as the data collection would, without\nconnecting to Prometheus. The invalid settings are all reported and the command exits with an error.")
+	flags := config.AddFlags(fs)
+	printConfig := fs.Bool("print-config", false, "Print the effective value of every setting and where it comes from, with the secrets redacted")
+	fs.Parse(args)
+
+	initParameters(flags, *printConfig, io.Discard)
+	fmt.Println("[INFO] The configuration is valid")
+}
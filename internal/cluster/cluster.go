@@ -2,9 +2,9 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
 	"github.com/prometheus/common/model"
@@ -133,166 +133,162 @@ func writeAttributes(args *common.Parameters) {
 }
 
 // Metrics a global func for collecting node level metrics in prometheus
-func Metrics(args *common.Parameters) {
+func Metrics(ctx context.Context, args *common.Parameters) {
 	//Setup variables used in the code.
-	var historyInterval time.Duration
-	historyInterval = 0
 	var query, requestsLabel string
-	var result model.Value
-	var err error
-
-	//Start and end time + the prometheus address used for querying
-	range5Min := common.TimeRange(args, historyInterval)
-
-	query = `sum(kube_pod_container_resource_limits) by (resource)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_resource_limits_cpu_cores*1000)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=cpuLimit query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cpuLimit query=" + query + " message=" + err.Error())
-		} else {
-			getClusterMetric(result, "cpuLimit")
-		}
 
-		query = `sum(kube_pod_container_resource_limits_memory_bytes/1024/1024)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=memLimit query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=memLimit query=" + query + " message=" + err.Error())
-		} else {
-			getClusterMetric(result, "memLimit")
-		}
-	} else {
-		getClusterMetric(result, "limits")
+	//The current time + the prometheus address used for querying, the limits and requests only need the latest values
+	configRange := common.ConfigRange(args)
+
+	//Limits and requests queries
+	queries := common.NewQueries(ctx, args, configRange, entityKind)
+	addClusterMetric := func(metric, query, metricName, field string) {
+		queries.AddIfAvailable(metric, query, metricName, func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
+			} else {
+				getClusterMetric(result, field)
+			}
+		})
 	}
 
-	query = `sum(kube_pod_container_resource_requests) by (resource)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_resource_requests_cpu_cores*1000)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=cpuRequest query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cpuRequest query=" + query + " message=" + err.Error())
+	//kube-state-metrics 1.x reports the limits and requests in a metric per resource.
+	if common.FamilyAvailable(args, "the cluster limits", "kube_pod_container_resource_limits", "kube_pod_container_resource_limits_cpu_cores", "kube_pod_container_resource_limits_memory_bytes") {
+		if common.Available(args, "kube_pod_container_resource_limits") {
+			addClusterMetric("kube_pod_container_resource_limits", `sum(kube_pod_container_resource_limits) by (resource)`, "limits", "limits")
 		} else {
-			getClusterMetric(result, "cpuRequest")
+			addClusterMetric("kube_pod_container_resource_limits_cpu_cores", `sum(kube_pod_container_resource_limits_cpu_cores*1000)`, "cpuLimit", "cpuLimit")
+			addClusterMetric("kube_pod_container_resource_limits_memory_bytes", `sum(kube_pod_container_resource_limits_memory_bytes/1024/1024)`, "memLimit", "memLimit")
 		}
+	}
 
-		query = `sum(kube_pod_container_resource_requests_memory_bytes/1024/1024)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=memRequest query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=memRequest query=" + query + " message=" + err.Error())
+	hasRequests := common.FamilyAvailable(args, "the cluster requests", "kube_pod_container_resource_requests", "kube_pod_container_resource_requests_cpu_cores", "kube_pod_container_resource_requests_memory_bytes")
+	if hasRequests {
+		if common.Available(args, "kube_pod_container_resource_requests") {
+			addClusterMetric("kube_pod_container_resource_requests", `sum(kube_pod_container_resource_requests) by (resource)`, "requests", "requests")
+			requestsLabel = "unified"
 		} else {
-			getClusterMetric(result, "memRequest")
+			addClusterMetric("kube_pod_container_resource_requests_cpu_cores", `sum(kube_pod_container_resource_requests_cpu_cores*1000)`, "cpuRequest", "cpuRequest")
+			addClusterMetric("kube_pod_container_resource_requests_memory_bytes", `sum(kube_pod_container_resource_requests_memory_bytes/1024/1024)`, "memRequest", "memRequest")
 		}
-	} else {
-		getClusterMetric(result, "requests")
-		requestsLabel = "unified"
 	}
 
+	queries.Run()
+
+	//The run is being stopped, do not write the files out of partially collected data.
+	if ctx.Err() != nil {
+		return
+	}
 	writeAttributes(args)
 	writeConfig(args)
 
 	var metricField []model.LabelName
+	//Workloads
+	workloads := common.NewPool(args)
 
 	if requestsLabel == "unified" {
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="cpu"}) by (node))`
-		common.GetWorkload("cpu_requests", "CpuRequests", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "cpu_requests", "CpuRequests", query, metricField, args, entityKind)
 
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="cpu"}) by (node) / sum(kube_node_status_capacity{resource="cpu"}) by (node)) * 100`
-		common.GetWorkload("cpu_reservation_percent", "CpuReservationPercent", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "cpu_reservation_percent", "CpuReservationPercent", query, metricField, args, entityKind)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="memory"}/1024/1024) by (node))`
-		common.GetWorkload("memory_requests", "MemoryRequests", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "memory_requests", "MemoryRequests", query, metricField, args, entityKind)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="memory"}/1024/1024) by (node) / sum(kube_node_status_capacity{resource="memory"}/1024/1024) by (node)) * 100`
-		common.GetWorkload("memory_reservation_percent", "MemoryReservationPercent", query, metricField, args, entityKind)
-	} else {
+		workloads.GetWorkload(ctx, "memory_reservation_percent", "MemoryReservationPercent", query, metricField, args, entityKind)
+	} else if hasRequests {
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests_cpu_cores) by (node))`
-		common.GetWorkload("cpu_requests", "CpuRequests", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "cpu_requests", "CpuRequests", query, metricField, args, entityKind)
 
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests_cpu_cores) by (node) / sum(kube_node_status_capacity_cpu_cores) by (node)) * 100`
-		common.GetWorkload("cpu_reservation_percent", "CpuReservationPercent", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "cpu_reservation_percent", "CpuReservationPercent", query, metricField, args, entityKind)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024) by (node))`
-		common.GetWorkload("memory_requests", "MemoryRequests", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "memory_requests", "MemoryRequests", query, metricField, args, entityKind)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024) by (node) / sum(kube_node_status_capacity_memory_bytes/1024/1024) by (node)) * 100`
-		common.GetWorkload("memory_reservation_percent", "MemoryReservationPercent", query, metricField, args, entityKind)
+		workloads.GetWorkload(ctx, "memory_reservation_percent", "MemoryReservationPercent", query, metricField, args, entityKind)
+	}
+
+	//The other workloads are those of Node Exporter.
+	if !common.FamilyAvailable(args, "the Node Exporter metrics", "node_cpu_seconds_total") {
+		workloads.Wait()
+		return
 	}
 
 	//For cluster we don't have to check instance field and convert to pod_ip as we aren't looking to map to the node names but rather just get the avg for nodes. So we can use just instance field in all cases.
 	//Query and store prometheus total cpu uptime in seconds
 	query = `avg(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100)`
-	common.GetWorkload("cpu_utilization", "CpuUtilization", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "cpu_utilization", "CpuUtilization", query, metricField, args, entityKind)
 
 	//Query and store prometheus node memory total in bytes
 	query = `avg(node_memory_MemTotal_bytes - node_memory_MemFree_bytes)`
-	common.GetWorkload("memory_raw_bytes", "MemoryBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "memory_raw_bytes", "MemoryBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus node memory total free in bytes
 	query = `avg(node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes))`
-	common.GetWorkload("memory_actual_workload", "MemoryActualWorkload", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "memory_actual_workload", "MemoryActualWorkload", query, metricField, args, entityKind)
 
 	//Query and store prometheus node disk write in bytes
 	query = `avg(sum(irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("disk_write_bytes", "DiskWriteBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "disk_write_bytes", "DiskWriteBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus node disk read in bytes
 	query = `avg(sum(irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("disk_read_bytes", "DiskReadBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "disk_read_bytes", "DiskReadBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus total disk read uptime as a percentage
 	query = `avg(sum(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("disk_read_ops", "DiskReadOps", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "disk_read_ops", "DiskReadOps", query, metricField, args, entityKind)
 
 	//Query and store prometheus total disk write uptime as a percentage
 	query = `avg(sum(irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("disk_write_ops", "DiskWriteOps", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "disk_write_ops", "DiskWriteOps", query, metricField, args, entityKind)
 
 	//Total disk values
 	//Query and store prometheus node disk read in bytes
 	query = `avg(sum(irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("disk_total_bytes", "DiskTotalBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "disk_total_bytes", "DiskTotalBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus total disk read uptime as a percentage
 	query = `avg(sum((irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("disk_total_ops", "DiskTotalOps", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "disk_total_ops", "DiskTotalOps", query, metricField, args, entityKind)
 
 	//Query and store prometheus node received network data in bytes
 	query = `avg(sum(irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("net_received_bytes", "NetReceivedBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "net_received_bytes", "NetReceivedBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus recieved network data in packets
 	query = `avg(sum(irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("net_received_packets", "NetReceivedPackets", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "net_received_packets", "NetReceivedPackets", query, metricField, args, entityKind)
 
 	//Query and store prometheus total transmitted network data in bytes
 	query = `avg(sum(irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("net_sent_bytes", "NetSentBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "net_sent_bytes", "NetSentBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus total transmitted network data in packets
 	query = `avg(sum(irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("net_sent_packets", "NetSentPackets", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "net_sent_packets", "NetSentPackets", query, metricField, args, entityKind)
 
 	//Total values network
 	//Query and store prometheus total network data in bytes
 	query = `avg(sum(irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("net_total_bytes", "NetTotalBytes", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "net_total_bytes", "NetTotalBytes", query, metricField, args, entityKind)
 
 	//Query and store prometheus total network data in packets
 	query = `avg(sum(irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])) by (instance))`
-	common.GetWorkload("net_total_packets", "NetTotalPackets", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "net_total_packets", "NetTotalPackets", query, metricField, args, entityKind)
 
+	workloads.Wait()
 }
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/common/config"
+)
+
+// newAuthRoundTripper wraps the round tripper with the configured authentication: SigV4 signing, OAuth2, basic auth or the bearer
+// token file. Only one of them can be configured, the settings are checked up front. The extra headers are added on top of the
+// authentication, so they are signed as well.
+func newAuthRoundTripper(args *Parameters, roundTripper http.RoundTripper) (http.RoundTripper, error) {
+	var err error
+	switch {
+	case args.SigV4Region != "":
+		if roundTripper, err = newSigV4RoundTripper(args, roundTripper); err != nil {
+			return nil, err
+		}
+	case args.OAuth2TokenURL != "":
+		if roundTripper, err = newOAuth2RoundTripper(args, roundTripper); err != nil {
+			return nil, err
+		}
+	case args.BasicAuthUsername != "":
+		if args.BasicAuthPasswordFile != "" {
+			if _, err := os.Stat(args.BasicAuthPasswordFile); err != nil {
+				return nil, fmt.Errorf("cannot use basic auth password file: %w", err)
+			}
+		}
+		roundTripper = config.NewBasicAuthRoundTripper(args.BasicAuthUsername, config.Secret(args.BasicAuthPassword), args.BasicAuthPasswordFile, roundTripper)
+	case args.BasicAuthPassword != "" || args.BasicAuthPasswordFile != "":
+		return nil, errors.New("basic auth password is set without a username")
+	case args.OAuthTokenPath != "":
+		roundTripper = config.NewAuthorizationCredentialsFileRoundTripper("Bearer", args.OAuthTokenPath, roundTripper)
+	}
+	if len(args.Headers) > 0 {
+		roundTripper = &headersRoundTripper{headers: args.Headers, next: roundTripper}
+	}
+	return roundTripper, nil
+}
+
+// headersRoundTripper adds extra headers, such as the X-Scope-OrgID tenant header of Mimir and Cortex, to every request.
+type headersRoundTripper struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (rt *headersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	for name, value := range rt.headers {
+		req.Header.Set(name, value)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// ParseHeaders parses the extra headers setting, a comma separated list of Name=value pairs.
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("header %q is not in Name=value format", pair)
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
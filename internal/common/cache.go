@@ -0,0 +1,179 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// DefaultCacheDir is where the query cache is stored when the cache directory is not set in the configuration.
+const DefaultCacheDir = "./data/cache"
+
+// servedByCache is recorded as the endpoint of the queries answered from the cache.
+const servedByCache = "cache"
+
+// queryCache is an on-disk cache of query results, keyed by the query, its range, the source it is sent to and who it is sent
+// as, so identical queries within a run and reruns of the same collection window are not sent to Prometheus again. Entries
+// expire after the TTL.
+type queryCache struct {
+	dir string
+	ttl time.Duration
+	// params are the extra query parameters, which may change the results
+	params string
+	// identity is the hash of the extra headers and the authentication, see cacheIdentity
+	identity string
+}
+
+// cacheEntry is the content of a cache file; the key fields are kept to ease troubleshooting.
+type cacheEntry struct {
+	Source string       `json:"source"`
+	Query  string       `json:"query"`
+	Start  string       `json:"start"`
+	End    string       `json:"end"`
+	Step   string       `json:"step"`
+	Result model.Matrix `json:"result"`
+}
+
+// newQueryCache creates the cache directory and removes the expired entries; the cache is disabled (nil) if the TTL is not set.
+func newQueryCache(args *Parameters) (*queryCache, error) {
+	if args.CacheTTL <= 0 {
+		return nil, nil
+	}
+	c := &queryCache{dir: args.CacheDir, ttl: args.CacheTTL, params: args.QueryParams.Encode(), identity: cacheIdentity(args)}
+	if c.dir == "" {
+		c.dir = DefaultCacheDir
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create query cache directory: %w", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && time.Since(fi.ModTime()) > c.ttl {
+			_ = os.Remove(f)
+		}
+	}
+	return c, nil
+}
+
+// source identifies the Prometheus the query is sent to, by the URLs of its endpoints.
+func (c *queryCache) source(set *endpointSet) string {
+	var urls []string
+	for _, ep := range set.endpoints {
+		urls = append(urls, ep.url)
+	}
+	return strings.Join(urls, ",")
+}
+
+// cacheIdentity identifies who the queries are sent as: the extra headers, such as the X-Scope-OrgID tenant of Mimir and Cortex,
+// and the identity of the authentication, as both change the results. The identity is hashed, so no header value or
+// credential ends up in the cache keys.
+func cacheIdentity(args *Parameters) string {
+	var key []string
+	var names []string
+	for name := range args.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key = append(key, "header "+http.CanonicalHeaderKey(name)+"="+args.Headers[name])
+	}
+	switch {
+	case args.SigV4Region != "":
+		key = append(key, "sigv4 "+args.SigV4Region+" "+args.SigV4Service+" "+args.SigV4AccessKey)
+	case args.OAuth2TokenURL != "":
+		key = append(key, "oauth2 "+args.OAuth2TokenURL+" "+args.OAuth2ClientID+" "+args.OAuth2Scopes)
+	case args.BasicAuthUsername != "":
+		key = append(key, "basic "+args.BasicAuthUsername)
+	case args.OAuthTokenPath != "":
+		key = append(key, "bearer "+args.OAuthTokenPath)
+	}
+	if len(key) == 0 {
+		return ""
+	}
+	h := sha256.Sum256([]byte(strings.Join(key, "\n")))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *queryCache) file(source, query string, r v1.Range) string {
+	key := []string{source, query, Format(&r.Start), Format(&r.End), r.Step.String()}
+	if c.params != "" {
+		key = append(key, c.params)
+	}
+	if c.identity != "" {
+		key = append(key, c.identity)
+	}
+	h := sha256.Sum256([]byte(strings.Join(key, "\n")))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:])+".json")
+}
+
+// get returns the cached result of the query, or nil if it is not cached or expired.
+func (c *queryCache) get(set *endpointSet, query string, r v1.Range) model.Value {
+	if c == nil {
+		return nil
+	}
+	f := c.file(c.source(set), query, r)
+	if fi, err := os.Stat(f); err != nil || time.Since(fi.ModTime()) > c.ttl {
+		return nil
+	}
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err = json.Unmarshal(b, &entry); err != nil || entry.Query != query {
+		return nil
+	}
+	if entry.Result == nil {
+		entry.Result = model.Matrix{}
+	}
+	if r.Step <= 0 {
+		return matrixToVector(entry.Result)
+	}
+	return entry.Result
+}
+
+// put caches the result of the query; only series are cached, the results of instant queries (vectors) as matrices.
+func (c *queryCache) put(args *Parameters, set *endpointSet, query string, r v1.Range, value model.Value) {
+	mat, ok := value.(model.Matrix)
+	if vec, isVector := value.(model.Vector); isVector {
+		mat, ok = vectorToMatrix(vec), true
+	}
+	if c == nil || !ok {
+		return
+	}
+	source := c.source(set)
+	entry := cacheEntry{Source: source, Query: query, Start: Format(&r.Start), End: Format(&r.End), Step: r.Step.String(), Result: mat}
+	f := c.file(source, query, r)
+	b, err := json.Marshal(entry)
+	if err == nil {
+		// write then rename, so a concurrent or interrupted run never reads a partial entry
+		var tmp *os.File
+		if tmp, err = os.CreateTemp(c.dir, "entry-*.tmp"); err == nil {
+			_, err = tmp.Write(b)
+			if cerr := tmp.Close(); err == nil {
+				err = cerr
+			}
+			if err == nil {
+				err = os.Rename(tmp.Name(), f)
+			}
+			if err != nil {
+				_ = os.Remove(tmp.Name())
+			}
+		}
+	}
+	if err != nil && args.Debug {
+		msg := "message=cannot cache query result: " + err.Error()
+		args.DebugLogger.Println(msg)
+		fmt.Println("[DEBUG] " + msg)
+	}
+}
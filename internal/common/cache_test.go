@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func TestCacheIdentity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Parameters
+		same bool
+	}{
+		{"none", Parameters{}, Parameters{}, true},
+		{"same tenant", Parameters{Headers: map[string]string{"X-Scope-OrgID": "a"}}, Parameters{Headers: map[string]string{"x-scope-orgid": "a"}}, true},
+		{"tenants", Parameters{Headers: map[string]string{"X-Scope-OrgID": "a"}}, Parameters{Headers: map[string]string{"X-Scope-OrgID": "b"}}, false},
+		{"tenant and none", Parameters{Headers: map[string]string{"X-Scope-OrgID": "a"}}, Parameters{}, false},
+		{"users", Parameters{BasicAuthUsername: "a", BasicAuthPassword: "p"}, Parameters{BasicAuthUsername: "b", BasicAuthPassword: "p"}, false},
+		{"passwords", Parameters{BasicAuthUsername: "a", BasicAuthPassword: "p"}, Parameters{BasicAuthUsername: "a", BasicAuthPassword: "q"}, true},
+		{"access keys", Parameters{SigV4Region: "us-east-1", SigV4AccessKey: "a"}, Parameters{SigV4Region: "us-east-1", SigV4AccessKey: "b"}, false},
+		{"regions", Parameters{SigV4Region: "us-east-1"}, Parameters{SigV4Region: "eu-west-1"}, false},
+		{"client ids", Parameters{OAuth2TokenURL: "http://idp/token", OAuth2ClientID: "a"}, Parameters{OAuth2TokenURL: "http://idp/token", OAuth2ClientID: "b"}, false},
+		{"tokens", Parameters{OAuthTokenPath: "/a/token"}, Parameters{OAuthTokenPath: "/b/token"}, false},
+		{"user and token", Parameters{BasicAuthUsername: "a"}, Parameters{OAuthTokenPath: "a"}, false},
+	}
+	for _, test := range tests {
+		a, b := cacheIdentity(&test.a), cacheIdentity(&test.b)
+		if (a == b) != test.same {
+			t.Errorf("%s: identities %q and %q, want same %t", test.name, a, b, test.same)
+		}
+		// a hash, never the header values or credentials themselves
+		if _, err := hex.DecodeString(a); err != nil || (a != "" && len(a) != 2*sha256.Size) {
+			t.Errorf("%s: identity %q is not a hash", test.name, a)
+		}
+	}
+}
+
+func TestCacheTenants(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"tenant":%q},"values":[[1704164400,"1"]]}]}}`, r.Header.Get("X-Scope-OrgID"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	query := func(tenant string) string {
+		args := newTestParameters(t, srv.URL)
+		args.Headers = map[string]string{"X-Scope-OrgID": tenant}
+		args.CacheDir, args.CacheTTL = dir, time.Hour
+		if err := InitPromApi(args); err != nil {
+			t.Fatal(err)
+		}
+		value, err := MetricCollect(context.Background(), args, "up", TimeRange(args, 0), "test", "up")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(value.(model.Matrix)[0].Metric["tenant"])
+	}
+
+	for _, tenant := range []string{"tenant-a", "tenant-b", "tenant-a", "tenant-b"} {
+		if got := query(tenant); got != tenant {
+			t.Errorf("tenant %s got the result of %s", tenant, got)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want one per tenant", requests)
+	}
+
+	// one entry per tenant, which does not name it
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("%d cache files, want 2", len(files))
+	}
+	for _, f := range files {
+		if strings.Contains(filepath.Base(f), "tenant") {
+			t.Errorf("cache file %s names the tenant", f)
+		}
+	}
+}
+
+func TestCacheInstant(t *testing.T) {
+	args := &Parameters{CacheTTL: time.Hour, CacheDir: t.TempDir()}
+	c, err := newQueryCache(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &endpointSet{endpoints: []*endpoint{{url: "http://prometheus:9090"}}}
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	instant := v1.Range{Start: now, End: now}
+	vec := model.Vector{{Metric: model.Metric{"job": "a"}, Timestamp: model.TimeFromUnix(now.Unix()), Value: 1}}
+	c.put(args, set, "up", instant, vec)
+	if got := c.get(set, "up", instant); !reflect.DeepEqual(got, vec) {
+		t.Errorf("cached instant result %v, want %v", got, vec)
+	}
+	// the scalars are not series, they are not cached
+	c.put(args, set, "1", instant, &model.Scalar{Timestamp: model.TimeFromUnix(now.Unix()), Value: 1})
+	if got := c.get(set, "1", instant); got != nil {
+		t.Errorf("cached scalar %v", got)
+	}
+}
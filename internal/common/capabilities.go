@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// ErrNotAvailable is the error of the queries which are not sent, as Prometheus does not have their metric.
+var ErrNotAvailable = errors.New("metric not available in Prometheus")
+
+// capabilitiesEntity is the entity kind the discovery queries are logged with.
+const capabilitiesEntity = "prometheus"
+
+// capabilities holds the metric names known to each Prometheus (the default endpoints and the named sources), discovered
+// once before the collection, so the collectors pick the query variants matching the versions of the exporters up front and
+// skip the metric families which are missing, instead of trying the queries and falling back on errors.
+type capabilities struct {
+	// metrics holds the metric names of each endpoint set; a set is missing if its discovery failed
+	metrics map[*endpointSet]map[string]bool
+	mu      sync.Mutex
+	// skipped holds the families already reported as skipped
+	skipped map[string]bool
+	// labelsErr is the error of the probe of the cAdvisor labels
+	labelsErr error
+}
+
+// DiscoverCapabilities fetches the metric names of every Prometheus, over the collection window, and probes the labels of
+// cAdvisor: older Kubernetes versions label the containers with pod_name and container_name, which sets the label suffix.
+// A Prometheus whose metric names cannot be fetched is assumed to have all of them, so the queries are tried as before.
+func DiscoverCapabilities(ctx context.Context, args *Parameters) {
+	if args.endpoints == nil {
+		return
+	}
+	caps := &capabilities{metrics: map[*endpointSet]map[string]bool{}, skipped: map[string]bool{}}
+	// the whole collection window, from the start of the oldest history interval
+	var oldest time.Duration
+	if *args.History > 1 {
+		oldest = time.Duration(*args.History - 1)
+	}
+	start := TimeRange(args, oldest).Start
+	end := *args.CurrentTime
+	for _, set := range allEndpointSets(args) {
+		var values []string
+		err := withRetries(ctx, args, capabilitiesEntity, "__name__", func() error {
+			return discoveryCall(ctx, args, set, func(ctx context.Context, api v1.API) error {
+				lv, _, err := api.LabelValues(ctx, "__name__", nil, start, end)
+				values = values[:0]
+				for _, v := range lv {
+					values = append(values, string(v))
+				}
+				return err
+			})
+		})
+		if err != nil {
+			msg := "message=cannot discover the metric names, all metrics are assumed available: " + err.Error()
+			if set.name != "" {
+				msg = "source=" + set.name + " " + msg
+			}
+			args.WarnLogger.Println(msg)
+			fmt.Println("[WARNING] " + msg)
+			continue
+		}
+		names := make(map[string]bool, len(values))
+		for _, v := range values {
+			names[v] = true
+		}
+		caps.metrics[set] = names
+		msg := fmt.Sprintf("message=discovered %d metric names", len(names))
+		if set.name != "" {
+			msg = "source=" + set.name + " " + msg
+		}
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	args.capabilities = caps
+
+	const cAdvisorMetric = "container_spec_memory_limit_bytes"
+	set := endpointsFor(args, cAdvisorMetric)
+	// the labels are probed even if the metric names could not be discovered, as the collectors do not guess them
+	if args.LabelSuffix != "" || (caps.metrics[set] != nil && !caps.metrics[set][cAdvisorMetric]) {
+		return
+	}
+	var labels []string
+	err := withRetries(ctx, args, capabilitiesEntity, cAdvisorMetric, func() error {
+		return discoveryCall(ctx, args, set, func(ctx context.Context, api v1.API) (err error) {
+			labels, _, err = api.LabelNames(ctx, []string{cAdvisorMetric}, start, end)
+			return
+		})
+	})
+	if err != nil {
+		caps.labelsErr = err
+		msg := "metric=" + cAdvisorMetric + " message=cannot discover the labels of cAdvisor: " + err.Error()
+		args.WarnLogger.Println(msg)
+		fmt.Println("[WARNING] " + msg)
+		return
+	}
+	sort.Strings(labels)
+	hasLabel := func(name string) bool {
+		i := sort.SearchStrings(labels, name)
+		return i < len(labels) && labels[i] == name
+	}
+	if !hasLabel("pod") && hasLabel("pod_name") {
+		args.LabelSuffix = "_name"
+		msg := "metric=" + cAdvisorMetric + " message=cAdvisor labels the containers with pod_name and container_name"
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+}
+
+// discoveryCall calls the API of the active endpoint of the set, within the limits and the timeout of the queries.
+func discoveryCall(ctx context.Context, args *Parameters, set *endpointSet, f func(context.Context, v1.API) error) error {
+	release, err := args.throttle.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, durationOrDefault(args.QueryTimeout, DefaultQueryTimeout))
+	defer cancel()
+	return f(ctx, set.active().api)
+}
+
+// LabelsError returns the error of the probe of the cAdvisor labels, nil if they were discovered or did not need to be.
+func LabelsError(args *Parameters) error {
+	if args.capabilities == nil {
+		return nil
+	}
+	return args.capabilities.labelsErr
+}
+
+// Available reports whether the Prometheus the metric is routed to has it. Metrics are assumed available if the metric names
+// were not discovered, so their queries are tried.
+func Available(args *Parameters, metric string) bool {
+	if args.capabilities == nil {
+		return true
+	}
+	names, ok := args.capabilities.metrics[endpointsFor(args, metric)]
+	return !ok || names[metric]
+}
+
+// Discovered reports whether the metric names of the Prometheus the metric is routed to were discovered, so Available tells
+// whether it has the metric rather than assuming it.
+func Discovered(args *Parameters, metric string) bool {
+	if args.capabilities == nil {
+		return false
+	}
+	_, ok := args.capabilities.metrics[endpointsFor(args, metric)]
+	return ok
+}
+
+// FamilyAvailable reports whether any of the metrics of the family is available. If none is, the family is reported as skipped,
+// once per run, so the collectors can skip its queries without a warning for each of them.
+func FamilyAvailable(args *Parameters, family string, metrics ...string) bool {
+	if args.capabilities == nil {
+		return true
+	}
+	for _, metric := range metrics {
+		if Available(args, metric) {
+			return true
+		}
+	}
+	caps := args.capabilities
+	caps.mu.Lock()
+	defer caps.mu.Unlock()
+	if !caps.skipped[family] {
+		caps.skipped[family] = true
+		msg := fmt.Sprintf("message=skipping %s, Prometheus has none of %s", family, strings.Join(metrics, ", "))
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	return false
+}
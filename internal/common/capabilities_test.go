@@ -0,0 +1,193 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// namesServer serves the metric names and the labels of cAdvisor as the Prometheus API does, or a bad_data error if the
+// names are nil.
+func namesServer(t *testing.T, names, labels []string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var data []string
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			data = names
+		case "/api/v1/labels":
+			data = labels
+		}
+		if data == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"not supported"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscoverCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		names        []string
+		labels       []string
+		discovered   bool
+		available    []string
+		missing      []string
+		labelSuffix  string
+		labelsErr    bool
+		wantInfoLogs []string
+	}{
+		{
+			name:       "kube-state-metrics 2",
+			names:      []string{"container_spec_memory_limit_bytes", "kube_pod_container_resource_limits", "kube_node_status_capacity"},
+			labels:     []string{"__name__", "container", "namespace", "pod"},
+			discovered: true,
+			available:  []string{"kube_pod_container_resource_limits", "kube_node_status_capacity"},
+			missing:    []string{"kube_pod_container_resource_limits_cpu_cores", "node_cpu_seconds_total"},
+			wantInfoLogs: []string{
+				"message=discovered 3 metric names",
+			},
+		},
+		{
+			name:        "older cAdvisor labels",
+			names:       []string{"container_spec_memory_limit_bytes", "kube_pod_container_resource_limits_cpu_cores"},
+			labels:      []string{"__name__", "container_name", "namespace", "pod_name"},
+			discovered:  true,
+			available:   []string{"kube_pod_container_resource_limits_cpu_cores"},
+			missing:     []string{"kube_pod_container_resource_limits"},
+			labelSuffix: "_name",
+			wantInfoLogs: []string{
+				"message=discovered 2 metric names",
+				"message=cAdvisor labels the containers with pod_name and container_name",
+			},
+		},
+		{
+			name:       "without cAdvisor",
+			names:      []string{"kube_pod_info"},
+			discovered: true,
+			available:  []string{"kube_pod_info"},
+			missing:    []string{"container_spec_memory_limit_bytes"},
+		},
+		{
+			name:       "labels not discovered",
+			names:      []string{"container_spec_memory_limit_bytes"},
+			discovered: true,
+			available:  []string{"container_spec_memory_limit_bytes"},
+			labelsErr:  true,
+		},
+		{
+			name:      "names not discovered",
+			labels:    []string{"__name__", "container", "namespace", "pod"},
+			available: []string{"kube_pod_container_resource_limits", "node_cpu_seconds_total"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := newTestParameters(t, namesServer(t, tt.names, tt.labels).URL)
+			var infos bytes.Buffer
+			args.InfoLogger = log.New(&infos, "", 0)
+			DiscoverCapabilities(context.Background(), args)
+			if args.capabilities == nil {
+				t.Fatal("no capabilities discovered")
+			}
+			for _, metric := range tt.available {
+				if !Available(args, metric) {
+					t.Errorf("%s not available", metric)
+				}
+			}
+			for _, metric := range tt.missing {
+				if Available(args, metric) {
+					t.Errorf("%s available", metric)
+				}
+			}
+			if d := Discovered(args, "kube_pod_info"); d != tt.discovered {
+				t.Errorf("Discovered() = %v, want %v", d, tt.discovered)
+			}
+			if err := LabelsError(args); (err != nil) != tt.labelsErr {
+				t.Errorf("LabelsError() = %v", err)
+			}
+			if args.LabelSuffix != tt.labelSuffix {
+				t.Errorf("label suffix %q, want %q", args.LabelSuffix, tt.labelSuffix)
+			}
+			for _, want := range tt.wantInfoLogs {
+				if !strings.Contains(infos.String(), want) {
+					t.Errorf("info logs %q, want %q", infos.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestAvailableSources(t *testing.T) {
+	ksm := namesServer(t, []string{"kube_pod_info", "kube_pod_container_resource_limits"}, nil)
+	// the names of the node exporter source cannot be discovered
+	nodeExporter := namesServer(t, nil, nil)
+	args := newTestParameters(t, namesServer(t, []string{"container_cpu_usage_seconds_total"}, []string{"pod"}).URL)
+	args.PromSources = map[string][]string{"ksm": {ksm.URL}, "nodes": {nodeExporter.URL}}
+	args.PromRoutes = map[string]string{"kube": "ksm", "node": "nodes"}
+	if err := InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// before the discovery, the metrics are assumed available
+	if !Available(args, "kube_pod_info") || Discovered(args, "kube_pod_info") {
+		t.Error("metric not assumed available before the discovery")
+	}
+	DiscoverCapabilities(context.Background(), args)
+	tests := []struct {
+		metric                string
+		available, discovered bool
+	}{
+		{"kube_pod_info", true, true},
+		{"kube_pod_container_resource_limits_cpu_cores", false, true},
+		// the default endpoints have the cAdvisor metrics, not the kube-state-metrics ones
+		{"container_cpu_usage_seconds_total", true, true},
+		{"container_memory_rss", false, true},
+		{"node_cpu_seconds_total", true, false},
+		{"node_memory_MemTotal_bytes", true, false},
+	}
+	for _, tt := range tests {
+		if a := Available(args, tt.metric); a != tt.available {
+			t.Errorf("Available(%s) = %v, want %v", tt.metric, a, tt.available)
+		}
+		if d := Discovered(args, tt.metric); d != tt.discovered {
+			t.Errorf("Discovered(%s) = %v, want %v", tt.metric, d, tt.discovered)
+		}
+	}
+}
+
+func TestFamilyAvailable(t *testing.T) {
+	args := newTestParameters(t, namesServer(t, []string{"kube_pod_container_resource_limits_cpu_cores"}, nil).URL)
+	if !FamilyAvailable(args, "the limits", "kube_pod_container_resource_limits") {
+		t.Error("family skipped before the discovery")
+	}
+	DiscoverCapabilities(context.Background(), args)
+	var infos bytes.Buffer
+	args.InfoLogger = log.New(&infos, "", 0)
+
+	if !FamilyAvailable(args, "the limits", "kube_pod_container_resource_limits", "kube_pod_container_resource_limits_cpu_cores") {
+		t.Error("family skipped with one of its metrics")
+	}
+	for i := 0; i < 3; i++ {
+		if FamilyAvailable(args, "the HPA metrics", "kube_hpa_labels", "kube_horizontalpodautoscaler_labels") {
+			t.Error("family available without its metrics")
+		}
+	}
+	if FamilyAvailable(args, "the Node Exporter metrics", "node_cpu_seconds_total") {
+		t.Error("family available without its metrics")
+	}
+	want := "message=skipping the HPA metrics, Prometheus has none of kube_hpa_labels, kube_horizontalpodautoscaler_labels\n" +
+		"message=skipping the Node Exporter metrics, Prometheus has none of node_cpu_seconds_total\n"
+	if infos.String() != want {
+		t.Errorf("info logs %q, want %q", infos.String(), want)
+	}
+}
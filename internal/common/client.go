@@ -0,0 +1,189 @@
+package common
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/config"
+)
+
+// Default values of the Prometheus HTTP client settings, used when they are not set in the configuration.
+const (
+	DefaultDialTimeout         = 30 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultQueryTimeout        = 2 * time.Minute
+
+	// maxIdleConnsPerHost is the size of the keep-alive connection pool towards Prometheus.
+	maxIdleConnsPerHost = 16
+	keepAlive           = 30 * time.Second
+)
+
+// InitPromApi builds the Prometheus API clients, one per endpoint of the default endpoints and of the routed sources,
+// which are shared by all the queries of the run.
+// It has to be called once, after the parameters are set and before any query is issued.
+func InitPromApi(args *Parameters) (err error) {
+	var roundTripper http.RoundTripper
+	if roundTripper, err = newRoundTripper(args); err != nil {
+		return
+	}
+	urls := args.PromURLs
+	if len(urls) == 0 {
+		urls = []string{*args.PromURL}
+	}
+	if args.endpoints, err = newEndpointSet(urls, roundTripper); err != nil {
+		return
+	}
+	if args.sources, err = newSources(args, roundTripper); err != nil {
+		return
+	}
+	args.httpClient = &http.Client{Transport: roundTripper}
+	if args.cache, err = newQueryCache(args); err == nil {
+		args.served = &servedBy{}
+		args.stats = &queryStats{}
+		args.throttle = newThrottle(args)
+	}
+	return
+}
+
+// ParsePrometheusURL validates the Prometheus base URL, which may include a path prefix such as /prometheus or /api/prom.
+func ParsePrometheusURL(s string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	// the credentials would be logged along with the URL, they have their own settings
+	if u.User != nil {
+		return nil, fmt.Errorf("Prometheus URL %s must not include credentials, use the Prometheus username and password settings", u.Redacted())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Prometheus URL %s must use the http or https scheme", s)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("Prometheus URL %s has no host", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("Prometheus URL %s must not have a query or a fragment", s)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	return u, nil
+}
+
+// promEndpoints returns the endpoints the query is routed to.
+func promEndpoints(args *Parameters, query string) (*endpointSet, error) {
+	if args.endpoints == nil {
+		return nil, errors.New("Prometheus client is not initialized")
+	}
+	return routeQuery(args, query)
+}
+
+// newRoundTripper builds the round tripper chain used to talk to Prometheus: the pooled transport, and the authentication and extra headers on top of it.
+func newRoundTripper(args *Parameters) (http.RoundTripper, error) {
+	tlsClientConfig, err := newTLSConfig(args)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newProxy(args)
+	if err != nil {
+		return nil, err
+	}
+	return newAuthRoundTripper(args, newTransport(args, tlsClientConfig, proxy))
+}
+
+// newTLSConfig builds the TLS configuration of the Prometheus connections: trusted CA, client certificate and key for
+// mutual TLS, server name override, minimum TLS version and certificate verification.
+func newTLSConfig(args *Parameters) (*tls.Config, error) {
+	cfg := &config.TLSConfig{
+		CAFile:             args.CaCertPath,
+		CertFile:           args.ClientCertPath,
+		KeyFile:            args.ClientKeyPath,
+		ServerName:         args.TLSServerName,
+		InsecureSkipVerify: args.InsecureSkipVerify,
+	}
+	for _, f := range []struct{ name, path string }{{"client certificate", args.ClientCertPath}, {"client key", args.ClientKeyPath}} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return nil, fmt.Errorf("cannot use %s file: %w", f.name, err)
+		}
+	}
+	if args.TLSMinVersion != "" {
+		v, ok := config.TLSVersions[strings.ToUpper(args.TLSMinVersion)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %s, valid values are TLS10, TLS11, TLS12 and TLS13", args.TLSMinVersion)
+		}
+		cfg.MinVersion = v
+	}
+	if args.InsecureSkipVerify {
+		msg := "message=verification of the Prometheus certificate is disabled"
+		args.WarnLogger.Println(msg)
+		fmt.Println("[WARNING] " + msg)
+	}
+	return config.NewTLSConfig(cfg)
+}
+
+// newProxy returns the proxy selection of the Prometheus connections: the explicitly configured proxy if any, otherwise
+// the one of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newProxy(args *Parameters) (func(*http.Request) (*url.URL, error), error) {
+	if args.ProxyHost == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	protocol := strings.ToLower(args.ProxyProtocol)
+	switch protocol {
+	case "":
+		protocol = "http"
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy protocol %s, valid values are http, https and socks5", args.ProxyProtocol)
+	}
+	proxyURL := &url.URL{Scheme: protocol, Host: args.ProxyHost}
+	if args.ProxyPort != "" {
+		proxyURL.Host = net.JoinHostPort(args.ProxyHost, args.ProxyPort)
+	}
+	if args.ProxyUser != "" {
+		proxyURL.User = url.UserPassword(args.ProxyUser, args.ProxyPassword)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// newTransport creates the transport which keeps a pool of keep-alive connections to Prometheus.
+// HTTP/2 is attempted even though a custom TLS config is used, and responses are requested gzip-encoded
+// (the transport adds the Accept-Encoding header and decompresses transparently).
+func newTransport(args *Parameters, tlsClientConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           newDialer(args).DialContext,
+		TLSClientConfig:       tlsClientConfig,
+		TLSHandshakeTimeout:   durationOrDefault(args.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		IdleConnTimeout:       durationOrDefault(args.IdleConnTimeout, DefaultIdleConnTimeout),
+		MaxIdleConns:          maxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     true,
+		DisableCompression:    false,
+	}
+}
+
+// newDialer creates the dialer of the Prometheus connections, with the dial timeout and TCP keep-alives.
+func newDialer(args *Parameters) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   durationOrDefault(args.DialTimeout, DefaultDialTimeout),
+		KeepAlive: keepAlive,
+	}
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
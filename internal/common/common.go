@@ -2,20 +2,19 @@ package common
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
-	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 )
 
@@ -31,74 +30,208 @@ type Parameters struct {
 	SampleRateString, NodeGroupList                       string
 	OAuthTokenPath                                        string
 	CaCertPath                                            string
+	ClientCertPath, ClientKeyPath                         string
+	TLSServerName, TLSMinVersion                          string
+	InsecureSkipVerify                                    bool
+	BasicAuthUsername, BasicAuthPassword                  string
+	BasicAuthPasswordFile                                 string
+	Headers                                               map[string]string
+	SigV4Region, SigV4Service                             string
+	SigV4AccessKey, SigV4SecretKey                        string
+	OAuth2TokenURL, OAuth2ClientID, OAuth2Scopes          string
+	OAuth2ClientSecret, OAuth2ClientSecretFile            string
+	ProxyHost, ProxyPort, ProxyProtocol                   string
+	ProxyUser, ProxyPassword                              string
 	Deployments, CronJobs                                 bool
+	DialTimeout, TLSHandshakeTimeout, IdleConnTimeout     time.Duration
+	QueryTimeout, RunTimeout                              time.Duration
+	Retries, Concurrency, MaxInFlight                     int
+	MaxQPS                                                float64
+	CacheDir                                              string
+	CacheTTL                                              time.Duration
+	RemoteRead                                            bool
+	InstantLookback                                       time.Duration
+	QueryParams                                           url.Values
+	Namespaces                                            *NamespaceFilter
+	RetryBackoff, RetryMaxBackoff                         time.Duration
+	PromURLs                                              []string
+	PromSources                                           map[string][]string
+	PromRoutes                                            map[string]string
+	endpoints                                             *endpointSet
+	sources                                               map[string]*endpointSet
+	served                                                *servedBy
+	throttle                                              *throttle
+	cache                                                 *queryCache
+	httpClient                                            *http.Client
+	stats                                                 *queryStats
+	capabilities                                          *capabilities
 }
 
 // Prometheus Objects
 
 // MetricCollect is used to query Prometheus to get data for specific query and return the results to be processed.
-func MetricCollect(args *Parameters, query string, range5m v1.Range) (value model.Value, err error) {
-	var pa v1.API
+// Transient failures are retried as configured, then the query fails over to the next Prometheus endpoint, if any, and ranges
+// too large for Prometheus are split; the entity kind and metric name are used for logging. Results are read from and stored
+// in the query cache, if enabled, and returned as a matrix, see toMatrix. The stats of the query are recorded for the query
+// stats file.
+func MetricCollect(ctx context.Context, args *Parameters, query string, range5m v1.Range, entityKind, metricName string) (value model.Value, err error) {
+	st := newQueryStat(entityKind, metricName, query, queryTypeOf(range5m), range5m)
+	defer func() {
+		st.countResult(value)
+		args.stats.record(st, err)
+	}()
+	if value, err = collect(ctx, args, query, range5m, entityKind, metricName, st); err != nil {
+		return
+	}
+	var mat model.Matrix
+	if mat, err = toMatrix(value); err != nil {
+		return nil, err
+	}
+	value = mat
+	if mat == nil {
+		err = errors.New("no time series data returned")
+	} else if mat.Len() == 0 {
+		err = errors.New("no data returned, value.(model.Matrix) is empty")
+	}
+	return
+}
+
+// Query sends the query the way MetricCollect does, and returns its result as Prometheus does: a vector for an instant query
+// (a range without step), a matrix for a range query, or a scalar or a string.
+func Query(ctx context.Context, args *Parameters, query string, r v1.Range, entityKind, metricName string) (value model.Value, err error) {
+	st := newQueryStat(entityKind, metricName, query, queryTypeOf(r), r)
+	defer func() {
+		st.countResult(value)
+		args.stats.record(st, err)
+	}()
+	return collect(ctx, args, query, r, entityKind, metricName, st)
+}
+
+// queryTypeOf returns the type of query sent for the range: an instant query if it has no step.
+func queryTypeOf(r v1.Range) string {
+	if r.Step <= 0 {
+		return queryTypeInstant
+	}
+	return queryTypeRange
+}
+
+// collect sends the query for MetricCollect and Query: from the cache if it holds the result, to the endpoints the query is
+// routed to otherwise.
+func collect(ctx context.Context, args *Parameters, query string, range5m v1.Range, entityKind, metricName string, st *queryStat) (value model.Value, err error) {
+	var set *endpointSet
+	// the run is being stopped, do not even try
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	if args.Debug {
 		// range5m is always the same, no point in logging
-		msg := fmt.Sprintf("QueryRange: query = %s", query)
+		msg := fmt.Sprintf("QueryRange: entity = %s metric = %s query = %s", entityKind, metricName, query)
+		if range5m.Step <= 0 {
+			msg = fmt.Sprintf("Query: entity = %s metric = %s query = %s", entityKind, metricName, query)
+		}
 		args.DebugLogger.Println(msg)
 		fmt.Println("[DEBUG] " + msg)
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	_ = time.AfterFunc(2*time.Minute, func() { cancel() })
-	if pa, err = promApi(args); err != nil {
+	if set, err = promEndpoints(args, query); err != nil {
 		return
 	}
-	if value, _, err = pa.QueryRange(ctx, query, range5m); err != nil {
+	if value = args.cache.get(set, query, range5m); value != nil {
+		st.Cached = true
+		args.served.record(entityKind, servedByCache)
+	} else if value, err = queryEndpoints(ctx, args, set, query, range5m, entityKind, metricName, st); err != nil {
 		return
-	}
-	if value == nil {
+	} else if value == nil {
 		err = errors.New("no resultset returned")
-	} else if value.(model.Matrix) == nil {
-		err = errors.New("no time series data returned")
-	} else if value.(model.Matrix).Len() == 0 {
-		err = errors.New("no data returned, value.(model.Matrix) is empty")
+	} else {
+		args.cache.put(args, set, query, range5m, value)
 	}
 	return
 }
 
-func GetVersion(args *Parameters) (version string, err error) {
-	var pa v1.API
-	ctx, cancel := context.WithCancel(context.Background())
-	_ = time.AfterFunc(30*time.Second, func() { cancel() })
-	if pa, err = promApi(args); err != nil {
-		return
+// toMatrix converts the result of a query to a matrix, which the collectors handle: the series of an instant query get their
+// one value and a scalar becomes a series without labels. Strings are not series and are rejected.
+func toMatrix(value model.Value) (model.Matrix, error) {
+	switch v := value.(type) {
+	case model.Matrix:
+		return v, nil
+	case model.Vector:
+		return vectorToMatrix(v), nil
+	case *model.Scalar:
+		return model.Matrix{{Metric: model.Metric{}, Values: []model.SamplePair{{Timestamp: v.Timestamp, Value: v.Value}}}}, nil
+	default:
+		return nil, fmt.Errorf("query returned a %s, not time series", value.Type())
 	}
-	var bir v1.BuildinfoResult
-	if bir, err = pa.Buildinfo(ctx); err == nil {
-		version = bir.Version
+}
+
+// queryEndpoints sends the query to the active endpoint of the set, failing over to the next endpoints on transient errors.
+func queryEndpoints(ctx context.Context, args *Parameters, set *endpointSet, query string, range5m v1.Range, entityKind, metricName string, st *queryStat) (value model.Value, err error) {
+	ep := set.active()
+	for tried := 1; ; tried++ {
+		st.Endpoint = ep.url
+		if value, err = queryRange(ctx, args, ep.client, query, range5m, entityKind, metricName, 0, st); err == nil {
+			set.succeeded(ep)
+			args.served.record(entityKind, ep.url)
+			break
+		}
+		if !isTransient(err) || ctx.Err() != nil || tried == len(set.endpoints) {
+			return
+		}
+		next := set.failover(ep)
+		msg := fmt.Sprintf("entity=%s metric=%s message=failing over from %s to %s: %s", entityKind, metricName, ep.url, next.url, err.Error())
+		args.WarnLogger.Println(msg)
+		fmt.Println("[WARNING] " + msg)
+		ep = next
 	}
 	return
 }
 
-func promApi(args *Parameters) (v1.API, error) {
-	tlsClientConfig := &tls.Config{}
-	if args.CaCertPath != "" {
-		if c, err := config.NewTLSConfig(&config.TLSConfig{
-			CAFile: args.CaCertPath,
-		}); err == nil {
-			tlsClientConfig = c
-		} else {
-			log.Fatalf("Failed to generate TLS config:%v", err)
-		}
+// queryRangeOnce sends one request for the query, accounting for it in the stats of the query.
+func queryRangeOnce(ctx context.Context, args *Parameters, client api.Client, query string, r v1.Range, st *queryStat) (value model.Value, err error) {
+	release, err := args.throttle.acquire(ctx)
+	if err != nil {
+		return
 	}
-	var roundTripper http.RoundTripper = &http.Transport{
-		TLSClientConfig: tlsClientConfig,
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, durationOrDefault(args.QueryTimeout, DefaultQueryTimeout))
+	defer cancel()
+	var ps *promStats
+	value, ps, err = promQuery(ctx, client, query, r, args.QueryParams)
+	st.addRequest(ps)
+	return
+}
+
+// vectorToMatrix converts the result of an instant query to a matrix of one value per series, so it is handled like the result
+// of a range query.
+func vectorToMatrix(vec model.Vector) model.Matrix {
+	mat := make(model.Matrix, 0, len(vec))
+	for _, s := range vec {
+		mat = append(mat, &model.SampleStream{Metric: s.Metric, Values: []model.SamplePair{{Timestamp: s.Timestamp, Value: s.Value}}})
 	}
-	if args.OAuthTokenPath != "" {
-		roundTripper = config.NewAuthorizationCredentialsFileRoundTripper("Bearer", args.OAuthTokenPath, roundTripper)
+	return mat
+}
+
+// matrixToVector is the inverse of vectorToMatrix, keeping the latest value of each series.
+func matrixToVector(mat model.Matrix) model.Vector {
+	vec := make(model.Vector, 0, len(mat))
+	for _, s := range mat {
+		if n := len(s.Values); n > 0 {
+			vec = append(vec, &model.Sample{Metric: s.Metric, Timestamp: s.Values[n-1].Timestamp, Value: s.Values[n-1].Value})
+		}
 	}
-	if client, err := api.NewClient(api.Config{Address: *args.PromURL, RoundTripper: roundTripper}); err == nil {
-		return v1.NewAPI(client), nil
-	} else {
-		return nil, err
+	return vec
+}
+
+// GetVersion queries the Prometheus build information of every endpoint, including those of the named sources, which also
+// checks the connections to Prometheus, and returns the version of the first healthy default endpoint, which is then used for the queries.
+func GetVersion(ctx context.Context, args *Parameters) (version string, err error) {
+	var set *endpointSet
+	if set, err = promEndpoints(args, ""); err != nil {
+		return
 	}
+	if version, err = set.checkHealth(ctx, args); err == nil {
+		err = checkSourcesHealth(ctx, args)
+	}
+	return
 }
 
 // TimeRange allows you to define the start and end values of the range will pass to the Prometheus for the query.
@@ -121,6 +254,24 @@ func TimeRange(args *Parameters, historyInterval time.Duration) (promRange v1.Ra
 	return v1.Range{Start: start, End: end, Step: time.Minute * time.Duration(args.SampleRate)}
 }
 
+// ConfigRange returns the range of the config and attribute queries, whose results are reduced to the latest value of each
+// series: an instant query at the current time (a range without step) unless a lookback is configured, the steps of the lookback
+// before the current time otherwise, so entities which went away during it are still reported.
+// Workloads keep using TimeRange.
+func ConfigRange(args *Parameters) v1.Range {
+	end := *args.CurrentTime
+	if args.InstantLookback <= 0 {
+		return v1.Range{Start: end, End: end}
+	}
+	step := time.Minute * time.Duration(args.SampleRate)
+	if step <= 0 {
+		step = time.Minute
+	}
+	// the last step falls on the current time, like for the range queries
+	steps := (args.InstantLookback + step - 1) / step
+	return v1.Range{Start: end.Add(-steps * step), End: end, Step: step}
+}
+
 // AddToLabelMap used to add values to label map used for attributes.
 func AddToLabelMap(key string, value string, labelPath map[string]string) {
 	if _, ok := labelPath[key]; !ok {
@@ -169,7 +320,23 @@ func AddToLabelMap(key string, value string, labelPath map[string]string) {
 }
 
 // GetWorkload used to query for the workload data and then calls write workload
-func GetWorkload(fileName, metricName, query string, metricField []model.LabelName, args *Parameters, entityKind string) {
+func GetWorkload(ctx context.Context, fileName, metricName, query string, metricField []model.LabelName, args *Parameters, entityKind string) {
+	getWorkload(ctx, fileName, metricName, query, metricField, args, entityKind, func(r v1.Range) (model.Value, error) {
+		return MetricCollect(ctx, args, query, r, entityKind, metricName)
+	})
+}
+
+// GetJoinWorkload is GetWorkload for a join, see CollectJoin.
+func GetJoinWorkload(ctx context.Context, fileName, metricName string, j *Join, metricField []model.LabelName, args *Parameters, entityKind string) {
+	getWorkload(ctx, fileName, metricName, j.String(), metricField, args, entityKind, func(r v1.Range) (model.Value, error) {
+		return CollectJoin(ctx, args, j, r, entityKind, metricName)
+	})
+}
+
+func getWorkload(ctx context.Context, fileName, metricName, query string, metricField []model.LabelName, args *Parameters, entityKind string, collect func(v1.Range) (model.Value, error)) {
+	if ctx.Err() != nil {
+		return
+	}
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
@@ -180,6 +347,7 @@ func GetWorkload(fileName, metricName, query string, metricField []model.LabelNa
 		fmt.Println("entity=" + entityKind + " message=" + err.Error())
 		return
 	}
+	defer workloadWrite.Close()
 	if csvHeaderFormat, f := GetCsvHeaderFormat(entityKind); f {
 		fmt.Fprintf(workloadWrite, csvHeaderFormat, metricName)
 	} else {
@@ -193,9 +361,12 @@ func GetWorkload(fileName, metricName, query string, metricField []model.LabelNa
 	//This is done as the farther you go back in time the slower prometheus querying becomes and we have seen cases where will not run from timeouts on Prometheus.
 	//As a result if we do hit an issue with timing out on Prometheus side we still can send the current data and data going back to that point vs losing it all.
 	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
+		if ctx.Err() != nil {
+			break
+		}
 		range5Min := TimeRange(args, historyInterval)
 
-		result, err = MetricCollect(args, query, range5Min)
+		result, err = collect(range5Min)
 		if err != nil {
 			args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
 			fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
@@ -203,8 +374,6 @@ func GetWorkload(fileName, metricName, query string, metricField []model.LabelNa
 			WriteWorkload(workloadWrite, result, metricField, args, entityKind)
 		}
 	}
-	//Close the workload files.
-	workloadWrite.Close()
 }
 
 // WriteWorkload will write out the workload data specific to metric provided to the file that was passed in.
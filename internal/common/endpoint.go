@@ -0,0 +1,150 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// endpoint is one Prometheus server the queries can be sent to.
+type endpoint struct {
+	// url is redacted for logging, base is the URL as configured
+	url     string
+	base    string
+	client  api.Client
+	api     v1.API
+	healthy bool
+	version string
+}
+
+// endpointSet is a list of equivalent Prometheus endpoints, such as an HA pair. Queries are sent to the current endpoint,
+// which is the first healthy one at startup, and fail over to the next healthy one when they keep failing. The health of an
+// endpoint is updated by every query it fails or serves.
+type endpointSet struct {
+	// name is the name of the source, empty for the default endpoints
+	name      string
+	mu        sync.Mutex
+	endpoints []*endpoint
+	current   int
+}
+
+// newEndpointSet creates the API clients of the endpoints, all sharing the same round tripper (and connection pool).
+func newEndpointSet(urls []string, roundTripper http.RoundTripper) (*endpointSet, error) {
+	set := &endpointSet{}
+	for _, u := range urls {
+		client, err := api.NewClient(api.Config{Address: u, RoundTripper: roundTripper})
+		if err != nil {
+			return nil, err
+		}
+		set.endpoints = append(set.endpoints, &endpoint{url: redactURL(u), base: u, client: client, api: v1.NewAPI(client)})
+	}
+	if len(set.endpoints) == 0 {
+		return nil, errors.New("no Prometheus endpoint configured")
+	}
+	return set, nil
+}
+
+// active returns the endpoint queries are currently sent to.
+func (s *endpointSet) active() *endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endpoints[s.current]
+}
+
+// failover marks the failed endpoint unhealthy and moves away from it, unless another query already did, to the next healthy
+// endpoint or, if none is, to the next one, which may have recovered. It returns the endpoint to use next.
+func (s *endpointSet) failover(failed *endpoint) *endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failed.healthy = false
+	if s.endpoints[s.current] == failed {
+		n := len(s.endpoints)
+		next := (s.current + 1) % n
+		for i := 1; i < n; i++ {
+			if j := (s.current + i) % n; s.endpoints[j].healthy {
+				next = j
+				break
+			}
+		}
+		s.current = next
+	}
+	return s.endpoints[s.current]
+}
+
+// succeeded marks the endpoint healthy again, after it served a query.
+func (s *endpointSet) succeeded(ep *endpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ep.healthy = true
+}
+
+// checkHealth calls Buildinfo on every endpoint and makes the first healthy one current. The version of that endpoint is returned;
+// it fails only if no endpoint is healthy.
+func (s *endpointSet) checkHealth(ctx context.Context, args *Parameters) (version string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := -1
+	for i, ep := range s.endpoints {
+		var bir v1.BuildinfoResult
+		bctx, cancel := context.WithTimeout(ctx, durationOrDefault(args.QueryTimeout, DefaultQueryTimeout))
+		bir, err = ep.api.Buildinfo(bctx)
+		cancel()
+		if ep.healthy = err == nil; !ep.healthy {
+			if len(s.endpoints) > 1 {
+				msg := fmt.Sprintf("endpoint=%s message=%s", ep.url, err.Error())
+				args.WarnLogger.Println(msg)
+				fmt.Println("[WARNING] " + msg)
+			}
+			continue
+		}
+		ep.version = bir.Version
+		if first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		if len(s.endpoints) > 1 {
+			err = fmt.Errorf("none of the %d Prometheus endpoints is healthy, last error: %w", len(s.endpoints), err)
+		}
+		return
+	}
+	s.current = first
+	if len(s.endpoints) > 1 {
+		msg := fmt.Sprintf("endpoint=%s message=using the first healthy Prometheus endpoint", s.endpoints[first].url)
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	return s.endpoints[first].version, nil
+}
+
+// servedBy counts, per entity kind, the queries served by each endpoint.
+type servedBy struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+func (s *servedBy) record(entityKind, endpointURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = map[string]map[string]int{}
+	}
+	if _, ok := s.counts[entityKind]; !ok {
+		s.counts[entityKind] = map[string]int{}
+	}
+	s.counts[entityKind][endpointURL]++
+}
+
+// redactURL hides the password of the URL, if any, so it can be logged.
+func redactURL(s string) string {
+	if u, err := url.Parse(s); err == nil {
+		return u.Redacted()
+	}
+	return s
+}
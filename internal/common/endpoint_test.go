@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// Responses of the test endpoints to the queries.
+const (
+	serve int32 = iota
+	unavailable
+	badData
+)
+
+func TestFailover(t *testing.T) {
+	var modes [3]int32
+	var urls []string
+	for i := range modes {
+		mode := &modes[i]
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/status/buildinfo" {
+				io.WriteString(w, `{"status":"success","data":{"version":"2.45.0"}}`)
+				return
+			}
+			switch atomic.LoadInt32(mode) {
+			case unavailable:
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			case badData:
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+			default:
+				io.WriteString(w, matrixResponse)
+			}
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	args := newTestParameters(t, urls[0])
+	args.PromURLs = urls
+	if err := InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetVersion(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	health := func() (healthy []bool) {
+		for _, ep := range args.endpoints.endpoints {
+			healthy = append(healthy, ep.healthy)
+		}
+		return
+	}
+	if got := health(); !reflect.DeepEqual(got, []bool{true, true, true}) {
+		t.Fatalf("health at startup %v", got)
+	}
+
+	steps := []struct {
+		name  string
+		modes [3]int32
+		// the endpoint serving the query, -1 if it fails, and the health of the endpoints afterwards
+		servedBy int
+		healthy  []bool
+	}{
+		{"first serves", [3]int32{serve, serve, serve}, 0, []bool{true, true, true}},
+		{"first unavailable", [3]int32{unavailable, serve, serve}, 1, []bool{false, true, true}},
+		// the second stays current, the first is not tried again
+		{"second serves", [3]int32{unavailable, serve, serve}, 1, []bool{false, true, true}},
+		{"bad query", [3]int32{unavailable, badData, serve}, -1, []bool{false, true, true}},
+		// the third is the next healthy one
+		{"second unavailable", [3]int32{serve, unavailable, serve}, 2, []bool{false, false, true}},
+		// none is healthy, the first is tried again, and has recovered
+		{"third unavailable", [3]int32{serve, unavailable, unavailable}, 0, []bool{true, false, false}},
+		{"all unavailable", [3]int32{unavailable, unavailable, unavailable}, -1, []bool{false, false, false}},
+	}
+	want := map[string]int{}
+	for _, step := range steps {
+		for i := range modes {
+			atomic.StoreInt32(&modes[i], step.modes[i])
+		}
+		_, err := MetricCollect(context.Background(), args, "up", TimeRange(args, 0), "test", "up")
+		if step.servedBy < 0 {
+			if err == nil {
+				t.Errorf("%s: the query did not fail", step.name)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("%s: %v", step.name, err)
+			}
+			want[args.endpoints.endpoints[step.servedBy].url]++
+		}
+		if got := health(); !reflect.DeepEqual(got, step.healthy) {
+			t.Errorf("%s: health %v, want %v", step.name, got, step.healthy)
+		}
+		if got := args.served.counts["test"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: served by %v, want %v", step.name, got, want)
+		}
+	}
+}
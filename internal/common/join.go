@@ -0,0 +1,230 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// Join is a query multiplying the series of a query by those of info queries, the pod owners or the node labels of
+// kube-state-metrics for instance, and aggregating the result:
+//
+//	Aggregator(Query * on (On) group_left (Include) Info ...) by (By)
+//
+// When its metrics are routed to different sources, no Prometheus can run it; CollectJoin then queries each side from its own
+// source and joins the series itself.
+type Join struct {
+	Aggregator string
+	Query      string
+	Matches    []Match
+	By         []model.LabelName
+}
+
+// Match is a vector matching of a Join: the series are matched with those of Info having the same On labels, Info being the
+// one side (group_left) unless Right is set (group_right). The Include labels are copied from the one side.
+type Match struct {
+	On      []model.LabelName
+	Right   bool
+	Include []model.LabelName
+	Info    string
+}
+
+// NodeExporterPods matches the node exporter series aggregated by pod IP, see ByPodIP, with the pod info of the node exporter
+// pods, which has their node.
+var NodeExporterPods = Match{On: []model.LabelName{"pod_ip"}, Right: true, Info: `kube_pod_info{pod=~".*node-exporter.*"}`}
+
+// ByPodIP returns the query aggregated by the pod IP of the instance of its series.
+func ByPodIP(aggregator, query string) string {
+	return aggregator + `(label_replace(` + query + `, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip)`
+}
+
+// String returns the PromQL of the join.
+func (j *Join) String() string {
+	var sb strings.Builder
+	sb.WriteString(j.Aggregator + "(" + j.Query)
+	for _, m := range j.Matches {
+		sb.WriteString(" * on (" + joinLabels(m.On) + ") ")
+		if m.Right {
+			sb.WriteString("group_right")
+		} else {
+			sb.WriteString("group_left")
+		}
+		if len(m.Include) > 0 {
+			sb.WriteString(" (" + joinLabels(m.Include) + ")")
+		}
+		sb.WriteString(" " + m.Info)
+	}
+	sb.WriteString(") by (" + joinLabels(j.By) + ")")
+	return sb.String()
+}
+
+func joinLabels(names []model.LabelName) string {
+	s := make([]string, len(names))
+	for i, name := range names {
+		s[i] = string(name)
+	}
+	return strings.Join(s, ", ")
+}
+
+// CollectJoin collects the join the way MetricCollect collects a query. If the metrics of the join are routed to different
+// sources, the query and each info query are collected from their own source and joined here.
+func CollectJoin(ctx context.Context, args *Parameters, j *Join, r v1.Range, entityKind, metricName string) (model.Value, error) {
+	query := j.String()
+	if _, err := routeQuery(args, query); err == nil {
+		return MetricCollect(ctx, args, query, r, entityKind, metricName)
+	}
+	value, err := MetricCollect(ctx, args, j.Query, r, entityKind, metricName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", j.Query, err)
+	}
+	mat := value.(model.Matrix)
+	for _, m := range j.Matches {
+		info, err := MetricCollect(ctx, args, m.Info, r, entityKind, metricName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.Info, err)
+		}
+		if mat, err = joinMatrices(mat, info.(model.Matrix), m); err != nil {
+			return nil, err
+		}
+	}
+	if mat, err = aggregateMatrix(mat, j.Aggregator, j.By); err != nil {
+		return nil, err
+	}
+	if mat.Len() == 0 {
+		return mat, errors.New("no data returned, the joined series do not match")
+	}
+	return mat, nil
+}
+
+type joinSample struct {
+	metric model.Metric
+	value  model.SampleValue
+}
+
+// joinMatrices multiplies the series of left by those of right matched as m describes, sample by sample, as PromQL does.
+func joinMatrices(left, right model.Matrix, m Match) (model.Matrix, error) {
+	one, many := right, left
+	if m.Right {
+		one, many = left, right
+	}
+	// the sample of the one side of each signature at each time
+	ones := map[string]map[model.Time]joinSample{}
+	for _, ss := range one {
+		sig := signature(ss.Metric, m.On)
+		if ones[sig] == nil {
+			ones[sig] = map[model.Time]joinSample{}
+		}
+		for _, sp := range ss.Values {
+			if s, ok := ones[sig][sp.Timestamp]; ok && !s.metric.Equal(ss.Metric) {
+				return nil, fmt.Errorf("found duplicate series for the match group %s on the one side of the join with %s, many-to-many matching not allowed", sig, s.metric)
+			}
+			ones[sig][sp.Timestamp] = joinSample{metric: ss.Metric, value: sp.Value}
+		}
+	}
+	var result model.Matrix
+	joined := map[model.Fingerprint]*model.SampleStream{}
+	for _, ss := range many {
+		matches := ones[signature(ss.Metric, m.On)]
+		if matches == nil {
+			continue
+		}
+		for _, sp := range ss.Values {
+			o, ok := matches[sp.Timestamp]
+			if !ok {
+				continue
+			}
+			metric := ss.Metric.Clone()
+			delete(metric, model.MetricNameLabel)
+			for _, name := range m.Include {
+				if v, ok := o.metric[name]; ok {
+					metric[name] = v
+				} else {
+					delete(metric, name)
+				}
+			}
+			fp := metric.Fingerprint()
+			if joined[fp] == nil {
+				joined[fp] = &model.SampleStream{Metric: metric}
+				result = append(result, joined[fp])
+			}
+			joined[fp].Values = append(joined[fp].Values, model.SamplePair{Timestamp: sp.Timestamp, Value: sp.Value * o.value})
+		}
+	}
+	return result, nil
+}
+
+// signature returns the values of the labels matched on.
+func signature(metric model.Metric, on []model.LabelName) string {
+	values := make([]string, len(on))
+	for i, name := range on {
+		values[i] = string(metric[name])
+	}
+	return strings.Join(values, "\xff")
+}
+
+// aggregateMatrix aggregates the series by the labels, as the sum, avg, max and min PromQL aggregators do.
+func aggregateMatrix(mat model.Matrix, aggregator string, by []model.LabelName) (model.Matrix, error) {
+	var combine func(acc, v model.SampleValue) model.SampleValue
+	switch aggregator {
+	case "sum", "avg":
+		combine = func(acc, v model.SampleValue) model.SampleValue { return acc + v }
+	case "max":
+		combine = func(acc, v model.SampleValue) model.SampleValue {
+			return model.SampleValue(math.Max(float64(acc), float64(v)))
+		}
+	case "min":
+		combine = func(acc, v model.SampleValue) model.SampleValue {
+			return model.SampleValue(math.Min(float64(acc), float64(v)))
+		}
+	default:
+		return nil, fmt.Errorf("aggregator %s cannot be evaluated on joined series", aggregator)
+	}
+	type group struct {
+		metric model.Metric
+		values map[model.Time]model.SampleValue
+		counts map[model.Time]int
+	}
+	groups := map[model.Fingerprint]*group{}
+	for _, ss := range mat {
+		metric := model.Metric{}
+		for _, name := range by {
+			if v, ok := ss.Metric[name]; ok {
+				metric[name] = v
+			}
+		}
+		fp := metric.Fingerprint()
+		g := groups[fp]
+		if g == nil {
+			g = &group{metric: metric, values: map[model.Time]model.SampleValue{}, counts: map[model.Time]int{}}
+			groups[fp] = g
+		}
+		for _, sp := range ss.Values {
+			if g.counts[sp.Timestamp] == 0 {
+				g.values[sp.Timestamp] = sp.Value
+			} else {
+				g.values[sp.Timestamp] = combine(g.values[sp.Timestamp], sp.Value)
+			}
+			g.counts[sp.Timestamp]++
+		}
+	}
+	result := make(model.Matrix, 0, len(groups))
+	for _, g := range groups {
+		ss := &model.SampleStream{Metric: g.metric}
+		for t, v := range g.values {
+			if aggregator == "avg" {
+				v /= model.SampleValue(g.counts[t])
+			}
+			ss.Values = append(ss.Values, model.SamplePair{Timestamp: t, Value: v})
+		}
+		sort.Slice(ss.Values, func(a, b int) bool { return ss.Values[a].Timestamp < ss.Values[b].Timestamp })
+		result = append(result, ss)
+	}
+	sort.Sort(result)
+	return result, nil
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+// the join of the container metrics with the pod owners, as the container collector sends it
+var ownerJoin = &Join{
+	Aggregator: "sum",
+	Query:      `container_memory_rss{name!~"k8s_POD_.*"}`,
+	Matches: []Match{{On: []model.LabelName{"pod", "namespace"}, Include: []model.LabelName{"owner_name", "owner_kind"},
+		Info: `max(kube_pod_owner) by (namespace, pod, owner_name, owner_kind)`}},
+	By: []model.LabelName{"owner_kind", "owner_name", "namespace", "container"},
+}
+
+func TestJoinString(t *testing.T) {
+	want := `sum(container_memory_rss{name!~"k8s_POD_.*"} * on (pod, namespace) group_left (owner_name, owner_kind) max(kube_pod_owner) by (namespace, pod, owner_name, owner_kind)) by (owner_kind, owner_name, namespace, container)`
+	if s := ownerJoin.String(); s != want {
+		t.Errorf("String() = %s, want %s", s, want)
+	}
+	j := &Join{Aggregator: "max", Query: ByPodIP("sum", `node_load1`), Matches: []Match{NodeExporterPods}, By: []model.LabelName{"node"}}
+	want = `max(sum(label_replace(node_load1, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
+	if s := j.String(); s != want {
+		t.Errorf("String() = %s, want %s", s, want)
+	}
+}
+
+// series returns a series of two samples of the value, at the times of the test queries.
+func series(value float64, labels ...string) *model.SampleStream {
+	ss := &model.SampleStream{Metric: model.Metric{}}
+	for i := 0; i < len(labels); i += 2 {
+		ss.Metric[model.LabelName(labels[i])] = model.LabelValue(labels[i+1])
+	}
+	for _, t := range []model.Time{1704160800000, 1704161100000} {
+		ss.Values = append(ss.Values, model.SamplePair{Timestamp: t, Value: model.SampleValue(value)})
+	}
+	return ss
+}
+
+// matrixServer serves the matrix for every query, recording the queries.
+func matrixServer(t *testing.T, mat model.Matrix, queries *[]string, mu *sync.Mutex) *httptest.Server {
+	body, err := json.Marshal(map[string]interface{}{"status": "success", "data": map[string]interface{}{"resultType": "matrix", "result": mat}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mu.Lock()
+		*queries = append(*queries, r.Form.Get("query"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCollectJoin(t *testing.T) {
+	var mu sync.Mutex
+	var ksmQueries, cadvisorQueries []string
+	ksm := matrixServer(t, model.Matrix{
+		series(1, "__name__", "kube_pod_owner", "namespace", "n", "pod", "a", "owner_name", "web", "owner_kind", "ReplicaSet"),
+		series(1, "__name__", "kube_pod_owner", "namespace", "n", "pod", "b", "owner_name", "web", "owner_kind", "ReplicaSet"),
+		series(1, "__name__", "kube_pod_owner", "namespace", "n", "pod", "c", "owner_name", "db", "owner_kind", "StatefulSet"),
+	}, &ksmQueries, &mu)
+	cadvisor := matrixServer(t, model.Matrix{
+		series(10, "__name__", "container_memory_rss", "namespace", "n", "pod", "a", "container", "app"),
+		series(20, "__name__", "container_memory_rss", "namespace", "n", "pod", "b", "container", "app"),
+		series(30, "__name__", "container_memory_rss", "namespace", "n", "pod", "c", "container", "db"),
+		// the pod has no owner series, it is left out as PromQL would
+		series(40, "__name__", "container_memory_rss", "namespace", "n", "pod", "d", "container", "app"),
+	}, &cadvisorQueries, &mu)
+
+	args := newTestParameters(t, ksm.URL)
+	args.PromSources = map[string][]string{"cadvisor": {cadvisor.URL}}
+	args.PromRoutes = map[string]string{"container": "cadvisor"}
+	if err := InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+	value, err := CollectJoin(context.Background(), args, ownerJoin, TimeRange(args, 0), "container", "rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.Matrix{
+		series(30, "owner_kind", "ReplicaSet", "owner_name", "web", "namespace", "n", "container", "app"),
+		series(30, "owner_kind", "StatefulSet", "owner_name", "db", "namespace", "n", "container", "db"),
+	}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("CollectJoin() = %v, want %v", value, want)
+	}
+	if !reflect.DeepEqual(cadvisorQueries, []string{ownerJoin.Query}) {
+		t.Errorf("cAdvisor source queried with %q", cadvisorQueries)
+	}
+	if !reflect.DeepEqual(ksmQueries, []string{ownerJoin.Matches[0].Info}) {
+		t.Errorf("kube-state-metrics source queried with %q", ksmQueries)
+	}
+
+	// routed to the same source, the join is sent as such
+	ksmQueries = nil
+	args.PromRoutes = map[string]string{"container": "cadvisor", "kube": "cadvisor"}
+	if err = InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+	cadvisorQueries = nil
+	if _, err = CollectJoin(context.Background(), args, ownerJoin, TimeRange(args, 0), "container", "rss"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cadvisorQueries, []string{ownerJoin.String()}) || len(ksmQueries) != 0 {
+		t.Errorf("cAdvisor source queried with %q, kube-state-metrics source with %q", cadvisorQueries, ksmQueries)
+	}
+}
+
+func TestJoinMatrices(t *testing.T) {
+	nodeExporter := model.Matrix{
+		series(5, "pod_ip", "10.0.0.1"),
+		series(7, "pod_ip", "10.0.0.2"),
+		series(9, "pod_ip", "10.0.0.3"),
+	}
+	podInfo := model.Matrix{
+		series(1, "__name__", "kube_pod_info", "pod_ip", "10.0.0.1", "node", "n1", "pod", "node-exporter-1"),
+		series(1, "__name__", "kube_pod_info", "pod_ip", "10.0.0.2", "node", "n2", "pod", "node-exporter-2"),
+	}
+	nodeLabels := model.Matrix{
+		series(1, "__name__", "kube_node_labels", "node", "n1", "label_pool", "a"),
+		series(1, "__name__", "kube_node_labels", "node", "n2", "label_pool", "a"),
+	}
+	// the node exporter series by node, averaged by node group as the node group collector does
+	mat, err := joinMatrices(nodeExporter, podInfo, NodeExporterPods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mat, err = joinMatrices(mat, nodeLabels, Match{On: []model.LabelName{"node"}, Right: true}); err != nil {
+		t.Fatal(err)
+	}
+	if mat, err = aggregateMatrix(mat, "avg", []model.LabelName{"label_pool"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (model.Matrix{series(6, "label_pool", "a")}); !reflect.DeepEqual(mat, want) {
+		t.Errorf("joined = %v, want %v", mat, want)
+	}
+
+	// the one side, the left one for group_right, has to be unique for each match
+	nodeExporter = append(nodeExporter, series(1, "pod_ip", "10.0.0.1", "device", "sda"))
+	if _, err = joinMatrices(nodeExporter, podInfo, NodeExporterPods); err == nil || !strings.Contains(err.Error(), "many-to-many matching not allowed") {
+		t.Errorf("joinMatrices() with duplicate series error = %v", err)
+	}
+}
+
+func TestAggregateMatrix(t *testing.T) {
+	mat := model.Matrix{series(1, "node", "a", "pool", "x"), series(5, "node", "b", "pool", "x"), series(2, "node", "c")}
+	tests := []struct {
+		aggregator string
+		x, none    float64
+	}{
+		{"sum", 6, 2},
+		{"avg", 3, 2},
+		{"max", 5, 2},
+		{"min", 1, 2},
+	}
+	for _, test := range tests {
+		got, err := aggregateMatrix(mat, test.aggregator, []model.LabelName{"pool"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := model.Matrix{series(test.none), series(test.x, "pool", "x")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", test.aggregator, got, want)
+		}
+	}
+	if _, err := aggregateMatrix(mat, "count", nil); err == nil {
+		t.Error("count aggregated")
+	}
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestFile describes the run next to the collected data.
+const manifestFile = "./data/manifest.json"
+
+type manifestEndpoint struct {
+	Source  string `json:"source,omitempty"`
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Version string `json:"version,omitempty"`
+}
+
+type manifest struct {
+	GeneratedAt string             `json:"generatedAt"`
+	Endpoints   []manifestEndpoint `json:"endpoints"`
+	// Entities holds, per entity kind, the number of queries served by each endpoint.
+	Entities map[string]map[string]int `json:"entities"`
+	// ThrottledQueries is the number of queries which waited for the rate limit or the in flight cap, ThrottledTime
+	// the total time they waited.
+	ThrottledQueries int    `json:"throttledQueries,omitempty"`
+	ThrottledTime    string `json:"throttledTime,omitempty"`
+}
+
+// WriteManifest writes the manifest of the run, recording the Prometheus endpoints, which of them served each entity and
+// how long the queries were throttled. The endpoints serving each entity and the throttled time are logged as well.
+func WriteManifest(args *Parameters) {
+	if args.endpoints == nil {
+		return
+	}
+	now := time.Now().UTC()
+	m := manifest{GeneratedAt: Format(&now), Entities: map[string]map[string]int{}}
+	for _, set := range allEndpointSets(args) {
+		set.mu.Lock()
+		for _, ep := range set.endpoints {
+			m.Endpoints = append(m.Endpoints, manifestEndpoint{Source: set.name, URL: ep.url, Healthy: ep.healthy, Version: ep.version})
+		}
+		set.mu.Unlock()
+	}
+	args.served.mu.Lock()
+	var entityKinds []string
+	for entityKind := range args.served.counts {
+		entityKinds = append(entityKinds, entityKind)
+	}
+	sort.Strings(entityKinds)
+	for _, entityKind := range entityKinds {
+		counts := args.served.counts[entityKind]
+		m.Entities[entityKind] = counts
+		var urls []string
+		for u := range counts {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		msg := fmt.Sprintf("entity=%s message=served by %s", entityKind, strings.Join(urls, ", "))
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	args.served.mu.Unlock()
+	if args.throttle.enabled() {
+		var waited time.Duration
+		m.ThrottledQueries, waited = args.throttle.throttled()
+		m.ThrottledTime = waited.String()
+		msg := fmt.Sprintf("message=%d queries throttled for %s in total", m.ThrottledQueries, m.ThrottledTime)
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = os.WriteFile(manifestFile, b, 0644)
+	}
+	if err != nil {
+		args.ErrorLogger.Println("message=cannot write manifest: " + err.Error())
+		fmt.Println("[ERROR] message=cannot write manifest: " + err.Error())
+	}
+}
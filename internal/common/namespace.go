@@ -0,0 +1,63 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// NamespaceFilter selects the namespaces whose containers and resource quotas are collected, by name patterns in the syntax of
+// path.Match, e.g. kube-*. A namespace is collected if it matches one of the include patterns, or there are none, and none of
+// the exclude patterns. The queries still cover every namespace, the others are dropped from the results.
+type NamespaceFilter struct {
+	Include, Exclude []string
+}
+
+// ParseNamespaceFilter parses the comma separated include and exclude patterns. It returns nil if there are none, so every
+// namespace is collected.
+func ParseNamespaceFilter(include, exclude string) (*NamespaceFilter, error) {
+	f := &NamespaceFilter{}
+	var err error
+	if f.Include, err = parsePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.Exclude, err = parsePatterns(exclude); err != nil {
+		return nil, err
+	}
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func parsePatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// IncludeNamespace reports whether the namespace is collected.
+func IncludeNamespace(args *Parameters, namespace string) bool {
+	f := args.Namespaces
+	if f == nil {
+		return true
+	}
+	return (len(f.Include) == 0 || matchAny(f.Include, namespace)) && !matchAny(f.Exclude, namespace)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
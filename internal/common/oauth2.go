@@ -0,0 +1,42 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// newOAuth2RoundTripper creates a round tripper which authenticates with a token obtained through the OAuth2 client credentials
+// flow. The token is cached and requested again shortly before it expires. The token endpoint is called through the same
+// transport as Prometheus, so it shares its TLS and proxy settings.
+func newOAuth2RoundTripper(args *Parameters, next http.RoundTripper) (http.RoundTripper, error) {
+	if args.OAuth2ClientID == "" {
+		return nil, errors.New("OAuth2 token URL is set without a client ID")
+	}
+	secret := args.OAuth2ClientSecret
+	if args.OAuth2ClientSecretFile != "" {
+		b, err := os.ReadFile(args.OAuth2ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use OAuth2 client secret file: %w", err)
+		}
+		secret = strings.TrimSpace(string(b))
+	}
+	cfg := &clientcredentials.Config{
+		ClientID:     args.OAuth2ClientID,
+		ClientSecret: secret,
+		TokenURL:     args.OAuth2TokenURL,
+	}
+	for _, scope := range strings.Split(args.OAuth2Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			cfg.Scopes = append(cfg.Scopes, scope)
+		}
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: next})
+	return &oauth2.Transport{Source: cfg.TokenSource(ctx), Base: next}, nil
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// DefaultConcurrency is the number of queries run at a time if the concurrency is not configured.
+const DefaultConcurrency = 4
+
+// Pool runs tasks, at most the configured concurrency of them at a time.
+type Pool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewPool creates a pool bounded by the concurrency setting.
+func NewPool(args *Parameters) *Pool {
+	concurrency := args.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Pool{slots: make(chan struct{}, concurrency)}
+}
+
+// Go starts the task once a slot is free, blocking until then.
+func (p *Pool) Go(task func()) {
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		task()
+	}()
+}
+
+// Wait waits for all the tasks started to complete.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// GetWorkload runs GetWorkload as a task of the pool.
+func (p *Pool) GetWorkload(ctx context.Context, fileName, metricName, query string, metricField []model.LabelName, args *Parameters, entityKind string) {
+	p.Go(func() {
+		GetWorkload(ctx, fileName, metricName, query, metricField, args, entityKind)
+	})
+}
+
+// GetJoinWorkload runs GetJoinWorkload as a task of the pool.
+func (p *Pool) GetJoinWorkload(ctx context.Context, fileName, metricName string, j *Join, metricField []model.LabelName, args *Parameters, entityKind string) {
+	p.Go(func() {
+		GetJoinWorkload(ctx, fileName, metricName, j, metricField, args, entityKind)
+	})
+}
+
+// queryResult is the outcome of a query of a Queries batch.
+type queryResult struct {
+	value model.Value
+	err   error
+}
+
+type pendingQuery struct {
+	query, metricName string
+	handle            func(query string, result model.Value, err error)
+	done              chan queryResult
+	// skipped is the error of a query which is not sent
+	skipped error
+}
+
+// Queries is a batch of queries of an entity kind over the same range, run concurrently. Their handlers are called in the order
+// the queries were added, on the goroutine calling Run.
+type Queries struct {
+	ctx        context.Context
+	args       *Parameters
+	r          v1.Range
+	entityKind string
+	pending    []*pendingQuery
+}
+
+// NewQueries creates an empty batch of queries.
+func NewQueries(ctx context.Context, args *Parameters, r v1.Range, entityKind string) *Queries {
+	return &Queries{ctx: ctx, args: args, r: r, entityKind: entityKind}
+}
+
+// Add adds a query to the batch, its handler getting the result of MetricCollect.
+func (q *Queries) Add(query, metricName string, handle func(query string, result model.Value, err error)) {
+	q.pending = append(q.pending, &pendingQuery{query: query, metricName: metricName, handle: handle, done: make(chan queryResult, 1)})
+}
+
+// AddIfAvailable adds a query of the metric to the batch; if Prometheus does not have the metric, see Available, the query is not
+// sent and its handler gets ErrNotAvailable.
+func (q *Queries) AddIfAvailable(metric, query, metricName string, handle func(query string, result model.Value, err error)) {
+	q.Add(query, metricName, handle)
+	if !Available(q.args, metric) {
+		q.pending[len(q.pending)-1].skipped = ErrNotAvailable
+	}
+}
+
+// Run runs the queries of the batch and calls their handlers. The batch is empty afterwards and can be reused.
+func (q *Queries) Run() {
+	pending := q.pending
+	q.pending = nil
+	pool := NewPool(q.args)
+	go func() {
+		for _, pq := range pending {
+			pq := pq
+			if pq.skipped != nil {
+				pq.done <- queryResult{err: pq.skipped}
+				continue
+			}
+			pool.Go(func() {
+				value, err := MetricCollect(q.ctx, q.args, pq.query, q.r, q.entityKind, pq.metricName)
+				pq.done <- queryResult{value: value, err: err}
+			})
+		}
+	}()
+	for _, pq := range pending {
+		res := <-pq.done
+		pq.handle(pq.query, res.value, res.err)
+	}
+}
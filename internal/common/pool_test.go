@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// inFlight counts the calls in progress, keeping the maximum reached.
+type inFlight struct {
+	current, max int32
+}
+
+func (f *inFlight) start() {
+	n := atomic.AddInt32(&f.current, 1)
+	for {
+		max := atomic.LoadInt32(&f.max)
+		if n <= max || atomic.CompareAndSwapInt32(&f.max, max, n) {
+			return
+		}
+	}
+}
+
+func (f *inFlight) end() {
+	atomic.AddInt32(&f.current, -1)
+}
+
+func TestPool(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 3} {
+		var f inFlight
+		var done int32
+		pool := NewPool(&Parameters{Concurrency: concurrency})
+		for i := 0; i < 12; i++ {
+			pool.Go(func() {
+				f.start()
+				defer f.end()
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&done, 1)
+			})
+		}
+		pool.Wait()
+		want := int32(concurrency)
+		if want < 1 {
+			want = 1
+		}
+		if f.max != want {
+			t.Errorf("concurrency %d: %d tasks in flight, want %d", concurrency, f.max, want)
+		}
+		if done != 12 {
+			t.Errorf("concurrency %d: %d tasks done, want 12", concurrency, done)
+		}
+	}
+}
+
+func TestQueriesRun(t *testing.T) {
+	const queries, concurrency = 8, 3
+	var f inFlight
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.start()
+		defer f.end()
+		_ = r.ParseForm()
+		// the query up{i="n"} returns n; the later queries complete first
+		var i int
+		if _, err := fmt.Sscanf(r.Form.Get("query"), `up{i="%d"}`, &i); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		time.Sleep(time.Duration(queries-i) * 3 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"i":"%d"},"values":[[1704164400,"%d"]]}]}}`, i, i)
+	}))
+	defer srv.Close()
+	args := newTestParameters(t, srv.URL)
+	args.Concurrency = concurrency
+	// the metric names of the default endpoints are known, without missing
+	args.capabilities = &capabilities{metrics: map[*endpointSet]map[string]bool{args.endpoints: {"up": true}}, skipped: map[string]bool{}}
+
+	var mu sync.Mutex
+	var handled []string
+	q := NewQueries(context.Background(), args, TimeRange(args, 0), "test")
+	for i := 0; i < queries; i++ {
+		i := i
+		q.Add(`up{i="`+strconv.Itoa(i)+`"}`, "up", func(query string, result model.Value, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				t.Errorf("%s: %v", query, err)
+				return
+			}
+			if v := result.(model.Matrix)[0].Values[0].Value; v != model.SampleValue(i) {
+				t.Errorf("%s handled the result %v", query, v)
+			}
+			handled = append(handled, query)
+		})
+	}
+	q.AddIfAvailable("missing", `missing`, "missing", func(query string, result model.Value, err error) {
+		if !errors.Is(err, ErrNotAvailable) {
+			t.Errorf("%s: error = %v, want %v", query, err, ErrNotAvailable)
+		}
+		handled = append(handled, query)
+	})
+	q.Run()
+
+	var want []string
+	for i := 0; i < queries; i++ {
+		want = append(want, `up{i="`+strconv.Itoa(i)+`"}`)
+	}
+	want = append(want, "missing")
+	if !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %q, want %q", handled, want)
+	}
+	if f.max > concurrency {
+		t.Errorf("%d queries in flight, want at most %d", f.max, concurrency)
+	}
+	if len(q.pending) != 0 {
+		t.Errorf("%d queries left in the batch", len(q.pending))
+	}
+}
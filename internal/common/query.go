@@ -0,0 +1,227 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// Paths of the query API, relative to the Prometheus base URL.
+const (
+	queryPath      = "/api/v1/query"
+	queryRangePath = "/api/v1/query_range"
+)
+
+// Presets of extra query parameters for the Prometheus compatible servers which need them. For Thanos, partial responses are
+// refused, so a missing store fails the query (which is then retried) instead of silently missing data, the replicas are
+// deduplicated and downsampled blocks are used when the step allows it, which speeds up long histories. VictoriaMetrics aligns
+// the range to the step, to cache it, unless the cache is bypassed; bypassing it keeps the timestamps of the samples the same
+// as with Prometheus.
+var queryPresets = map[string]url.Values{
+	"thanos":          {"partial_response": {"false"}, "dedup": {"true"}, "max_source_resolution": {"auto"}},
+	"victoriametrics": {"nocache": {"1"}},
+}
+
+// reservedQueryParams are set by the forwarder itself.
+var reservedQueryParams = map[string]bool{"query": true, "time": true, "start": true, "end": true, "step": true, "stats": true}
+
+// ParseQueryParams returns the extra parameters sent with the range and instant queries: those of the preset, if any, then
+// the query parameters setting, a comma separated list of name=value pairs, which override the parameters of the preset with
+// the same names. A name may be repeated, e.g. replicaLabels[]=replica,replicaLabels[]=prometheus_replica for Thanos.
+func ParseQueryParams(preset, s string) (url.Values, error) {
+	params := url.Values{}
+	if preset = strings.ToLower(strings.TrimSpace(preset)); preset != "" {
+		values, ok := queryPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown query preset %q, must be thanos or victoriametrics", preset)
+		}
+		for name, v := range values {
+			params[name] = append([]string(nil), v...)
+		}
+	}
+	overridden := map[string]bool{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("query parameter %q is not in name=value format", pair)
+		}
+		if reservedQueryParams[name] {
+			return nil, fmt.Errorf("query parameter %s cannot be overridden", name)
+		}
+		if !overridden[name] {
+			params.Del(name)
+			overridden[name] = true
+		}
+		params.Add(name, strings.TrimSpace(value))
+	}
+	return params, nil
+}
+
+// apiResponse is the envelope of the responses of the Prometheus HTTP API.
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType v1.ErrorType    `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// queryData is the data of a query response: the result and, if asked for, the query stats.
+type queryData struct {
+	Type   model.ValueType `json:"resultType"`
+	Result json.RawMessage `json:"result"`
+	Stats  json.RawMessage `json:"stats"`
+}
+
+// promStats holds the stats of a query, as reported by Prometheus (timings and samples) or by VictoriaMetrics (execution time).
+type promStats struct {
+	Timings struct {
+		EvalTotalTime float64 `json:"evalTotalTime"`
+	} `json:"timings"`
+	Samples struct {
+		TotalQueryableSamples int64 `json:"totalQueryableSamples"`
+		PeakSamples           int64 `json:"peakSamples"`
+	} `json:"samples"`
+	ExecutionTimeMsec float64 `json:"executionTimeMsec"`
+}
+
+// evalTime returns the time the server spent evaluating the query.
+func (s *promStats) evalTime() float64 {
+	if s.Timings.EvalTotalTime > 0 {
+		return s.Timings.EvalTotalTime
+	}
+	return s.ExecutionTimeMsec / 1000
+}
+
+// promQuery sends an instant query (if the range has no step) or a range query, with the extra parameters, asking for the query
+// stats, which are returned along with the result if the server reports them. It is the equivalent of Query and QueryRange of
+// the v1 API, which can send neither; errors are reported the same way, so they are classified the same way for retries and
+// splits.
+func promQuery(ctx context.Context, client api.Client, query string, r v1.Range, extra url.Values) (value model.Value, stats *promStats, err error) {
+	path := queryRangePath
+	params := url.Values{}
+	for name, values := range extra {
+		params[name] = values
+	}
+	params.Set("query", query)
+	if r.Step <= 0 {
+		path = queryPath
+		params.Set("time", formatTime(r.End))
+	} else {
+		params.Set("start", formatTime(r.Start))
+		params.Set("end", formatTime(r.End))
+		params.Set("step", strconv.FormatFloat(r.Step.Seconds(), 'f', -1, 64))
+	}
+	params.Set("stats", "all")
+	data, err := postForm(ctx, client, client.URL(path, nil), params)
+	if err != nil {
+		return
+	}
+	var qd queryData
+	if err = json.Unmarshal(data, &qd); err != nil {
+		return
+	}
+	if value, err = decodeResult(qd.Type, qd.Result); err != nil {
+		return
+	}
+	if len(qd.Stats) > 0 {
+		// the format of the stats depends on the server, they are only informative
+		s := &promStats{}
+		if json.Unmarshal(qd.Stats, s) == nil {
+			stats = s
+		}
+	}
+	return
+}
+
+// postForm posts the parameters to the API, falling back to GET if the server does not accept POST, and returns the data of the
+// response.
+func postForm(ctx context.Context, client api.Client, u *url.URL, params url.Values) (json.RawMessage, error) {
+	encoded := params.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// the request is idempotent, so it can be retried by the transport on network errors
+	req.Header["Idempotency-Key"] = nil
+	resp, body, err := client.Do(ctx, req)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		u.RawQuery = encoded
+		if req, err = http.NewRequest(http.MethodGet, u.String(), nil); err != nil {
+			return nil, err
+		}
+		resp, body, err = client.Do(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	code := resp.StatusCode
+	// 400 and 422 are the errors of the API itself, with the error in the body
+	apiError := code == http.StatusBadRequest || code == http.StatusUnprocessableEntity
+	if code/100 != 2 && !apiError {
+		e := &v1.Error{Type: v1.ErrBadResponse, Msg: fmt.Sprintf("bad response code %d", code), Detail: string(body)}
+		switch code / 100 {
+		case 4:
+			e.Type, e.Msg = v1.ErrClient, fmt.Sprintf("client error: %d", code)
+		case 5:
+			e.Type, e.Msg = v1.ErrServer, fmt.Sprintf("server error: %d", code)
+		}
+		return nil, e
+	}
+	var result apiResponse
+	if code != http.StatusNoContent {
+		if err = json.Unmarshal(body, &result); err != nil {
+			return nil, &v1.Error{Type: v1.ErrBadResponse, Msg: err.Error()}
+		}
+	}
+	if result.Status == "error" {
+		return nil, &v1.Error{Type: result.ErrorType, Msg: result.Error}
+	}
+	if apiError {
+		return nil, &v1.Error{Type: v1.ErrBadResponse, Msg: "inconsistent body for response code"}
+	}
+	return result.Data, nil
+}
+
+// decodeResult decodes the result of a query according to its type.
+func decodeResult(t model.ValueType, raw json.RawMessage) (model.Value, error) {
+	var err error
+	switch t {
+	case model.ValMatrix:
+		var m model.Matrix
+		err = json.Unmarshal(raw, &m)
+		return m, err
+	case model.ValVector:
+		var v model.Vector
+		err = json.Unmarshal(raw, &v)
+		return v, err
+	case model.ValScalar:
+		var s model.Scalar
+		err = json.Unmarshal(raw, &s)
+		return &s, err
+	case model.ValString:
+		var s model.String
+		err = json.Unmarshal(raw, &s)
+		return &s, err
+	default:
+		return nil, fmt.Errorf("unexpected value type %q", t)
+	}
+}
+
+// formatTime formats the time as the API expects it, in seconds since the epoch.
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.Unix())+float64(t.Nanosecond())/1e9, 'f', -1, 64)
+}
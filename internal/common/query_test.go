@@ -0,0 +1,254 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// sentRequest is a request received by the stub, with its form.
+type sentRequest struct {
+	method, contentType string
+	idempotencyKey      bool
+	form                url.Values
+}
+
+func TestPostForm(t *testing.T) {
+	params := url.Values{"query": {`up{job="a b"}`}, "time": {"1704164400"}, "replicaLabels[]": {"replica", "rule_replica"}}
+	tests := []struct {
+		name  string
+		codes []int
+		body  string
+		// the methods of the requests sent, and the error returned, if any
+		methods []string
+		err     *v1.Error
+	}{
+		{"post", []int{200}, `{"status":"success","data":{"resultType":"vector","result":[]}}`, []string{"POST"}, nil},
+		{"method not allowed", []int{405, 200}, `{"status":"success","data":{}}`, []string{"POST", "GET"}, nil},
+		{"not implemented", []int{501, 200}, `{"status":"success","data":{}}`, []string{"POST", "GET"}, nil},
+		{"get fails too", []int{405, 405}, `method not allowed`, []string{"POST", "GET"}, &v1.Error{Type: v1.ErrClient, Msg: "client error: 405"}},
+		{"not found", []int{404}, `404 page not found`, []string{"POST"}, &v1.Error{Type: v1.ErrClient, Msg: "client error: 404"}},
+		{"too many requests", []int{429}, `slow down`, []string{"POST"}, &v1.Error{Type: v1.ErrClient, Msg: "client error: 429"}},
+		{"unavailable", []int{503}, `unavailable`, []string{"POST"}, &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}},
+		{"redirect", []int{304}, ``, []string{"POST"}, &v1.Error{Type: v1.ErrBadResponse, Msg: "bad response code 304"}},
+		{"bad data", []int{400}, `{"status":"error","errorType":"bad_data","error":"parse error at char 4"}`, []string{"POST"},
+			&v1.Error{Type: v1.ErrBadData, Msg: "parse error at char 4"}},
+		{"execution error", []int{422}, `{"status":"error","errorType":"execution","error":"exceeded maximum resolution"}`, []string{"POST"},
+			&v1.Error{Type: v1.ErrExec, Msg: "exceeded maximum resolution"}},
+		{"timeout", []int{503}, `{"status":"error","errorType":"timeout","error":"query timed out"}`, []string{"POST"},
+			&v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}},
+		{"error with success code", []int{200}, `{"status":"error","errorType":"timeout","error":"query timed out"}`, []string{"POST"},
+			&v1.Error{Type: v1.ErrTimeout, Msg: "query timed out"}},
+		{"inconsistent body", []int{400}, `{"status":"success","data":{}}`, []string{"POST"},
+			&v1.Error{Type: v1.ErrBadResponse, Msg: "inconsistent body for response code"}},
+		{"not json", []int{200}, `<html>proxy login</html>`, []string{"POST"}, &v1.Error{Type: v1.ErrBadResponse}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sent []sentRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, key := r.Header["Idempotency-Key"]
+				r.ParseForm()
+				sent = append(sent, sentRequest{method: r.Method, contentType: r.Header.Get("Content-Type"), idempotencyKey: key, form: r.Form})
+				w.WriteHeader(test.codes[len(sent)-1])
+				io.WriteString(w, test.body)
+			}))
+			defer srv.Close()
+			client, err := api.NewClient(api.Config{Address: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := postForm(context.Background(), client, client.URL(queryPath, nil), params)
+			var methods []string
+			for _, r := range sent {
+				methods = append(methods, r.method)
+				if !reflect.DeepEqual(r.form, params) {
+					t.Errorf("%s sent the parameters %v, want %v", r.method, r.form, params)
+				}
+				if r.idempotencyKey {
+					t.Errorf("%s sent the Idempotency-Key header", r.method)
+				}
+				if r.method == http.MethodPost && r.contentType != "application/x-www-form-urlencoded" {
+					t.Errorf("POST sent the content type %q", r.contentType)
+				}
+			}
+			if !reflect.DeepEqual(methods, test.methods) {
+				t.Errorf("sent %v, want %v", methods, test.methods)
+			}
+			if test.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				var resp apiResponse
+				json.Unmarshal([]byte(test.body), &resp)
+				if string(data) != string(resp.Data) {
+					t.Errorf("data %s, want %s", data, resp.Data)
+				}
+				return
+			}
+			var apiErr *v1.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if apiErr.Type != test.err.Type || (test.err.Msg != "" && apiErr.Msg != test.err.Msg) {
+				t.Errorf("error %s: %s, want %s: %s", apiErr.Type, apiErr.Msg, test.err.Type, test.err.Msg)
+			}
+		})
+	}
+}
+
+func TestDecodeResult(t *testing.T) {
+	tests := []struct {
+		typ  model.ValueType
+		raw  string
+		want model.Value
+		err  bool
+	}{
+		{model.ValMatrix, `[{"metric":{"job":"a"},"values":[[1704164400,"1"],[1704164700,"2"]]}]`,
+			model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1704164400000, Value: 1}, {Timestamp: 1704164700000, Value: 2}}}}, false},
+		{model.ValVector, `[{"metric":{"job":"a"},"value":[1704164400,"1.5"]}]`,
+			model.Vector{{Metric: model.Metric{"job": "a"}, Timestamp: 1704164400000, Value: 1.5}}, false},
+		{model.ValScalar, `[1704164400,"2"]`, &model.Scalar{Timestamp: 1704164400000, Value: 2}, false},
+		{model.ValString, `[1704164400,"up"]`, &model.String{Timestamp: 1704164400000, Value: "up"}, false},
+		{model.ValMatrix, `{"metric":{}}`, nil, true},
+		{model.ValScalar, `[1704164400,"not a number"]`, nil, true},
+		{model.ValNone, `[]`, nil, true},
+	}
+	for _, test := range tests {
+		value, err := decodeResult(test.typ, json.RawMessage(test.raw))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s %s: decoded %v", test.typ, test.raw, value)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(value, test.want) {
+			t.Errorf("%s %s: decoded %v, %v, want %v", test.typ, test.raw, value, err, test.want)
+		}
+	}
+}
+
+func TestMetricCollectResults(t *testing.T) {
+	var result string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"status":"success","data":`+result+`}`)
+	}))
+	defer srv.Close()
+	args := newTestParameters(t, srv.URL)
+	instant := v1.Range{Start: *args.CurrentTime, End: *args.CurrentTime}
+
+	tests := []struct {
+		name, result string
+		r            v1.Range
+		// the result of Query, and of MetricCollect or its error
+		value model.Value
+		mat   model.Matrix
+		err   string
+	}{
+		{"matrix", `{"resultType":"matrix","result":[{"metric":{"job":"a"},"values":[[1704164400,"1"]]}]}`, TimeRange(args, 0),
+			model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1704164400000, Value: 1}}}},
+			model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1704164400000, Value: 1}}}}, ""},
+		{"vector", `{"resultType":"vector","result":[{"metric":{"job":"a"},"value":[1704164400,"1"]}]}`, instant,
+			model.Vector{{Metric: model.Metric{"job": "a"}, Timestamp: 1704164400000, Value: 1}},
+			model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1704164400000, Value: 1}}}}, ""},
+		{"scalar", `{"resultType":"scalar","result":[1704164400,"2"]}`, instant,
+			&model.Scalar{Timestamp: 1704164400000, Value: 2},
+			model.Matrix{{Metric: model.Metric{}, Values: []model.SamplePair{{Timestamp: 1704164400000, Value: 2}}}}, ""},
+		{"string", `{"resultType":"string","result":[1704164400,"up"]}`, instant,
+			&model.String{Timestamp: 1704164400000, Value: "up"}, nil, "query returned a string, not time series"},
+		{"empty", `{"resultType":"vector","result":[]}`, instant, model.Vector{}, nil, "no data returned"},
+	}
+	for _, test := range tests {
+		result = test.result
+		value, err := Query(context.Background(), args, test.name, test.r, "test", test.name)
+		if err != nil || !reflect.DeepEqual(value, test.value) {
+			t.Errorf("%s: Query returned %v, %v, want %v", test.name, value, err, test.value)
+		}
+		value, err = MetricCollect(context.Background(), args, test.name, test.r, "test", test.name)
+		switch {
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: MetricCollect error %v, want %q", test.name, err, test.err)
+		case test.err == "" && err != nil:
+			t.Errorf("%s: MetricCollect: %v", test.name, err)
+		case test.err == "" && !reflect.DeepEqual(value, test.mat):
+			t.Errorf("%s: MetricCollect returned %v, want %v", test.name, value, test.mat)
+		}
+	}
+}
+
+func TestParseQueryParams(t *testing.T) {
+	tests := []struct {
+		name, preset, s string
+		want            url.Values
+		err             string
+	}{
+		{"none", "", "", url.Values{}, ""},
+		{"blank pairs", "", " , ,", url.Values{}, ""},
+		{"pairs", "", "a=1, b = 2", url.Values{"a": {"1"}, "b": {"2"}}, ""},
+		{"empty value", "", "a=", url.Values{"a": {""}}, ""},
+		{"value with equals", "", "a=b=c", url.Values{"a": {"b=c"}}, ""},
+		{"repeated", "", "replicaLabels[]=replica,replicaLabels[]=prometheus_replica",
+			url.Values{"replicaLabels[]": {"replica", "prometheus_replica"}}, ""},
+		{"thanos", "thanos", "", url.Values{"partial_response": {"false"}, "dedup": {"true"}, "max_source_resolution": {"auto"}}, ""},
+		{"preset case and spaces", " VictoriaMetrics ", "", url.Values{"nocache": {"1"}}, ""},
+		{"preset and explicit", "thanos", "replicaLabels[]=replica,replicaLabels[]=rule_replica", url.Values{"partial_response": {"false"},
+			"dedup": {"true"}, "max_source_resolution": {"auto"}, "replicaLabels[]": {"replica", "rule_replica"}}, ""},
+		{"explicit overrides preset", "thanos", "dedup=false,max_source_resolution=5m,max_source_resolution=1h",
+			url.Values{"partial_response": {"false"}, "dedup": {"false"}, "max_source_resolution": {"5m", "1h"}}, ""},
+		{"unknown preset", "cortex", "", nil, `unknown query preset "cortex"`},
+		{"no value", "", "a", nil, `query parameter "a" is not in name=value format`},
+		{"no name", "", "=1", nil, `query parameter "=1" is not in name=value format`},
+		{"reserved", "", "step=1m", nil, "query parameter step cannot be overridden"},
+		{"reserved with preset", "thanos", "dedup=false,query=up", nil, "query parameter query cannot be overridden"},
+	}
+	for _, test := range tests {
+		params, err := ParseQueryParams(test.preset, test.s)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(params, test.want) {
+			t.Errorf("%s: parsed %v, %v, want %v", test.name, params, err, test.want)
+		}
+	}
+	// the preset values are copied, not shared
+	params, _ := ParseQueryParams("thanos", "")
+	params.Add("dedup", "false")
+	if got := queryPresets["thanos"]["dedup"]; !reflect.DeepEqual(got, []string{"true"}) {
+		t.Errorf("the thanos preset was changed to dedup=%v", got)
+	}
+}
+
+func TestConfigRange(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lookback time.Duration
+		want     v1.Range
+	}{
+		{0, v1.Range{Start: now, End: now}},
+		{time.Hour, v1.Range{Start: now.Add(-time.Hour), End: now, Step: 5 * time.Minute}},
+		// the lookback is rounded up to whole steps
+		{7 * time.Minute, v1.Range{Start: now.Add(-10 * time.Minute), End: now, Step: 5 * time.Minute}},
+	}
+	for _, test := range tests {
+		args := &Parameters{CurrentTime: &now, SampleRate: 5, InstantLookback: test.lookback}
+		if r := ConfigRange(args); r != test.want {
+			t.Errorf("ConfigRange() with lookback %s = %+v, want %+v", test.lookback, r, test.want)
+		}
+	}
+}
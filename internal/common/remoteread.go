@@ -0,0 +1,58 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/remoteread"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// RemoteRead reads the raw samples of the series of a metric through the remote-read API of the Prometheus endpoint the metric
+// is routed to, and calls f for every series as it is received. The query counts against the same limits as the range queries.
+// It is neither retried nor failed over, as the series may have been partly handled already: callers fall back to range
+// queries instead. The stats of the read are recorded for the query stats file.
+func RemoteRead(ctx context.Context, args *Parameters, q remoteread.Query, entityKind, metricName string, f func(*remoteread.Series) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if args.httpClient == nil {
+		return errors.New("Prometheus client is not initialized")
+	}
+	var metric string
+	for _, m := range q.Matchers {
+		if m.Name == "__name__" && m.Type == remoteread.MatchEqual {
+			metric = m.Value
+		}
+	}
+	if args.Debug {
+		msg := fmt.Sprintf("RemoteRead: entity = %s metric = %s series = %s", entityKind, metricName, metric)
+		args.DebugLogger.Println(msg)
+		fmt.Println("[DEBUG] " + msg)
+	}
+	set := endpointsFor(args, metric)
+	ep := set.active()
+	st := newQueryStat(entityKind, metricName, metric, queryTypeRemoteRead, v1.Range{Start: q.Start, End: q.End, Step: q.Step})
+	st.Endpoint = ep.url
+	defer func() { args.stats.record(st, err) }()
+	release, err := args.throttle.acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, durationOrDefault(args.QueryTimeout, DefaultQueryTimeout))
+	defer cancel()
+	st.addRequest(nil)
+	err = remoteread.Read(ctx, args.httpClient, strings.TrimRight(ep.base, "/")+remoteread.Path, q, func(s *remoteread.Series) error {
+		st.Series++
+		st.Samples += len(s.Samples)
+		return f(s)
+	})
+	if err == nil {
+		set.succeeded(ep)
+		args.served.record(entityKind, ep.url)
+	}
+	return
+}
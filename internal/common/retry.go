@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Default values of the query retry settings, used when they are not set in the configuration.
+const (
+	DefaultRetries         = 3
+	DefaultRetryBackoff    = time.Second
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// Prometheus error types which are not exposed as constants by the client library.
+const (
+	errUnavailable v1.ErrorType = "unavailable"
+	errInternal    v1.ErrorType = "internal"
+)
+
+// isTransient classifies a failed query: transient errors (server errors, throttling, timeouts and dropped connections)
+// are worth retrying, while permanent ones (bad PromQL, other client errors) will fail the same way again.
+func isTransient(err error) bool {
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case v1.ErrServer, v1.ErrTimeout, v1.ErrCanceled, errUnavailable, errInternal:
+			return true
+		case v1.ErrClient:
+			// the client library only reports the status code of 4xx responses in the message
+			return strings.Contains(apiErr.Msg, strconv.Itoa(http.StatusTooManyRequests))
+		default:
+			return false
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns how long to wait before the given retry (starting at 1): exponential growth from the
+// configured initial backoff up to the configured maximum, with half of it randomized to spread the retries.
+func backoff(args *Parameters, retry int) time.Duration {
+	initial := durationOrDefault(args.RetryBackoff, DefaultRetryBackoff)
+	max := durationOrDefault(args.RetryMaxBackoff, DefaultRetryMaxBackoff)
+	d := initial
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// withRetries runs the query function until it succeeds, fails with a permanent error or the configured retries are exhausted.
+// Every failed attempt is logged with the entity and metric name. It gives up as soon as the run context is done.
+func withRetries(ctx context.Context, args *Parameters, entityKind, metricName string, f func() error) (err error) {
+	attempts := args.Retries + 1
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = f(); err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			return
+		}
+		transient := isTransient(err)
+		msg := fmt.Sprintf("entity=%s metric=%s attempt=%d/%d transient=%t message=%s", entityKind, metricName, attempt, attempts, transient, err.Error())
+		args.WarnLogger.Println(msg)
+		fmt.Println("[WARNING] " + msg)
+		if !transient || attempt == attempts {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(backoff(args, attempt)):
+		}
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// newTestParameters returns the parameters of a run against the Prometheus at the URL, with the client initialized, quick
+// retries and the logs discarded.
+func newTestParameters(t *testing.T, promURL string) *Parameters {
+	t.Helper()
+	interval, clusterName := "hours", "test"
+	intervalSize, history, offset := 1, 1, 0
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	logger := log.New(io.Discard, "", 0)
+	args := &Parameters{
+		ClusterName:     &clusterName,
+		PromURL:         &promURL,
+		PromAddress:     &clusterName,
+		Interval:        &interval,
+		IntervalSize:    &intervalSize,
+		History:         &history,
+		Offset:          &offset,
+		CurrentTime:     &now,
+		SampleRate:      5,
+		InfoLogger:      logger,
+		WarnLogger:      logger,
+		ErrorLogger:     logger,
+		DebugLogger:     logger,
+		Retries:         3,
+		RetryBackoff:    time.Millisecond,
+		RetryMaxBackoff: 4 * time.Millisecond,
+	}
+	if err := InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+	return args
+}
+
+const matrixResponse = `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up","job":"a"},"values":[[1704164400,"1"]]}]}}`
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}, true},
+		{"timeout", &v1.Error{Type: v1.ErrTimeout}, true},
+		{"canceled", &v1.Error{Type: v1.ErrCanceled}, true},
+		{"unavailable", &v1.Error{Type: errUnavailable}, true},
+		{"internal", &v1.Error{Type: errInternal}, true},
+		{"too many requests", &v1.Error{Type: v1.ErrClient, Msg: "client error: 429"}, true},
+		{"not found", &v1.Error{Type: v1.ErrClient, Msg: "client error: 404"}, false},
+		{"unauthorized", &v1.Error{Type: v1.ErrClient, Msg: "client error: 401"}, false},
+		{"bad PromQL", &v1.Error{Type: v1.ErrBadData, Msg: "parse error"}, false},
+		{"bad response", &v1.Error{Type: v1.ErrBadResponse}, false},
+		{"wrapped server error", fmt.Errorf("source a: %w", &v1.Error{Type: v1.ErrServer}), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"connection reset", &url.Error{Op: "Post", URL: "http://prom", Err: syscall.ECONNRESET}, true},
+		{"connection refused", &url.Error{Op: "Post", URL: "http://prom", Err: syscall.ECONNREFUSED}, true},
+		{"broken pipe", syscall.EPIPE, true},
+		{"EOF", io.EOF, true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"other", errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	args := &Parameters{RetryBackoff: time.Second, RetryMaxBackoff: 5 * time.Second}
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			if d := backoff(args, tt.retry); d < tt.max/2 || d > tt.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.retry, d, tt.max/2, tt.max)
+			}
+		}
+	}
+	// the defaults apply when the settings are not set
+	if d := backoff(&Parameters{}, 1); d < DefaultRetryBackoff/2 || d > DefaultRetryBackoff {
+		t.Errorf("backoff with the defaults = %s", d)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		wantErr  bool
+		wantReqs int32
+	}{
+		{"throttled then unavailable then ok", []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}, 3, false, 3},
+		{"ok", []int{http.StatusOK}, 3, false, 1},
+		{"retries exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 2, true, 3},
+		{"permanent error", []int{http.StatusNotFound, http.StatusOK}, 3, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				status := tt.statuses[len(tt.statuses)-1]
+				if int(n) <= len(tt.statuses) {
+					status = tt.statuses[n-1]
+				}
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					io.WriteString(w, matrixResponse)
+				}
+			}))
+			defer srv.Close()
+			args := newTestParameters(t, srv.URL)
+			args.Retries = tt.retries
+			_, err := MetricCollect(context.Background(), args, "up", TimeRange(args, 0), "test", "up")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantReqs {
+				t.Errorf("%d requests, want %d", got, tt.wantReqs)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc captures the requests sent by a round tripper.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// The credentials, clock and expected signatures of the get-vanilla and post-x-www-form-urlencoded cases of the AWS Signature
+// Version 4 test suite.
+const (
+	sigV4TestAccessKey = "AKIDEXAMPLE"
+	sigV4TestSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+var sigV4TestTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func newTestSigV4RoundTripper(t *testing.T, next http.RoundTripper) http.RoundTripper {
+	t.Helper()
+	args := &Parameters{SigV4Region: "us-east-1", SigV4Service: "service", SigV4AccessKey: sigV4TestAccessKey, SigV4SecretKey: sigV4TestSecretKey}
+	rt, err := newSigV4RoundTripper(args, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.(*sigV4RoundTripper).now = func() time.Time { return sigV4TestTime }
+	return rt
+}
+
+func TestSigV4Signature(t *testing.T) {
+	tests := []struct {
+		name          string
+		method, body  string
+		header        http.Header
+		authorization string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        http.MethodPost,
+			body:          "Param1=value1",
+			header:        http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent *http.Request
+			var sentBody string
+			rt := newTestSigV4RoundTripper(t, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				sent = req
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					sentBody = string(b)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}))
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com/", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			// headers without values are not sent, so they are not signed
+			req.Header["Idempotency-Key"] = nil
+			if _, err = rt.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if got := sent.Header.Get("Authorization"); got != tt.authorization {
+				t.Errorf("Authorization = %s\nwant %s", got, tt.authorization)
+			}
+			if got := sent.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+			if _, ok := sent.Header["Idempotency-Key"]; !ok {
+				t.Error("the Idempotency-Key header was not put back")
+			}
+			if sentBody != tt.body {
+				t.Errorf("body %q, want %q", sentBody, tt.body)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("the original request was modified")
+			}
+		})
+	}
+}
+
+// TestSigV4Stub checks a signed query reaches a stub Prometheus with its body and signature.
+func TestSigV4Stub(t *testing.T) {
+	var authorization, query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		r.ParseForm()
+		query = r.Form.Get("query")
+		io.WriteString(w, matrixResponse)
+	}))
+	defer srv.Close()
+	args := newTestParameters(t, srv.URL)
+	args.SigV4Region, args.SigV4AccessKey, args.SigV4SecretKey = "eu-west-1", sigV4TestAccessKey, sigV4TestSecretKey
+	if err := InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MetricCollect(context.Background(), args, "up", TimeRange(args, 0), "test", "up"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, "/eu-west-1/aps/aws4_request") {
+		t.Errorf("Authorization = %s", authorization)
+	}
+	if query != "up" {
+		t.Errorf("query = %q, the body was not sent", query)
+	}
+}
@@ -0,0 +1,225 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Metric families which can be routed to a named source, by the prefix of their metric names.
+var metricFamilies = []string{"kube", "node", "container", "openshift"}
+
+// PromQL words which are not metric names.
+var promQLKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+	"bool": true, "and": true, "or": true, "unless": true, "offset": true,
+}
+
+// ParseSources parses the named sources setting: semicolon separated name=URL pairs, where the URL can be a comma
+// separated list of equivalent endpoints used with failover. Ex: ksm=http://prom-ksm:9090;nodes=http://prom-a:9090,http://prom-b:9090
+func ParseSources(s string) (map[string][]string, error) {
+	sources := map[string][]string{}
+	for _, source := range strings.Split(s, ";") {
+		if strings.TrimSpace(source) == "" {
+			continue
+		}
+		name, urls, found := strings.Cut(source, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("source %q is not in name=URL format", source)
+		}
+		for _, u := range strings.Split(urls, ",") {
+			if strings.TrimSpace(u) == "" {
+				continue
+			}
+			pu, err := ParsePrometheusURL(u)
+			if err != nil {
+				return nil, fmt.Errorf("source %s: %w", name, err)
+			}
+			sources[name] = append(sources[name], pu.String())
+		}
+		if len(sources[name]) == 0 {
+			return nil, fmt.Errorf("source %s has no URL", name)
+		}
+	}
+	return sources, nil
+}
+
+// ParseRoutes parses the routes setting: comma separated family=source pairs, where family is one of kube, node, container
+// and openshift and source is the name of a source, an unrouted family going to the default endpoints. Ex: kube=ksm,node=nodes.
+// The container and node metrics joined with those of kube-state-metrics are joined by the forwarder when they are routed to
+// different sources, see CollectJoin.
+func ParseRoutes(s string, sources map[string][]string) (map[string]string, error) {
+	routes := map[string]string{}
+	for _, route := range strings.Split(s, ",") {
+		if strings.TrimSpace(route) == "" {
+			continue
+		}
+		family, source, found := strings.Cut(route, "=")
+		family = strings.ToLower(strings.TrimSpace(family))
+		source = strings.TrimSpace(source)
+		if !found || family == "" || source == "" {
+			return nil, fmt.Errorf("route %q is not in family=source format", route)
+		}
+		known := false
+		for _, f := range metricFamilies {
+			known = known || f == family
+		}
+		if !known {
+			return nil, fmt.Errorf("route %q has unknown metric family %s, valid values are %s", route, family, strings.Join(metricFamilies, ", "))
+		}
+		if _, ok := sources[source]; !ok {
+			return nil, fmt.Errorf("route %q refers to undefined source %s", route, source)
+		}
+		routes[family] = source
+	}
+	return routes, nil
+}
+
+// newSources creates the endpoint sets of the named sources which are used by a route.
+func newSources(args *Parameters, roundTripper http.RoundTripper) (map[string]*endpointSet, error) {
+	sources := map[string]*endpointSet{}
+	for _, name := range args.PromRoutes {
+		if _, ok := sources[name]; ok {
+			continue
+		}
+		set, err := newEndpointSet(args.PromSources[name], roundTripper)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		set.name = name
+		sources[name] = set
+	}
+	return sources, nil
+}
+
+// endpointsFor returns the endpoints the query is routed to: the source of the family of its (first) metric if there is a
+// route for it, the default endpoints otherwise. See routeQuery for the queries joining metrics of several families.
+func endpointsFor(args *Parameters, query string) *endpointSet {
+	if len(args.sources) > 0 {
+		if family, _, found := strings.Cut(firstMetricName(query), "_"); found {
+			if name, ok := args.PromRoutes[family]; ok {
+				return args.sources[name]
+			}
+		}
+	}
+	return args.endpoints
+}
+
+// routeQuery returns the endpoints the query is routed to, failing if its metrics are routed to different sources: no single
+// Prometheus has all of them, so the query would quietly return nothing. Such joins go through CollectJoin.
+func routeQuery(args *Parameters, query string) (*endpointSet, error) {
+	set := endpointsFor(args, query)
+	if len(args.sources) == 0 {
+		return set, nil
+	}
+	names := metricNames(query)
+	for _, name := range names {
+		if endpointsFor(args, name) != set {
+			return nil, fmt.Errorf("query joins %s and %s, which are routed to different sources", names[0], name)
+		}
+	}
+	return set, nil
+}
+
+// allEndpointSets returns the default endpoints followed by the named sources, sorted by name.
+func allEndpointSets(args *Parameters) []*endpointSet {
+	sets := []*endpointSet{args.endpoints}
+	var names []string
+	for name := range args.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sets = append(sets, args.sources[name])
+	}
+	return sets
+}
+
+// checkSourcesHealth checks the endpoints of the named sources; each source needs at least one healthy endpoint.
+func checkSourcesHealth(ctx context.Context, args *Parameters) error {
+	for _, set := range allEndpointSets(args)[1:] {
+		version, err := set.checkHealth(ctx, args)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", set.name, err)
+		}
+		msg := fmt.Sprintf("source=%s message=detected Prometheus version %s", set.name, version)
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	return nil
+}
+
+// firstMetricName returns the first metric name of the PromQL query.
+func firstMetricName(query string) string {
+	if names := metricNames(query); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// metricNames returns the metric names of the PromQL query, in order, skipping string literals, label matchers, range
+// selectors, numbers, functions and the label lists of aggregations and vector matching.
+func metricNames(query string) (names []string) {
+	isIdent := func(c byte) bool {
+		return c == '_' || c == ':' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+	}
+	nesting, skipList := 0, false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(query) && query[j] != c {
+				if query[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			i = j + 1
+		case c == '{' || c == '[':
+			nesting++
+			i++
+		case c == '}' || c == ']':
+			nesting--
+			i++
+		case c == ')':
+			skipList = false
+			i++
+		case unicode.IsDigit(rune(c)):
+			// numbers, including durations such as 5m and scientific notation
+			for i < len(query) && (isIdent(query[i]) || query[i] == '.') {
+				i++
+			}
+		case isIdent(c):
+			j := i
+			for j < len(query) && isIdent(query[j]) {
+				j++
+			}
+			word := query[i:j]
+			i = j
+			rest := strings.TrimLeftFunc(query[i:], unicode.IsSpace)
+			k := 0
+			for k < len(rest) && isIdent(rest[k]) {
+				k++
+			}
+			next := strings.ToLower(rest[:k])
+			switch {
+			case nesting > 0 || skipList:
+			case promQLKeywords[strings.ToLower(word)]:
+				// the label list of by, without, on, ignoring and group modifiers
+				skipList = strings.HasPrefix(rest, "(")
+			case strings.HasPrefix(rest, "("), next == "by", next == "without":
+				// function or aggregation
+			default:
+				names = append(names, word)
+			}
+		default:
+			i++
+		}
+	}
+	return
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestMetricNames(t *testing.T) {
+	tests := []struct {
+		query string
+		names []string
+	}{
+		{`up`, []string{"up"}},
+		{`kube_pod_info{namespace="kube-system", pod=~"a.*"}`, []string{"kube_pod_info"}},
+		{`rate(container_cpu_usage_seconds_total{container!="POD"}[5m]) * 1e3`, []string{"container_cpu_usage_seconds_total"}},
+		{`sum(node_memory_MemTotal_bytes) by (instance)`, []string{"node_memory_MemTotal_bytes"}},
+		{`sum by (instance) (node_memory_MemTotal_bytes offset 1h)`, []string{"node_memory_MemTotal_bytes"}},
+		{`max(container_spec_memory_limit_bytes * on (pod, namespace) group_left (owner_name) max(kube_pod_owner{owner_name="<none>"}) by (namespace, pod)) by (pod)`,
+			[]string{"container_spec_memory_limit_bytes", "kube_pod_owner"}},
+		{`label_replace(kube_pod_owner{owner_kind="Job"}, "job", "$1", "owner_name", "(.*)")`, []string{"kube_pod_owner"}},
+		{`kube_node_info and ignoring (a, b) node_load1 or vector(0)`, []string{"kube_node_info", "node_load1"}},
+		{`count(kube_pod_info{node="a\"b"}) by (node)`, []string{"kube_pod_info"}},
+		{`1 + 1`, nil},
+		{`"kube_pod_info"`, nil},
+	}
+	for _, test := range tests {
+		if names := metricNames(test.query); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("metricNames(%q) = %q, want %q", test.query, names, test.names)
+		}
+		first := ""
+		if len(test.names) > 0 {
+			first = test.names[0]
+		}
+		if name := firstMetricName(test.query); name != first {
+			t.Errorf("firstMetricName(%q) = %q, want %q", test.query, name, first)
+		}
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	sources := map[string][]string{"ksm": {"http://ksm:9090"}, "ocp": {"http://ocp:9090"}}
+	tests := []struct {
+		routes string
+		want   map[string]string
+		err    string
+	}{
+		{"", map[string]string{}, ""},
+		{"openshift=ocp", map[string]string{"openshift": "ocp"}, ""},
+		{"kube=ksm, container=ksm,node=ksm,openshift=ocp", map[string]string{"kube": "ksm", "container": "ksm", "node": "ksm", "openshift": "ocp"}, ""},
+		{"kube", nil, "not in family=source format"},
+		{"pod=ksm", nil, "unknown metric family pod"},
+		{"kube=nodes", nil, "undefined source nodes"},
+		{"kube=ksm,node=ocp", map[string]string{"kube": "ksm", "node": "ocp"}, ""},
+	}
+	for _, test := range tests {
+		routes, err := ParseRoutes(test.routes, sources)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("ParseRoutes(%q): %v", test.routes, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("ParseRoutes(%q) error = %v, want %q", test.routes, err, test.err)
+		case test.err == "" && !reflect.DeepEqual(routes, test.want):
+			t.Errorf("ParseRoutes(%q) = %v, want %v", test.routes, routes, test.want)
+		}
+	}
+}
+
+func TestRouteQuery(t *testing.T) {
+	def, ksm, ocp := &endpointSet{}, &endpointSet{name: "ksm"}, &endpointSet{name: "ocp"}
+	args := &Parameters{
+		PromRoutes: map[string]string{"kube": "ksm", "container": "ksm", "openshift": "ocp"},
+		endpoints:  def,
+		sources:    map[string]*endpointSet{"ksm": ksm, "ocp": ocp},
+	}
+	tests := []struct {
+		query string
+		set   *endpointSet
+		err   string
+	}{
+		{`up`, def, ""},
+		{`1 + 1`, def, ""},
+		{`sum(kube_pod_info) by (node)`, ksm, ""},
+		{`container_cpu_usage_seconds_total * on (pod, namespace) group_left max(kube_pod_owner) by (namespace, pod)`, ksm, ""},
+		{`openshift_clusterresourcequota_usage`, ocp, ""},
+		{`node_load1`, def, ""},
+		{`node_load1 * on (node) group_left max(kube_pod_info) by (node)`, nil, "query joins node_load1 and kube_pod_info"},
+		{`kube_node_info * on (node) group_left openshift_clusterresourcequota_usage`, nil, "which are routed to different sources"},
+	}
+	for _, test := range tests {
+		set, err := routeQuery(args, test.query)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("routeQuery(%q): %v", test.query, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("routeQuery(%q) error = %v, want %q", test.query, err, test.err)
+		case set != test.set:
+			t.Errorf("routeQuery(%q) routed to source %q", test.query, set.name)
+		}
+	}
+
+	// without named sources every query goes to the default endpoints
+	args = &Parameters{endpoints: def}
+	if set, err := routeQuery(args, `node_load1 * on (node) group_left kube_pod_info`); err != nil || set != def {
+		t.Errorf("routeQuery without sources = %v, %v", set, err)
+	}
+}
+
+// The routing of queries as the collectors send them, with the kube, container and node families on three sources.
+func TestRouteCollectorQueries(t *testing.T) {
+	def, ksm, cadvisor, nodes := &endpointSet{}, &endpointSet{name: "ksm"}, &endpointSet{name: "cadvisor"}, &endpointSet{name: "nodes"}
+	args := &Parameters{
+		PromRoutes: map[string]string{"kube": "ksm", "container": "cadvisor", "node": "nodes"},
+		endpoints:  def,
+		sources:    map[string]*endpointSet{"ksm": ksm, "cadvisor": cadvisor, "nodes": nodes},
+	}
+	nodeLabels := Match{On: []model.LabelName{"node"}, Right: true, Info: `kube_node_labels{label_pool=~".+"}`}
+	tests := []struct {
+		join  *Join
+		names []string
+		sets  []*endpointSet
+	}{
+		{ownerJoin, []string{"container_memory_rss", "kube_pod_owner"}, []*endpointSet{cadvisor, ksm}},
+		{&Join{Aggregator: "max", Query: `irate(container_cpu_usage_seconds_total{name!~"k8s_POD_.*"}[5m])`, By: []model.LabelName{"owner_name", "namespace", "container"}, Matches: []Match{
+			{On: []model.LabelName{"pod", "namespace"}, Include: []model.LabelName{"replicaset"}, Info: `max(label_replace(kube_pod_owner{owner_kind="ReplicaSet"}, "replicaset", "$1", "owner_name", "(.*)")) by (namespace, pod, replicaset)`},
+			{On: []model.LabelName{"replicaset", "namespace"}, Include: []model.LabelName{"owner_name"}, Info: `max(kube_replicaset_owner{owner_kind="Deployment"}) by (namespace, replicaset, owner_name)`}}},
+			[]string{"container_cpu_usage_seconds_total", "kube_pod_owner", "kube_replicaset_owner"}, []*endpointSet{cadvisor, ksm, ksm}},
+		{&Join{Aggregator: "max", Query: ByPodIP("max", `sum(irate(node_cpu_seconds_total{mode!="idle"}[5m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100`), Matches: []Match{NodeExporterPods}, By: []model.LabelName{"node"}},
+			[]string{"node_cpu_seconds_total", "node_cpu_seconds_total", "kube_pod_info"}, []*endpointSet{nodes, ksm}},
+		{&Join{Aggregator: "avg", Query: ByPodIP("sum", `irate(node_disk_written_bytes_total{device!~"dm-.*"}[5m])`), Matches: []Match{NodeExporterPods, nodeLabels}, By: []model.LabelName{"label_pool"}},
+			[]string{"node_disk_written_bytes_total", "kube_pod_info", "kube_node_labels"}, []*endpointSet{nodes, ksm, ksm}},
+		{&Join{Aggregator: "avg", Query: `label_replace(node_memory_MemTotal_bytes - node_memory_MemFree_bytes, "node", "$1", "instance", "(.*):*")`, Matches: []Match{nodeLabels}, By: []model.LabelName{"label_pool"}},
+			[]string{"node_memory_MemTotal_bytes", "node_memory_MemFree_bytes", "kube_node_labels"}, []*endpointSet{nodes, ksm}},
+	}
+	for _, test := range tests {
+		query := test.join.String()
+		if names := metricNames(query); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("metricNames(%q) = %q, want %q", query, names, test.names)
+		}
+		if _, err := routeQuery(args, query); err == nil || !strings.Contains(err.Error(), "which are routed to different sources") {
+			t.Errorf("routeQuery(%q) error = %v", query, err)
+		}
+		// the sides of the join are collected on their own
+		parts := []string{test.join.Query}
+		for _, m := range test.join.Matches {
+			parts = append(parts, m.Info)
+		}
+		for i, part := range parts {
+			if set, err := routeQuery(args, part); err != nil || set != test.sets[i] {
+				t.Errorf("routeQuery(%q) = %v, %v, want source %q", part, set, err, test.sets[i].name)
+			}
+		}
+	}
+
+	// the queries within a family are sent as they are
+	for query, set := range map[string]*endpointSet{
+		`avg(kube_node_status_capacity * on (node) group_left (label_pool) kube_node_labels{label_pool=~".+"}) by (label_pool,resource)`:                                                 ksm,
+		`sum(kube_pod_container_resource_limits{resource="cpu"}) by (pod,namespace,container) * on (namespace,pod) group_left max(kube_pod_owner{owner_kind!="Job"}) by (namespace,pod)`: ksm,
+		`sum(irate(node_cpu_seconds_total{mode!="idle"}[5m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100`:                    nodes,
+		`max(sum(container_spec_memory_limit_bytes{name!~"k8s_POD_.*"}) by (instance,pod,namespace,container)) by (pod,namespace,container)`:                                             cadvisor,
+	} {
+		if s, err := routeQuery(args, query); err != nil || s != set {
+			t.Errorf("routeQuery(%q) = %v, %v, want source %q", query, s, err, set.name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// maxRangeSplits limits how many times a range is halved, so a query is never split into more than 2^maxRangeSplits sub-ranges.
+const maxRangeSplits = 6
+
+// Fragments of the errors Prometheus returns when a range query is too large to be evaluated in one go.
+var tooLargeMessages = []string{
+	"exceeded maximum resolution",
+	"would load too many samples",
+}
+
+// isTooLarge reports whether Prometheus rejected the query because of the size of its range.
+func isTooLarge(err error) bool {
+	var apiErr *v1.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, msg := range tooLargeMessages {
+		if strings.Contains(apiErr.Msg, msg) || strings.Contains(apiErr.Detail, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRange halves the range on a step boundary. The second half starts one step after the first one ends,
+// so no sample is returned twice. It fails if the range is too short to be split.
+func splitRange(r v1.Range) (first, second v1.Range, ok bool) {
+	if r.Step <= 0 {
+		return
+	}
+	steps := int64(r.End.Sub(r.Start) / r.Step)
+	if steps < 2 {
+		return
+	}
+	mid := r.Start.Add(time.Duration(steps/2) * r.Step)
+	first = v1.Range{Start: r.Start, End: mid, Step: r.Step}
+	second = v1.Range{Start: mid.Add(r.Step), End: r.End, Step: r.Step}
+	ok = true
+	return
+}
+
+// mergeMatrices stitches the series of two consecutive sub-ranges together. Series keep the order in which they first appear.
+func mergeMatrices(first, second model.Matrix) model.Matrix {
+	merged := make(model.Matrix, 0, len(first))
+	series := make(map[model.Fingerprint]*model.SampleStream, len(first))
+	for _, m := range []model.Matrix{first, second} {
+		for _, ss := range m {
+			fp := ss.Metric.Fingerprint()
+			if existing, ok := series[fp]; ok {
+				existing.Values = append(existing.Values, ss.Values...)
+			} else {
+				series[fp] = ss
+				merged = append(merged, ss)
+			}
+		}
+	}
+	return merged
+}
+
+// queryRange runs the range query (with retries); if Prometheus rejects it as too large, the range is split
+// in two and each half is queried separately, recursively, and the results are stitched back together.
+func queryRange(ctx context.Context, args *Parameters, client api.Client, query string, r v1.Range, entityKind, metricName string, depth int, st *queryStat) (value model.Value, err error) {
+	err = withRetries(ctx, args, entityKind, metricName, func() (qErr error) {
+		value, qErr = queryRangeOnce(ctx, args, client, query, r, st)
+		return
+	})
+	if err == nil || !isTooLarge(err) || depth >= maxRangeSplits {
+		return
+	}
+	first, second, ok := splitRange(r)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("entity=%s metric=%s message=query range too large, splitting %s - %s in two", entityKind, metricName, Format(&r.Start), Format(&r.End))
+	args.InfoLogger.Println(msg)
+	fmt.Println("[INFO] " + msg)
+	var v1st, v2nd model.Value
+	if v1st, err = queryRange(ctx, args, client, query, first, entityKind, metricName, depth+1, st); err != nil {
+		return
+	}
+	if v2nd, err = queryRange(ctx, args, client, query, second, entityKind, metricName, depth+1, st); err != nil {
+		return
+	}
+	m1st, ok1 := asMatrix(v1st)
+	m2nd, ok2 := asMatrix(v2nd)
+	if !ok1 || !ok2 {
+		err = errors.New("cannot merge split results which are not range vectors")
+		return
+	}
+	value = mergeMatrices(m1st, m2nd)
+	return
+}
+
+// asMatrix returns the value as a matrix, an empty one if there is no value at all.
+func asMatrix(value model.Value) (model.Matrix, bool) {
+	if value == nil {
+		return model.Matrix{}, true
+	}
+	m, ok := value.(model.Matrix)
+	return m, ok
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// tooLargeResponse is the error of Prometheus for a range with too many steps.
+const tooLargeResponse = `{"status":"error","errorType":"bad_data","error":"exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)"}`
+
+// newSplitServer returns a stub which rejects the ranges of more than maxSteps steps as too large and returns two series
+// with a sample per step otherwise, whose value is the timestamp. maxSteps 0 rejects every range.
+func newSplitServer(maxSteps int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		r.ParseForm()
+		start, _ := strconv.ParseFloat(r.Form.Get("start"), 64)
+		end, _ := strconv.ParseFloat(r.Form.Get("end"), 64)
+		step, _ := strconv.ParseFloat(r.Form.Get("step"), 64)
+		if maxSteps == 0 || int((end-start)/step) > maxSteps {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, tooLargeResponse)
+			return
+		}
+		var values []string
+		for ts := start; ts <= end; ts += step {
+			values = append(values, fmt.Sprintf(`[%d,"%d"]`, int64(ts), int64(ts)))
+		}
+		var series []string
+		for _, job := range []string{"b", "a"} {
+			series = append(series, fmt.Sprintf(`{"metric":{"job":%q},"values":[%s]}`, job, strings.Join(values, ",")))
+		}
+		io.WriteString(w, `{"status":"success","data":{"resultType":"matrix","result":[`+strings.Join(series, ",")+`]}}`)
+	}))
+}
+
+func TestSplitRange(t *testing.T) {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	step := 5 * time.Minute
+	tests := []struct {
+		name       string
+		r          v1.Range
+		ok         bool
+		firstEnd   time.Time
+		secondFrom time.Time
+	}{
+		{"even steps", v1.Range{Start: start, End: start.Add(12 * step), Step: step}, true, start.Add(6 * step), start.Add(7 * step)},
+		{"odd steps", v1.Range{Start: start, End: start.Add(11 * step), Step: step}, true, start.Add(5 * step), start.Add(6 * step)},
+		{"end off the step", v1.Range{Start: start, End: start.Add(10*step + time.Minute), Step: step}, true, start.Add(5 * step), start.Add(6 * step)},
+		{"two steps", v1.Range{Start: start, End: start.Add(2 * step), Step: step}, true, start.Add(step), start.Add(2 * step)},
+		{"one step", v1.Range{Start: start, End: start.Add(step), Step: step}, false, time.Time{}, time.Time{}},
+		{"instant", v1.Range{Start: start, End: start}, false, time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second, ok := splitRange(tt.r)
+			if ok != tt.ok {
+				t.Fatalf("ok = %t, want %t", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !first.Start.Equal(tt.r.Start) || !first.End.Equal(tt.firstEnd) || !second.Start.Equal(tt.secondFrom) || !second.End.Equal(tt.r.End) {
+				t.Errorf("split into %s - %s and %s - %s", first.Start, first.End, second.Start, second.End)
+			}
+			if first.Step != tt.r.Step || second.Step != tt.r.Step {
+				t.Errorf("steps %s and %s, want %s", first.Step, second.Step, tt.r.Step)
+			}
+			if first.End.Sub(first.Start)%tt.r.Step != 0 {
+				t.Errorf("first half %s - %s does not end on a step", first.Start, first.End)
+			}
+		})
+	}
+}
+
+func TestMergeMatrices(t *testing.T) {
+	a := model.Metric{"job": "a"}
+	b := model.Metric{"job": "b"}
+	first := model.Matrix{
+		{Metric: b, Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}}},
+		{Metric: a, Values: []model.SamplePair{{Timestamp: 1, Value: 1}}},
+	}
+	second := model.Matrix{
+		{Metric: model.Metric{"job": "c"}, Values: []model.SamplePair{{Timestamp: 3, Value: 3}}},
+		{Metric: a, Values: []model.SamplePair{{Timestamp: 3, Value: 3}}},
+		{Metric: b, Values: []model.SamplePair{{Timestamp: 3, Value: 3}}},
+	}
+	merged := mergeMatrices(first, second)
+	var got []string
+	for _, ss := range merged {
+		got = append(got, fmt.Sprintf("%s%v", ss.Metric["job"], ss.Values))
+	}
+	want := []string{"b[1 @[0.001] 2 @[0.002] 3 @[0.003]]", "a[1 @[0.001] 3 @[0.003]]", "c[3 @[0.003]]"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+}
+
+func TestQueryRangeSplit(t *testing.T) {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	step := 5 * time.Minute
+	for _, steps := range []int{12, 13, 100} {
+		t.Run(fmt.Sprintf("%d steps", steps), func(t *testing.T) {
+			var requests int32
+			srv := newSplitServer(4, &requests)
+			defer srv.Close()
+			args := newTestParameters(t, srv.URL)
+			r := v1.Range{Start: start, End: start.Add(time.Duration(steps) * step), Step: step}
+			value, err := MetricCollect(context.Background(), args, "up", r, "test", "up")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := value.(model.Matrix)
+			if len(m) != 2 || m[0].Metric["job"] != "b" || m[1].Metric["job"] != "a" {
+				t.Fatalf("series %v, want b and a in the order of the first response", m)
+			}
+			for _, ss := range m {
+				if len(ss.Values) != steps+1 {
+					t.Errorf("job %s has %d samples, want %d", ss.Metric["job"], len(ss.Values), steps+1)
+				}
+				// every step once, in order: none is missing or duplicated at the split points
+				for i, v := range ss.Values {
+					if want := model.TimeFromUnix(start.Add(time.Duration(i) * step).Unix()); v.Timestamp != want {
+						t.Fatalf("job %s sample %d at %s, want %s", ss.Metric["job"], i, v.Timestamp, want)
+					}
+				}
+			}
+			if requests < 3 {
+				t.Errorf("%d requests, the range was not split", requests)
+			}
+		})
+	}
+}
+
+func TestQueryRangeSplitDepth(t *testing.T) {
+	var requests int32
+	srv := newSplitServer(0, &requests)
+	defer srv.Close()
+	args := newTestParameters(t, srv.URL)
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	r := v1.Range{Start: start, End: start.Add(1000 * time.Minute), Step: time.Minute}
+	if _, err := MetricCollect(context.Background(), args, "up", r, "test", "up"); !isTooLarge(err) {
+		t.Fatalf("err = %v, want the too large error", err)
+	}
+	// the first half is split again at every depth until the limit, then the error is returned without the other halves
+	if want := int32(maxRangeSplits + 1); requests != want {
+		t.Errorf("%d requests, want %d", requests, want)
+	}
+}
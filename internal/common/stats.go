@@ -0,0 +1,131 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// queryStatsFile holds the stats of every query of the run, to find out which queries make a run slow.
+const queryStatsFile = "./data/query_stats.json"
+
+// Types of queries.
+const (
+	queryTypeRange      = "range"
+	queryTypeInstant    = "instant"
+	queryTypeRemoteRead = "remote_read"
+)
+
+// queryStat records a query of an entity: how long it took, including retries, splits and the time it was throttled, how many
+// requests it took, its result and, summed over the requests, the stats reported by Prometheus.
+type queryStat struct {
+	Entity   string `json:"entity"`
+	Metric   string `json:"metric"`
+	Query    string `json:"query"`
+	Type     string `json:"type"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Cached   bool   `json:"cached,omitempty"`
+	Requests int    `json:"requests"`
+	// Seconds is the wall-clock duration of the query
+	Seconds float64 `json:"seconds"`
+	Series  int     `json:"series"`
+	Samples int     `json:"samples"`
+	// EvalSeconds, SamplesLoaded and PeakSamples are reported by Prometheus; peak samples is the highest of the requests
+	EvalSeconds   float64 `json:"evalSeconds,omitempty"`
+	SamplesLoaded int64   `json:"samplesLoaded,omitempty"`
+	PeakSamples   int64   `json:"peakSamples,omitempty"`
+	Error         string  `json:"error,omitempty"`
+	began         time.Time
+}
+
+// newQueryStat starts recording a query.
+func newQueryStat(entityKind, metricName, query, queryType string, r v1.Range) *queryStat {
+	return &queryStat{Entity: entityKind, Metric: metricName, Query: query, Type: queryType, Start: Format(&r.Start), End: Format(&r.End), began: time.Now()}
+}
+
+// addRequest accounts for a request of the query and the stats Prometheus reported for it, if any.
+func (s *queryStat) addRequest(ps *promStats) {
+	s.Requests++
+	if ps == nil {
+		return
+	}
+	s.EvalSeconds += ps.evalTime()
+	s.SamplesLoaded += ps.Samples.TotalQueryableSamples
+	if ps.Samples.PeakSamples > s.PeakSamples {
+		s.PeakSamples = ps.Samples.PeakSamples
+	}
+}
+
+// countResult sets the number of series and samples of the result.
+func (s *queryStat) countResult(value model.Value) {
+	switch v := value.(type) {
+	case model.Matrix:
+		s.Series = len(v)
+		for _, ss := range v {
+			s.Samples += len(ss.Values)
+		}
+	case model.Vector:
+		s.Series, s.Samples = len(v), len(v)
+	}
+}
+
+// queryStats collects the stats of the queries of the run.
+type queryStats struct {
+	mu    sync.Mutex
+	stats []*queryStat
+}
+
+// record ends the recording of the query.
+func (qs *queryStats) record(s *queryStat, err error) {
+	if qs == nil {
+		return
+	}
+	s.Seconds = time.Since(s.began).Seconds()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.stats = append(qs.stats, s)
+}
+
+type queryStatsReport struct {
+	GeneratedAt string `json:"generatedAt"`
+	// Queries are sorted from the slowest to the fastest.
+	Queries []*queryStat `json:"queries"`
+}
+
+// WriteQueryStats writes the stats of the queries of the run, the slowest first. The slowest query is logged as well.
+func WriteQueryStats(args *Parameters) {
+	if args.stats == nil {
+		return
+	}
+	now := time.Now().UTC()
+	report := queryStatsReport{GeneratedAt: Format(&now)}
+	args.stats.mu.Lock()
+	report.Queries = append(report.Queries, args.stats.stats...)
+	args.stats.mu.Unlock()
+	sort.SliceStable(report.Queries, func(i, j int) bool { return report.Queries[i].Seconds > report.Queries[j].Seconds })
+	if len(report.Queries) > 0 {
+		s := report.Queries[0]
+		msg := fmt.Sprintf("entity=%s metric=%s query=%s message=slowest of %d queries, took %.3fs", s.Entity, s.Metric, s.Query, len(report.Queries), s.Seconds)
+		args.InfoLogger.Println(msg)
+		fmt.Println("[INFO] " + msg)
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(queryStatsFile, b, 0644)
+	}
+	if err != nil {
+		args.ErrorLogger.Println("message=cannot write query stats: " + err.Error())
+		fmt.Println("[ERROR] message=cannot write query stats: " + err.Error())
+	}
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throttle protects a shared Prometheus from the forwarder: it limits the rate of the queries sent and the number of queries
+// in flight. Queries over the limits wait for their turn rather than fail; the time they spend waiting is recorded.
+type throttle struct {
+	// limiter is nil when the rate of queries is not limited
+	limiter *rate.Limiter
+	// inFlight holds a slot per query in flight, it is nil when their number is not capped
+	inFlight chan struct{}
+
+	mu      sync.Mutex
+	queries int
+	waited  time.Duration
+}
+
+// newThrottle creates the throttle of the max QPS and max in flight settings; zero or less means no limit.
+func newThrottle(args *Parameters) *throttle {
+	t := &throttle{}
+	if args.MaxQPS > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(args.MaxQPS), 1)
+	}
+	if args.MaxInFlight > 0 {
+		t.inFlight = make(chan struct{}, args.MaxInFlight)
+	}
+	return t
+}
+
+// enabled tells whether any limit is set.
+func (t *throttle) enabled() bool {
+	return t != nil && (t.limiter != nil || t.inFlight != nil)
+}
+
+// acquire waits until a query can be sent within the limits; release must be called once the query is done. It fails only
+// if the context is done while waiting.
+func (t *throttle) acquire(ctx context.Context) (release func(), err error) {
+	release = func() {}
+	if !t.enabled() {
+		return
+	}
+	start := time.Now()
+	if t.inFlight != nil {
+		select {
+		case t.inFlight <- struct{}{}:
+			release = func() { <-t.inFlight }
+		case <-ctx.Done():
+			return release, ctx.Err()
+		}
+	}
+	if t.limiter != nil {
+		if err = t.limiter.Wait(ctx); err != nil {
+			release()
+			return func() {}, err
+		}
+	}
+	// the bookkeeping of the limiter itself takes a few microseconds
+	if waited := time.Since(start); waited >= time.Millisecond {
+		t.mu.Lock()
+		t.queries++
+		t.waited += waited
+		t.mu.Unlock()
+	}
+	return
+}
+
+// throttled returns the number of queries which had to wait and the total time they waited.
+func (t *throttle) throttled() (queries int, waited time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.queries, t.waited
+}
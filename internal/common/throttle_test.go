@@ -0,0 +1,127 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottleInFlight(t *testing.T) {
+	th := newThrottle(&Parameters{MaxInFlight: 2})
+	var f inFlight
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := th.acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f.start()
+			time.Sleep(5 * time.Millisecond)
+			f.end()
+			release()
+		}()
+	}
+	wg.Wait()
+	if f.max != 2 {
+		t.Errorf("%d queries in flight, want 2", f.max)
+	}
+	if queries, waited := th.throttled(); queries == 0 || waited < 5*time.Millisecond {
+		t.Errorf("throttled() = %d, %v, want the queries which waited for a slot", queries, waited)
+	}
+}
+
+func TestThrottleCancel(t *testing.T) {
+	th := newThrottle(&Parameters{MaxInFlight: 1})
+	release, err := th.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		r, err := th.acquire(ctx)
+		r()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("acquire() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire() did not return once cancelled")
+	}
+	release()
+	if n := len(th.inFlight); n != 0 {
+		t.Fatalf("%d slots held after the queries are done", n)
+	}
+	// the slot is free for the next query
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if release, err = th.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestThrottleQPS(t *testing.T) {
+	th := newThrottle(&Parameters{MaxQPS: 50})
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		release, err := th.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	// the first query is sent at once, the next ones every 20ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("6 queries at 50 QPS sent in %v", elapsed)
+	}
+	if queries, waited := th.throttled(); queries < 4 || waited < 80*time.Millisecond {
+		t.Errorf("throttled() = %d, %v, want 5 queries waiting 100ms", queries, waited)
+	}
+
+	// a query cancelled while waiting for the rate releases its slot
+	th = newThrottle(&Parameters{MaxQPS: 1, MaxInFlight: 1})
+	release, err := th.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = th.acquire(ctx); err == nil {
+		t.Error("acquire() within the rate")
+	}
+	if n := len(th.inFlight); n != 0 {
+		t.Errorf("%d slots held after the rate wait failed", n)
+	}
+}
+
+func TestThrottleDisabled(t *testing.T) {
+	th := newThrottle(&Parameters{})
+	if th.enabled() {
+		t.Error("throttle enabled without limits")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// without limits the queries never wait, even on a done context
+	if release, err := th.acquire(ctx); err != nil {
+		t.Error(err)
+	} else {
+		release()
+	}
+	var none *throttle
+	if queries, waited := none.throttled(); queries != 0 || waited != 0 {
+		t.Errorf("throttled() = %d, %v", queries, waited)
+	}
+}
@@ -0,0 +1,293 @@
+// Package config holds the settings of the data collection and validates them, so invalid settings are reported all at once,
+// before any query is sent to Prometheus.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+)
+
+// Valid values of the enumerated settings.
+var (
+	Intervals      = []string{"days", "hours", "minutes"}
+	Protocols      = []string{"http", "https"}
+	Entities       = []string{"cluster", "node", "container", "nodegroup", "quota"}
+	TLSVersions    = []string{"TLS10", "TLS11", "TLS12", "TLS13"}
+	ProxyProtocols = []string{"http", "https", "socks5"}
+)
+
+// Config holds the settings of the data collection, whether they come from the defaults, the environment, the config file
+// or the command line. It is built by Load.
+type Config struct {
+	ClusterName  string
+	Protocol     string
+	Address      string
+	Port         string
+	URL          string
+	FailoverURLs string
+	Sources      string
+	Routes       string
+
+	Interval     string
+	IntervalSize int
+	History      int
+	Offset       int
+	SampleRate   int
+
+	Debug             bool
+	ConfigFile        string
+	ConfigPath        string
+	IncludeList       string
+	NodeGroupList     string
+	IncludeNamespaces string
+	ExcludeNamespaces string
+
+	OAuthToken          string
+	CACert              string
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	IdleConnTimeout     time.Duration
+	Retries             int
+	RetryBackoff        time.Duration
+	RetryMaxBackoff     time.Duration
+	QueryTimeout        time.Duration
+	RunTimeout          time.Duration
+	ClientCert          string
+	ClientKey           string
+	TLSServerName       string
+	TLSMinVersion       string
+	InsecureSkipVerify  bool
+
+	Username     string
+	Password     string
+	PasswordFile string
+	Headers      string
+
+	SigV4Region    string
+	SigV4Service   string
+	SigV4AccessKey string
+	SigV4SecretKey string
+
+	OAuth2TokenURL         string
+	OAuth2ClientID         string
+	OAuth2ClientSecret     string
+	OAuth2ClientSecretFile string
+	OAuth2Scopes           string
+
+	ProxyHost     string
+	ProxyPort     string
+	ProxyProtocol string
+	ProxyUser     string
+	ProxyPassword string
+
+	Concurrency     int
+	MaxQPS          float64
+	MaxInFlight     int
+	CacheTTL        time.Duration
+	CacheDir        string
+	RemoteRead      bool
+	InstantLookback time.Duration
+	QueryPreset     string
+	QueryParams     string
+
+	// file is the config file which was read, origins holds where each setting comes from, by config file key
+	file    string
+	origins map[string]string
+}
+
+// Default returns the default settings.
+func Default() *Config {
+	return &Config{
+		Protocol:            "http",
+		Port:                "9090",
+		Interval:            "hours",
+		IntervalSize:        1,
+		History:             1,
+		SampleRate:          5,
+		ConfigFile:          "config",
+		ConfigPath:          "./config",
+		IncludeList:         "container,node,cluster,nodegroup,quota",
+		NodeGroupList:       "label_cloud_google_com_gke_nodepool,label_eks_amazonaws_com_nodegroup,label_agentpool,label_pool_name,label_alpha_eksctl_io_nodegroup_name,label_kops_k8s_io_instancegroup",
+		DialTimeout:         common.DefaultDialTimeout,
+		IdleConnTimeout:     common.DefaultIdleConnTimeout,
+		Retries:             common.DefaultRetries,
+		RetryBackoff:        common.DefaultRetryBackoff,
+		RetryMaxBackoff:     common.DefaultRetryMaxBackoff,
+		QueryTimeout:        common.DefaultQueryTimeout,
+		SigV4Service:        common.DefaultSigV4Service,
+		ProxyProtocol:       "http",
+		Concurrency:         common.DefaultConcurrency,
+		CacheDir:            common.DefaultCacheDir,
+		TLSHandshakeTimeout: common.DefaultTLSHandshakeTimeout,
+	}
+}
+
+// Include returns the entities to collect. The cluster is always collected, regardless of the include list.
+func (c *Config) Include() map[string]bool {
+	include := map[string]bool{"cluster": true}
+	for _, elem := range strings.Split(c.IncludeList, ",") {
+		if elem = strings.ToLower(strings.TrimSpace(elem)); elem != "" {
+			include[elem] = true
+		}
+	}
+	return include
+}
+
+// Validate checks every setting and returns all the invalid ones, none if the settings are valid. Settings are named by their
+// config file keys.
+func (c *Config) Validate() Errors {
+	var errs Errors
+	if c.URL == "" {
+		if strings.TrimSpace(c.Address) == "" {
+			errs.Addf("prometheus_address is not set, either it or prometheus_url is required")
+		}
+		errs.oneOf("prometheus_protocol", c.Protocol, false, Protocols...)
+		errs.port("prometheus_port", c.Port)
+	}
+	errs.oneOf("interval", c.Interval, false, Intervals...)
+	errs.atLeast("interval_size", c.IntervalSize, 1)
+	errs.atLeast("history", c.History, 1)
+	errs.atLeast("sample_rate", c.SampleRate, 1)
+	errs.atLeast("offset", c.Offset, 0)
+	for _, elem := range strings.Split(c.IncludeList, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			errs.oneOf("include_list", elem, true, Entities...)
+		}
+	}
+
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"prometheus_dial_timeout", c.DialTimeout},
+		{"prometheus_tls_handshake_timeout", c.TLSHandshakeTimeout},
+		{"prometheus_idle_conn_timeout", c.IdleConnTimeout},
+		{"prometheus_retry_backoff", c.RetryBackoff},
+		{"prometheus_retry_max_backoff", c.RetryMaxBackoff},
+		{"prometheus_query_timeout", c.QueryTimeout},
+		{"run_timeout", c.RunTimeout},
+		{"prometheus_cache_ttl", c.CacheTTL},
+		{"prometheus_instant_lookback", c.InstantLookback},
+	} {
+		if d.value < 0 {
+			errs.Addf("%s must not be negative, got %s", d.key, d.value)
+		}
+	}
+	errs.atLeast("prometheus_retries", c.Retries, 0)
+	errs.atLeast("prometheus_concurrency", c.Concurrency, 1)
+	errs.atLeast("prometheus_max_in_flight", c.MaxInFlight, 0)
+	if c.MaxQPS < 0 {
+		errs.Addf("prometheus_max_qps must not be negative, got %g", c.MaxQPS)
+	}
+
+	if c.TLSMinVersion != "" {
+		errs.oneOf("prometheus_tls_min_version", c.TLSMinVersion, true, TLSVersions...)
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		errs.Addf("client_certificate and client_key must be set together")
+	}
+	errs.fileExists("ca_certificate", c.CACert)
+	errs.fileExists("client_certificate", c.ClientCert)
+	errs.fileExists("client_key", c.ClientKey)
+	var auth []string
+	for _, a := range []struct{ key, value string }{
+		{"prometheus_sigv4_region", c.SigV4Region},
+		{"prometheus_oauth2_token_url", c.OAuth2TokenURL},
+		{"prometheus_username", c.Username},
+		{"prometheus_oauth_token", c.OAuthToken},
+	} {
+		if a.value != "" {
+			auth = append(auth, a.key)
+		}
+	}
+	if len(auth) > 1 {
+		errs.Addf("%s and %s are set, only one authentication method can be used", strings.Join(auth[:len(auth)-1], ", "), auth[len(auth)-1])
+	}
+	errs.fileExists("prometheus_oauth_token", c.OAuthToken)
+	if c.Username == "" && (c.Password != "" || c.PasswordFile != "") {
+		errs.Addf("prometheus_password is set without prometheus_username")
+	}
+	errs.fileExists("prometheus_password_file", c.PasswordFile)
+	if c.OAuth2TokenURL != "" && c.OAuth2ClientID == "" {
+		errs.Addf("prometheus_oauth2_token_url is set without prometheus_oauth2_client_id")
+	}
+	errs.fileExists("prometheus_oauth2_client_secret_file", c.OAuth2ClientSecretFile)
+	if c.ProxyHost != "" {
+		if c.ProxyProtocol != "" {
+			errs.oneOf("prometheus_proxy_protocol", c.ProxyProtocol, true, ProxyProtocols...)
+		}
+		errs.port("prometheus_proxy_port", c.ProxyPort)
+	}
+	return errs
+}
+
+// Errors is the aggregated report of invalid settings.
+type Errors []error
+
+// Addf adds an invalid setting to the report.
+func (e *Errors) Addf(format string, a ...interface{}) {
+	*e = append(*e, fmt.Errorf(format, a...))
+}
+
+// Err returns the report as an error, nil if it is empty.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e Errors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	if len(e) == 1 {
+		lines = append(lines, "1 invalid setting:")
+	} else {
+		lines = append(lines, fmt.Sprintf("%d invalid settings:", len(e)))
+	}
+	for _, err := range e {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *Errors) oneOf(key, value string, ignoreCase bool, valid ...string) {
+	for _, v := range valid {
+		if value == v || ignoreCase && strings.EqualFold(value, v) {
+			return
+		}
+	}
+	e.Addf("%s has an invalid value %q, valid values are %s", key, value, strings.Join(valid, ", "))
+}
+
+func (e *Errors) atLeast(key string, value, min int) {
+	if value < min {
+		e.Addf("%s must be at least %d, got %d", key, min, value)
+	}
+}
+
+// port checks the port, if set.
+func (e *Errors) port(key, value string) {
+	if value == "" {
+		return
+	}
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+		e.Addf("%s has an invalid value %q, must be a port number", key, value)
+	}
+}
+
+// fileExists checks the file, if set, exists and is not a directory.
+func (e *Errors) fileExists(key, path string) {
+	if path == "" {
+		return
+	}
+	if fi, err := os.Stat(path); err != nil {
+		e.Addf("%s: %v", key, err)
+	} else if fi.IsDir() {
+		e.Addf("%s: %s is a directory", key, path)
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validationErrors returns the messages of the invalid settings, one per line.
+func validationErrors(errs Errors) string {
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	return strings.Join(got, "\n")
+}
+
+// tempFile returns the path of an empty file, removed at the end of the test.
+func tempFile(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	tests := []struct {
+		name string
+		set  func(c *Config)
+		want string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"missing address", func(c *Config) { c.Address = " " },
+			"prometheus_address is not set, either it or prometheus_url is required"},
+		{"url without address", func(c *Config) { c.Address, c.URL, c.Protocol, c.Port = "", "http://prometheus:9090", "ftp", "0" }, ""},
+		{"protocol", func(c *Config) { c.Protocol = "HTTPS" },
+			`prometheus_protocol has an invalid value "HTTPS", valid values are http, https`},
+		{"interval", func(c *Config) { c.Interval = "weeks" },
+			`interval has an invalid value "weeks", valid values are days, hours, minutes`},
+		{"tls min version", func(c *Config) { c.TLSMinVersion = "tls12" }, ""},
+		{"invalid tls min version", func(c *Config) { c.TLSMinVersion = "SSL3" },
+			`prometheus_tls_min_version has an invalid value "SSL3", valid values are TLS10, TLS11, TLS12, TLS13`},
+		{"proxy protocol", func(c *Config) { c.ProxyHost, c.ProxyProtocol = "proxy", "ftp" },
+			`prometheus_proxy_protocol has an invalid value "ftp", valid values are http, https, socks5`},
+		{"ranges", func(c *Config) { c.IntervalSize, c.History, c.SampleRate, c.Offset = 0, 0, 0, -1 },
+			"interval_size must be at least 1, got 0\nhistory must be at least 1, got 0\nsample_rate must be at least 1, got 0\noffset must be at least 0, got -1"},
+		{"limits", func(c *Config) { c.Retries, c.Concurrency, c.MaxInFlight, c.MaxQPS = -1, 0, -1, -0.5 },
+			"prometheus_retries must be at least 0, got -1\nprometheus_concurrency must be at least 1, got 0\nprometheus_max_in_flight must be at least 0, got -1\nprometheus_max_qps must not be negative, got -0.5"},
+		{"negative durations", func(c *Config) { c.QueryTimeout, c.InstantLookback = -time.Second, -time.Minute },
+			"prometheus_query_timeout must not be negative, got -1s\nprometheus_instant_lookback must not be negative, got -1m0s"},
+		{"include list", func(c *Config) { c.IncludeList = " Container, node,,quota " }, ""},
+		{"invalid include list", func(c *Config) { c.IncludeList = "container,pods,nodes" },
+			`include_list has an invalid value "pods", valid values are cluster, node, container, nodegroup, quota` + "\n" +
+				`include_list has an invalid value "nodes", valid values are cluster, node, container, nodegroup, quota`},
+		{"port", func(c *Config) { c.Port = "65535" }, ""},
+		{"port not set", func(c *Config) { c.Port = "" }, ""},
+		{"port zero", func(c *Config) { c.Port = "0" }, `prometheus_port has an invalid value "0", must be a port number`},
+		{"port too large", func(c *Config) { c.Port = "65536" }, `prometheus_port has an invalid value "65536", must be a port number`},
+		{"port not a number", func(c *Config) { c.Port = "http" }, `prometheus_port has an invalid value "http", must be a port number`},
+		{"proxy port", func(c *Config) { c.ProxyHost, c.ProxyPort = "proxy", "-1" },
+			`prometheus_proxy_port has an invalid value "-1", must be a port number`},
+		{"proxy port without proxy", func(c *Config) { c.ProxyPort = "-1" }, ""},
+		{"missing oauth token", func(c *Config) { c.OAuthToken = missing },
+			"prometheus_oauth_token: stat " + missing + ": no such file or directory"},
+		{"missing ca certificate", func(c *Config) { c.CACert = missing },
+			"ca_certificate: stat " + missing + ": no such file or directory"},
+		{"ca certificate directory", func(c *Config) { c.CACert = dir }, "ca_certificate: " + dir + " is a directory"},
+		{"client key without certificate", func(c *Config) { c.ClientKey = missing },
+			"client_certificate and client_key must be set together\nclient_key: stat " + missing + ": no such file or directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Address = "prometheus"
+			tt.set(c)
+			if got := validationErrors(c.Validate()); got != tt.want {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	if err := errs.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+	errs.Addf("interval has an invalid value %q", "weeks")
+	if got, want := errs.Err().Error(), "1 invalid setting:\n  - interval has an invalid value \"weeks\""; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	// the address is not set either
+	c := Default()
+	c.Interval, c.History, c.Port = "weeks", 0, "0"
+	err := c.Validate().Err()
+	want := `4 invalid settings:
+  - prometheus_address is not set, either it or prometheus_url is required
+  - prometheus_port has an invalid value "0", must be a port number
+  - interval has an invalid value "weeks", valid values are days, hours, minutes
+  - history must be at least 1, got 0`
+	if err == nil || err.Error() != want {
+		t.Errorf("Error() = %v, want %s", err, want)
+	}
+	var report Errors
+	if !errors.As(err, &report) || len(report) != 4 {
+		t.Errorf("Err() = %#v, want the 4 invalid settings", err)
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	token := tempFile(t, "token")
+	tests := []struct {
+		name string
+		set  func(c *Config)
+		want string
+	}{
+		{"none", func(c *Config) {}, ""},
+		{"bearer token", func(c *Config) { c.OAuthToken = token }, ""},
+		{"basic auth", func(c *Config) { c.Username, c.Password = "u", "p" }, ""},
+		{"sigv4 and basic auth", func(c *Config) { c.SigV4Region, c.Username = "eu-west-1", "u" },
+			"prometheus_sigv4_region and prometheus_username are set, only one authentication method can be used"},
+		{"oauth2 and bearer token", func(c *Config) {
+			c.OAuth2TokenURL, c.OAuth2ClientID, c.OAuthToken = "https://idp/token", "id", token
+		},
+			"prometheus_oauth2_token_url and prometheus_oauth_token are set, only one authentication method can be used"},
+		{"all", func(c *Config) {
+			c.SigV4Region, c.OAuth2TokenURL, c.OAuth2ClientID, c.Username, c.OAuthToken = "eu-west-1", "https://idp/token", "id", "u", token
+		}, "prometheus_sigv4_region, prometheus_oauth2_token_url, prometheus_username and prometheus_oauth_token are set, only one authentication method can be used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Address = "prometheus"
+			tt.set(c)
+			if got := validationErrors(c.Validate()); got != tt.want {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstantLookbackDefault(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want time.Duration
+	}{
+		{"default", nil, 0},
+		{"interval", []string{"-interval", "days", "-intervalSize", "2"}, 0},
+		{"set", []string{"-instantLookback", "10m"}, 10 * time.Minute},
+		{"instant", []string{"-interval", "days", "-instantLookback", "0"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags := AddFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			c, errs := Load(flags)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if c.InstantLookback != tt.want {
+				t.Errorf("InstantLookback = %s, want %s", c.InstantLookback, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// OriginDefault is the origin of the settings which are not set in any layer.
+const OriginDefault = "default"
+
+// redacted replaces the secrets when the configuration is printed.
+const redacted = "<redacted>"
+
+// setting describes a setting: its config file key, its path in the sections of a YAML config file, environment variable and
+// command line flag, and the field it sets.
+type setting struct {
+	key, path, env, flag, usage string
+	// pairs is how a YAML map is written as the value of the setting, if it can be one
+	pairs pairs
+	// location settings locate the config file, so they cannot be set in it
+	location bool
+	// redact hides the secrets of the value when it is printed
+	redact func(string) string
+	field  func(c *Config) interface{}
+}
+
+// settings lists every setting, in the order they are printed.
+var settings = []setting{
+	{key: "cluster_name", path: "collection.cluster_name", env: "PROMETHEUS_CLUSTER", flag: "clusterName", usage: "Name of the cluster to show in Densify",
+		field: func(c *Config) interface{} { return &c.ClusterName }},
+	{key: "prometheus_protocol", path: "prometheus.protocol", env: "PROMETHEUS_PROTOCOL", flag: "protocol", usage: "Which protocol to use http|https",
+		field: func(c *Config) interface{} { return &c.Protocol }},
+	{key: "prometheus_address", path: "prometheus.address", env: "PROMETHEUS_ADDRESS", flag: "address", usage: "Name of the Prometheus Server",
+		field: func(c *Config) interface{} { return &c.Address }},
+	{key: "prometheus_port", path: "prometheus.port", env: "PROMETHEUS_PORT", flag: "port", usage: "Prometheus Port",
+		field: func(c *Config) interface{} { return &c.Port }},
+	{key: "prometheus_url", path: "prometheus.url", env: "PROMETHEUS_URL", flag: "url", usage: "Full base URL of Prometheus, including any path prefix. Takes precedence over protocol, address and port. Credentials go in the username and password settings, not the URL. Ex: https://thanos.example.com/api/prom", redact: redactURLs,
+		field: func(c *Config) interface{} { return &c.URL }},
+	{key: "prometheus_failover_urls", path: "prometheus.failover_urls", env: "PROMETHEUS_FAILOVERURLS", flag: "failoverUrls", usage: "Comma separated URLs of further Prometheus endpoints (e.g. the other replica of an HA pair), used in order if the main one is unhealthy or its queries keep failing", redact: redactURLs,
+		field: func(c *Config) interface{} { return &c.FailoverURLs }},
+	{key: "prometheus_sources", path: "prometheus.sources", pairs: mapSources, env: "PROMETHEUS_SOURCES", flag: "sources", usage: "Named Prometheus sources, semicolon separated name=URL pairs where the URL can be a comma separated failover list. Ex: ksm=http://prom-ksm:9090;nodes=http://prom-nodes:9090", redact: redactURLs,
+		field: func(c *Config) interface{} { return &c.Sources }},
+	{key: "prometheus_routes", path: "prometheus.routes", pairs: mapPairs, env: "PROMETHEUS_ROUTES", flag: "routes", usage: "Routing of metric families kube|node|container|openshift to named sources, comma separated family=source pairs. Ex: kube=ksm,container=cadvisor,node=nodes,openshift=ocp. The container and node metrics joined with the kube ones are joined by the forwarder if they are routed to different sources",
+		field: func(c *Config) interface{} { return &c.Routes }},
+	{key: "interval", path: "collection.interval", env: "PROMETHEUS_INTERVAL", flag: "interval", usage: "Interval to use for data collection. Can be days, hours or minutes",
+		field: func(c *Config) interface{} { return &c.Interval }},
+	{key: "interval_size", path: "collection.interval_size", env: "PROMETHEUS_INTERVALSIZE", flag: "intervalSize", usage: "Interval size to be used for querying. eg. default of 1 with default interval of hours queries 1 last hour of info",
+		field: func(c *Config) interface{} { return &c.IntervalSize }},
+	{key: "history", path: "collection.history", env: "PROMETHEUS_HISTORY", flag: "history", usage: "Amount of time to go back for data collection works with the interval and intervalSize settings",
+		field: func(c *Config) interface{} { return &c.History }},
+	{key: "offset", path: "collection.offset", env: "PROMETHEUS_OFFSET", flag: "offset", usage: "Amount of units (based on interval value) to offset the data collection backwards in time",
+		field: func(c *Config) interface{} { return &c.Offset }},
+	{key: "sample_rate", path: "collection.sample_rate", env: "PROMETHEUS_SAMPLERATE", flag: "sampleRate", usage: "Rate of sample points to collect. default is 5 for 1 sample for every 5 minutes.",
+		field: func(c *Config) interface{} { return &c.SampleRate }},
+	{key: "debug", path: "output.debug", env: "PROMETHEUS_DEBUG", flag: "debug", usage: "Enable debug logging",
+		field: func(c *Config) interface{} { return &c.Debug }},
+	{key: "config_file", env: "PROMETHEUS_CONFIGFILE", flag: "file", usage: "Name of the config file without extension. Default config", location: true,
+		field: func(c *Config) interface{} { return &c.ConfigFile }},
+	{key: "config_path", env: "PROMETHEUS_CONFIGPATH", flag: "path", usage: "Path to where the config file is stored", location: true,
+		field: func(c *Config) interface{} { return &c.ConfigPath }},
+	{key: "include_list", path: "entities.include", env: "PROMETHEUS_INCLUDE", flag: "includeList", usage: "Comma separated list of data to include in collection (cluster, node, container, nodegroup, quota) Ex: \"node,cluster\"",
+		field: func(c *Config) interface{} { return &c.IncludeList }},
+	{key: "node_group_list", path: "entities.node_group_labels", env: "NODE_GROUP_LIST", flag: "nodeGroupList", usage: "Comma separated list of labels to check for building node groups Ex: \"label_cloud_google_com_gke_nodepool,label_eks_amazonaws_com_nodegroup,label_agentpool,label_pool_name\"",
+		field: func(c *Config) interface{} { return &c.NodeGroupList }},
+	{key: "include_namespaces", path: "filters.include_namespaces", env: "PROMETHEUS_INCLUDENAMESPACES", flag: "includeNamespaces", usage: "Comma separated patterns of the namespaces whose containers and resource quotas are collected, all if not set. Ex: \"team-*,default\"",
+		field: func(c *Config) interface{} { return &c.IncludeNamespaces }},
+	{key: "exclude_namespaces", path: "filters.exclude_namespaces", env: "PROMETHEUS_EXCLUDENAMESPACES", flag: "excludeNamespaces", usage: "Comma separated patterns of the namespaces whose containers and resource quotas are not collected. Ex: \"kube-*,openshift-*\"",
+		field: func(c *Config) interface{} { return &c.ExcludeNamespaces }},
+	{key: "prometheus_oauth_token", path: "prometheus.auth.oauth_token", env: "OAUTH_TOKEN", flag: "oAuthToken", usage: "Path to oAuth token file required to authenticate with the Cluster where Prometheus is running.",
+		field: func(c *Config) interface{} { return &c.OAuthToken }},
+	{key: "ca_certificate", path: "prometheus.tls.ca_certificate", env: "CA_CERT", flag: "caCert", usage: "Path to CA certificate required to pass certificate validation if using HTTPS",
+		field: func(c *Config) interface{} { return &c.CACert }},
+	{key: "prometheus_dial_timeout", path: "prometheus.timeouts.dial", env: "PROMETHEUS_DIALTIMEOUT", flag: "dialTimeout", usage: "Timeout for establishing a connection to Prometheus. Ex: 30s",
+		field: func(c *Config) interface{} { return &c.DialTimeout }},
+	{key: "prometheus_tls_handshake_timeout", path: "prometheus.timeouts.tls_handshake", env: "PROMETHEUS_TLSHANDSHAKETIMEOUT", flag: "tlsHandshakeTimeout", usage: "Timeout for the TLS handshake with Prometheus. Ex: 10s",
+		field: func(c *Config) interface{} { return &c.TLSHandshakeTimeout }},
+	{key: "prometheus_idle_conn_timeout", path: "prometheus.timeouts.idle_conn", env: "PROMETHEUS_IDLECONNTIMEOUT", flag: "idleConnTimeout", usage: "How long an idle keep-alive connection to Prometheus is kept in the pool. Ex: 90s",
+		field: func(c *Config) interface{} { return &c.IdleConnTimeout }},
+	{key: "prometheus_retries", path: "prometheus.retries.count", env: "PROMETHEUS_RETRIES", flag: "retries", usage: "Number of times a query failing with a transient error (5xx, 429, timeout, connection reset) is retried",
+		field: func(c *Config) interface{} { return &c.Retries }},
+	{key: "prometheus_retry_backoff", path: "prometheus.retries.backoff", env: "PROMETHEUS_RETRYBACKOFF", flag: "retryBackoff", usage: "Initial wait before retrying a failed query, doubled on every retry. Ex: 1s",
+		field: func(c *Config) interface{} { return &c.RetryBackoff }},
+	{key: "prometheus_retry_max_backoff", path: "prometheus.retries.max_backoff", env: "PROMETHEUS_RETRYMAXBACKOFF", flag: "retryMaxBackoff", usage: "Maximum wait between retries of a failed query. Ex: 30s",
+		field: func(c *Config) interface{} { return &c.RetryMaxBackoff }},
+	{key: "prometheus_query_timeout", path: "prometheus.timeouts.query", env: "PROMETHEUS_QUERYTIMEOUT", flag: "queryTimeout", usage: "Timeout of a single Prometheus query. Ex: 2m",
+		field: func(c *Config) interface{} { return &c.QueryTimeout }},
+	{key: "run_timeout", path: "collection.run_timeout", env: "PROMETHEUS_RUNTIMEOUT", flag: "runTimeout", usage: "Deadline of the whole data collection run, no deadline if not set. Ex: 1h",
+		field: func(c *Config) interface{} { return &c.RunTimeout }},
+	{key: "client_certificate", path: "prometheus.tls.client_certificate", env: "CLIENT_CERT", flag: "clientCert", usage: "Path to client certificate presented to Prometheus for mutual TLS",
+		field: func(c *Config) interface{} { return &c.ClientCert }},
+	{key: "client_key", path: "prometheus.tls.client_key", env: "CLIENT_KEY", flag: "clientKey", usage: "Path to private key of the client certificate used for mutual TLS",
+		field: func(c *Config) interface{} { return &c.ClientKey }},
+	{key: "prometheus_tls_server_name", path: "prometheus.tls.server_name", env: "PROMETHEUS_TLSSERVERNAME", flag: "tlsServerName", usage: "Server name used to verify the certificate of Prometheus, if different from the address",
+		field: func(c *Config) interface{} { return &c.TLSServerName }},
+	{key: "prometheus_tls_min_version", path: "prometheus.tls.min_version", env: "PROMETHEUS_TLSMINVERSION", flag: "tlsMinVersion", usage: "Minimum TLS version accepted TLS10|TLS11|TLS12|TLS13",
+		field: func(c *Config) interface{} { return &c.TLSMinVersion }},
+	{key: "prometheus_insecure_skip_verify", path: "prometheus.tls.insecure_skip_verify", env: "PROMETHEUS_INSECURESKIPVERIFY", flag: "insecureSkipVerify", usage: "Skip the verification of the Prometheus certificate (insecure)",
+		field: func(c *Config) interface{} { return &c.InsecureSkipVerify }},
+	{key: "prometheus_username", path: "prometheus.auth.username", env: "PROMETHEUS_USERNAME", flag: "username", usage: "Username for basic authentication with Prometheus",
+		field: func(c *Config) interface{} { return &c.Username }},
+	{key: "prometheus_password", path: "prometheus.auth.password", env: "PROMETHEUS_PASSWORD", flag: "password", usage: "Password for basic authentication with Prometheus", redact: redactSecret,
+		field: func(c *Config) interface{} { return &c.Password }},
+	{key: "prometheus_password_file", path: "prometheus.auth.password_file", env: "PROMETHEUS_PASSWORDFILE", flag: "passwordFile", usage: "Path to file containing the password for basic authentication with Prometheus",
+		field: func(c *Config) interface{} { return &c.PasswordFile }},
+	{key: "prometheus_headers", path: "prometheus.headers", pairs: mapPairs, env: "PROMETHEUS_HEADERS", flag: "headers", usage: "Extra HTTP headers sent with every Prometheus request, comma separated Name=value pairs. Ex: X-Scope-OrgID=tenant1", redact: redactHeaders,
+		field: func(c *Config) interface{} { return &c.Headers }},
+	{key: "prometheus_sigv4_region", path: "prometheus.auth.sigv4.region", env: "PROMETHEUS_SIGV4REGION", flag: "sigv4Region", usage: "AWS region used to sign requests with SigV4, e.g. for Amazon Managed Service for Prometheus. Signing is enabled when set",
+		field: func(c *Config) interface{} { return &c.SigV4Region }},
+	{key: "prometheus_sigv4_service", path: "prometheus.auth.sigv4.service", env: "PROMETHEUS_SIGV4SERVICE", flag: "sigv4Service", usage: "AWS service name used to sign requests with SigV4",
+		field: func(c *Config) interface{} { return &c.SigV4Service }},
+	{key: "prometheus_sigv4_access_key", path: "prometheus.auth.sigv4.access_key", env: "PROMETHEUS_SIGV4ACCESSKEY", flag: "sigv4AccessKey", usage: "AWS access key used to sign requests with SigV4, the default AWS credential chain is used if not set", redact: redactSecret,
+		field: func(c *Config) interface{} { return &c.SigV4AccessKey }},
+	{key: "prometheus_sigv4_secret_key", path: "prometheus.auth.sigv4.secret_key", env: "PROMETHEUS_SIGV4SECRETKEY", flag: "sigv4SecretKey", usage: "AWS secret key used to sign requests with SigV4", redact: redactSecret,
+		field: func(c *Config) interface{} { return &c.SigV4SecretKey }},
+	{key: "prometheus_oauth2_token_url", path: "prometheus.auth.oauth2.token_url", env: "PROMETHEUS_OAUTH2TOKENURL", flag: "oAuth2TokenUrl", usage: "Token URL of the OAuth2 client credentials flow. OAuth2 authentication is enabled when set", redact: redactURLs,
+		field: func(c *Config) interface{} { return &c.OAuth2TokenURL }},
+	{key: "prometheus_oauth2_client_id", path: "prometheus.auth.oauth2.client_id", env: "PROMETHEUS_OAUTH2CLIENTID", flag: "oAuth2ClientId", usage: "Client ID of the OAuth2 client credentials flow",
+		field: func(c *Config) interface{} { return &c.OAuth2ClientID }},
+	{key: "prometheus_oauth2_client_secret", path: "prometheus.auth.oauth2.client_secret", env: "PROMETHEUS_OAUTH2CLIENTSECRET", flag: "oAuth2ClientSecret", usage: "Client secret of the OAuth2 client credentials flow", redact: redactSecret,
+		field: func(c *Config) interface{} { return &c.OAuth2ClientSecret }},
+	{key: "prometheus_oauth2_client_secret_file", path: "prometheus.auth.oauth2.client_secret_file", env: "PROMETHEUS_OAUTH2CLIENTSECRETFILE", flag: "oAuth2ClientSecretFile", usage: "Path to file containing the client secret of the OAuth2 client credentials flow",
+		field: func(c *Config) interface{} { return &c.OAuth2ClientSecretFile }},
+	{key: "prometheus_oauth2_scopes", path: "prometheus.auth.oauth2.scopes", env: "PROMETHEUS_OAUTH2SCOPES", flag: "oAuth2Scopes", usage: "Comma separated scopes requested in the OAuth2 client credentials flow",
+		field: func(c *Config) interface{} { return &c.OAuth2Scopes }},
+	{key: "prometheus_proxy_host", path: "prometheus.proxy.host", env: "PROMETHEUS_PROXYHOST", flag: "proxyHost", usage: "Host of the proxy used to connect to Prometheus, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used if not set",
+		field: func(c *Config) interface{} { return &c.ProxyHost }},
+	{key: "prometheus_proxy_port", path: "prometheus.proxy.port", env: "PROMETHEUS_PROXYPORT", flag: "proxyPort", usage: "Port of the proxy used to connect to Prometheus",
+		field: func(c *Config) interface{} { return &c.ProxyPort }},
+	{key: "prometheus_proxy_protocol", path: "prometheus.proxy.protocol", env: "PROMETHEUS_PROXYPROTOCOL", flag: "proxyProtocol", usage: "Protocol of the proxy used to connect to Prometheus http|https|socks5",
+		field: func(c *Config) interface{} { return &c.ProxyProtocol }},
+	{key: "prometheus_proxy_user", path: "prometheus.proxy.user", env: "PROMETHEUS_PROXYUSER", flag: "proxyUser", usage: "Username to authenticate with the proxy used to connect to Prometheus",
+		field: func(c *Config) interface{} { return &c.ProxyUser }},
+	{key: "prometheus_proxy_password", path: "prometheus.proxy.password", env: "PROMETHEUS_PROXYPASSWORD", flag: "proxyPassword", usage: "Password to authenticate with the proxy used to connect to Prometheus", redact: redactSecret,
+		field: func(c *Config) interface{} { return &c.ProxyPassword }},
+	{key: "prometheus_concurrency", path: "prometheus.concurrency", env: "PROMETHEUS_CONCURRENCY", flag: "concurrency", usage: "Maximum number of independent queries run at the same time by each collector, 1 runs the queries one after the other",
+		field: func(c *Config) interface{} { return &c.Concurrency }},
+	{key: "prometheus_max_qps", path: "prometheus.max_qps", env: "PROMETHEUS_MAXQPS", flag: "maxQps", usage: "Maximum number of queries per second sent to Prometheus, queries over the limit wait for their turn. 0 means no limit",
+		field: func(c *Config) interface{} { return &c.MaxQPS }},
+	{key: "prometheus_max_in_flight", path: "prometheus.max_in_flight", env: "PROMETHEUS_MAXINFLIGHT", flag: "maxInFlight", usage: "Maximum number of queries in flight to Prometheus at the same time, queries over the limit wait for their turn. 0 means no limit",
+		field: func(c *Config) interface{} { return &c.MaxInFlight }},
+	{key: "prometheus_cache_ttl", path: "prometheus.cache.ttl", env: "PROMETHEUS_CACHETTL", flag: "cacheTtl", usage: "How long query results are cached on disk, so repeated queries and reruns of the same window are not sent to Prometheus again. 0 disables the cache. Ex: 1h",
+		field: func(c *Config) interface{} { return &c.CacheTTL }},
+	{key: "prometheus_cache_dir", path: "prometheus.cache.dir", env: "PROMETHEUS_CACHEDIR", flag: "cacheDir", usage: "Directory of the query cache",
+		field: func(c *Config) interface{} { return &c.CacheDir }},
+	{key: "prometheus_remote_read", path: "prometheus.remote_read", env: "PROMETHEUS_REMOTEREAD", flag: "remoteRead", usage: "Read the raw container samples through the Prometheus remote-read API and aggregate them in the forwarder",
+		field: func(c *Config) interface{} { return &c.RemoteRead }},
+	{key: "prometheus_instant_lookback", path: "prometheus.instant_lookback", env: "PROMETHEUS_INSTANTLOOKBACK", flag: "instantLookback", usage: "Lookback of the config and attribute queries, which are instant queries at the current time by default. With a lookback they report the latest values over the lookback before the current time, so entities which went away shortly before are still reported, at the cost of heavier queries. Ex: 1h",
+		field: func(c *Config) interface{} { return &c.InstantLookback }},
+	{key: "prometheus_query_preset", path: "prometheus.query_preset", env: "PROMETHEUS_QUERYPRESET", flag: "queryPreset", usage: "Preset of extra query parameters for Thanos or VictoriaMetrics: thanos or victoriametrics",
+		field: func(c *Config) interface{} { return &c.QueryPreset }},
+	{key: "prometheus_query_params", path: "prometheus.query_params", pairs: mapPairs, env: "PROMETHEUS_QUERYPARAMS", flag: "queryParams", usage: "Extra parameters sent with every range and instant query, comma separated name=value pairs, overriding those of the preset. Ex: max_source_resolution=5m,replicaLabels[]=replica",
+		field: func(c *Config) interface{} { return &c.QueryParams }},
+}
+
+// Flags holds the settings passed on the command line, as they were given, so they are parsed and reported along with the
+// other layers.
+type Flags struct {
+	values map[string]string
+}
+
+// flagValue records the value of a setting flag.
+type flagValue struct {
+	flags     *Flags
+	name, def string
+	isBool    bool
+}
+
+func (v *flagValue) String() string { return v.def }
+
+func (v *flagValue) Set(s string) error {
+	v.flags.values[v.name] = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+
+// AddFlags registers a flag for each setting on the flag set.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{values: map[string]string{}}
+	def := Default()
+	for _, s := range settings {
+		_, isBool := s.field(def).(*bool)
+		v := &flagValue{flags: flags, name: s.flag, def: format(s.field(def)), isBool: isBool}
+		// zero defaults are not shown in the usage
+		if v.def == "0" || v.def == "0s" || v.def == "false" {
+			v.def = ""
+		}
+		fs.Var(v, s.flag, s.usage)
+	}
+	return flags
+}
+
+// Load layers the settings, each layer overriding the ones before it: the defaults, the environment variables, the config file
+// and the command line flags. The config file is located by the config file and config path settings, which are taken from the
+// environment and the flags only. A config file which was set explicitly must exist, the default one is optional. Values which
+// do not parse are reported and the value of the layer below is kept; empty values of numbers, booleans and durations are
+// ignored.
+func Load(flags *Flags) (*Config, Errors) {
+	c := Default()
+	c.origins = map[string]string{}
+	for _, s := range settings {
+		c.origins[s.key] = OriginDefault
+	}
+	var errs Errors
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := c.set(s, v, "env "+s.env); err != nil {
+				errs.Addf("environment variable %s %v", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags.values[s.flag]; ok && s.location {
+			c.set(s, v, "flag -"+s.flag)
+		}
+	}
+	if c.ConfigFile != "" {
+		file, values, err := readConfigFile(c.ConfigFile, c.ConfigPath, &errs)
+		switch {
+		case errors.As(err, &viper.ConfigFileNotFoundError{}) && c.origins["config_file"] == OriginDefault && c.origins["config_path"] == OriginDefault:
+		case err != nil:
+			errs.Addf("cannot read config file %s in %s: %v", c.ConfigFile, c.ConfigPath, err)
+		default:
+			c.file = file
+			for _, s := range settings {
+				if v, ok := values[s.key]; ok && !s.location {
+					if err := c.set(s, v, "file "+file); err != nil {
+						errs.Addf("%s in the config file %v", fileName(file, s), err)
+					}
+				}
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags.values[s.flag]; ok && !s.location {
+			if err := c.set(s, v, "flag -"+s.flag); err != nil {
+				errs.Addf("flag -%s %v", s.flag, err)
+			}
+		}
+	}
+	return c, errs
+}
+
+// readConfigFile reads the config file with the name, without extension, in the path. A YAML file is read by sections, see
+// readYAML; the other formats, such as the config.properties shared with the forwarder, are read by key. The values are returned
+// as strings, by key.
+func readConfigFile(name, path string, errs *Errors) (string, map[string]string, error) {
+	for _, ext := range yamlExtensions {
+		file := filepath.Join(path, name+ext)
+		if _, err := os.Stat(file); err == nil {
+			values, err := readYAML(file, errs)
+			return file, values, err
+		}
+	}
+	v := viper.New()
+	v.SetConfigName(name)
+	v.AddConfigPath(path)
+	if err := v.ReadInConfig(); err != nil {
+		return "", nil, err
+	}
+	values := map[string]string{}
+	for _, key := range v.AllKeys() {
+		values[key] = v.GetString(key)
+	}
+	return v.ConfigFileUsed(), values, nil
+}
+
+// set parses the value into the field of the setting and records its origin.
+func (c *Config) set(s setting, raw, origin string) error {
+	var err error
+	switch p := s.field(c).(type) {
+	case *string:
+		*p = raw
+	default:
+		if raw = strings.TrimSpace(raw); raw == "" {
+			return nil
+		}
+		switch p := p.(type) {
+		case *int:
+			var v int
+			if v, err = strconv.Atoi(raw); err != nil {
+				return fmt.Errorf("is not an integer: %q", raw)
+			}
+			*p = v
+		case *bool:
+			var v bool
+			if v, err = strconv.ParseBool(raw); err != nil {
+				return fmt.Errorf("is not a boolean: %q", raw)
+			}
+			*p = v
+		case *float64:
+			var v float64
+			if v, err = strconv.ParseFloat(raw, 64); err != nil {
+				return fmt.Errorf("is not a number: %q", raw)
+			}
+			*p = v
+		case *time.Duration:
+			var v time.Duration
+			if v, err = time.ParseDuration(raw); err != nil {
+				return fmt.Errorf("is not a duration: %q", raw)
+			}
+			*p = v
+		}
+	}
+	c.origins[s.key] = origin
+	return nil
+}
+
+// File returns the config file which was read, empty if none was.
+func (c *Config) File() string {
+	return c.file
+}
+
+// Origin returns where the value of the setting with the config file key comes from: the default, an environment variable,
+// the config file or a flag.
+func (c *Config) Origin(key string) string {
+	if origin, ok := c.origins[key]; ok {
+		return origin
+	}
+	return OriginDefault
+}
+
+// Print writes every setting with its effective value and where it comes from. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range settings {
+		v := format(s.field(c))
+		if s.redact != nil && v != "" {
+			v = s.redact(v)
+		}
+		if v == "" {
+			v = `""`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.key, v, c.Origin(s.key))
+	}
+	return tw.Flush()
+}
+
+// format formats the value of a field.
+func format(field interface{}) string {
+	switch p := field.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *time.Duration:
+		return p.String()
+	}
+	return fmt.Sprint(field)
+}
+
+func redactSecret(string) string {
+	return redacted
+}
+
+// urlPassword matches the password of the URLs in a value.
+var urlPassword = regexp.MustCompile(`(://[^/@:;,]*:)[^/@;,]*@`)
+
+// redactURLs hides the passwords of the URLs in the value.
+func redactURLs(s string) string {
+	return urlPassword.ReplaceAllString(s, "${1}"+redacted+"@")
+}
+
+// redactHeaders hides the header values, which often hold credentials, keeping the names.
+func redactHeaders(s string) string {
+	pairs := strings.Split(s, ",")
+	for i, pair := range pairs {
+		if name, _, found := strings.Cut(pair, "="); found {
+			pairs[i] = name + "=" + redacted
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlExtensions are the extensions of the YAML config files, which are looked for before the other formats.
+var yamlExtensions = []string{".yaml", ".yml"}
+
+// pairs is how a YAML map is written as the value of a setting, the way the properties file writes it.
+type pairs int
+
+const (
+	// noPairs settings cannot be maps
+	noPairs pairs = iota
+	// mapPairs are comma separated name=value pairs, a list value repeats the name, e.g. headers and query parameters
+	mapPairs
+	// mapSources are semicolon separated name=value pairs, a list value is comma separated, e.g. the named sources
+	mapSources
+)
+
+// readYAML reads a YAML config file, whose settings are grouped in the prometheus, collection, entities, filters and output
+// sections, e.g.
+//
+//	prometheus:
+//	  address: prometheus-server.monitoring.svc
+//	  headers:
+//	    X-Scope-OrgID: tenant1
+//	collection:
+//	  interval: hours
+//	  history: 1
+//	entities:
+//	  include: [container, node, cluster]
+//	filters:
+//	  exclude_namespaces: [kube-*]
+//
+// Lists are comma separated and maps written as name=value pairs, as in config.properties. For compatibility, the keys of
+// config.properties are accepted at the top level as well; a setting set in its section wins. Unknown settings are reported.
+func readYAML(file string, errs *Errors) (map[string]string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err = yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	byPath := map[string]setting{}
+	byKey := map[string]setting{}
+	for _, s := range settings {
+		if s.path != "" {
+			byPath[s.path] = s
+		}
+		if !s.location {
+			byKey[s.key] = s
+		}
+	}
+	isSection := func(path string) bool {
+		for p := range byPath {
+			if strings.HasPrefix(p, path+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	values := map[string]string{}
+	compat := map[string]string{}
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v, path := m[name], prefix+name
+			s, ok := byPath[path]
+			if !ok && prefix == "" {
+				if s, ok = byKey[name]; ok {
+					if value, err := yamlValue(s, v); err != nil {
+						errs.Addf("%s in the config file %v", path, err)
+					} else {
+						compat[s.key] = value
+					}
+					continue
+				}
+			}
+			if ok {
+				if value, err := yamlValue(s, v); err != nil {
+					errs.Addf("%s in the config file %v", path, err)
+				} else {
+					values[s.key] = value
+				}
+				continue
+			}
+			if !isSection(path) {
+				errs.Addf("%s in the config file is not a known setting", path)
+			} else if section, isMap := v.(map[string]interface{}); isMap {
+				walk(path+".", section)
+			} else {
+				errs.Addf("%s in the config file is a section, not a value", path)
+			}
+		}
+	}
+	walk("", doc)
+	for key, value := range compat {
+		if _, ok := values[key]; !ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// yamlValue writes the YAML value as the string value of the setting.
+func yamlValue(s setting, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		return joinList(v)
+	case map[string]interface{}:
+		if s.pairs == noPairs {
+			return "", errors.New("cannot be a map")
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var values []string
+		for _, name := range names {
+			list, isList := v[name].([]interface{})
+			switch {
+			case isList && s.pairs == mapSources:
+				value, err := joinList(list)
+				if err != nil {
+					return "", err
+				}
+				values = append(values, name+"="+value)
+			case isList:
+				for _, item := range list {
+					value, err := scalar(item)
+					if err != nil {
+						return "", err
+					}
+					values = append(values, name+"="+value)
+				}
+			default:
+				value, err := scalar(v[name])
+				if err != nil {
+					return "", err
+				}
+				values = append(values, name+"="+value)
+			}
+		}
+		if s.pairs == mapSources {
+			return strings.Join(values, ";"), nil
+		}
+		return strings.Join(values, ","), nil
+	default:
+		return scalar(v)
+	}
+}
+
+func joinList(list []interface{}) (string, error) {
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		value, err := scalar(item)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, ","), nil
+}
+
+func scalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}, map[string]interface{}:
+		return "", errors.New("is nested too deeply")
+	case string:
+		return v, nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// fileName returns the name of the setting in the config file: its path in the sections of a YAML file, its key otherwise.
+func fileName(file string, s setting) string {
+	ext := filepath.Ext(file)
+	for _, e := range yamlExtensions {
+		if ext == e && s.path != "" {
+			return s.path
+		}
+	}
+	return s.key
+}
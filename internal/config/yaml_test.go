@@ -0,0 +1,221 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeFile writes the content to the file in a temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadYAML(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		values map[string]string
+		errs   string
+	}{
+		{
+			name: "sections",
+			yaml: `
+prometheus:
+  address: prometheus-server.monitoring.svc
+  port: 9090
+  timeouts:
+    query: 2m
+  auth:
+    sigv4:
+      region: eu-west-1
+collection:
+  history: 2
+  run_timeout:
+output:
+  debug: true
+`,
+			values: map[string]string{
+				"prometheus_address": "prometheus-server.monitoring.svc", "prometheus_port": "9090", "prometheus_query_timeout": "2m",
+				"prometheus_sigv4_region": "eu-west-1", "history": "2", "run_timeout": "", "debug": "true",
+			},
+		},
+		{
+			name: "lists and maps",
+			yaml: `
+entities:
+  include: [container, node]
+prometheus:
+  headers:
+    X-Scope-OrgID: tenant1
+    Authorization: Bearer x
+  query_params:
+    replicaLabels[]: [replica, prometheus_replica]
+    max_source_resolution: 5m
+  sources:
+    ksm: http://ksm:9090
+    nodes: [http://n1:9090, http://n2:9090]
+  routes:
+    kube: ksm
+    node: nodes
+`,
+			values: map[string]string{
+				"include_list":            "container,node",
+				"prometheus_headers":      "Authorization=Bearer x,X-Scope-OrgID=tenant1",
+				"prometheus_query_params": "max_source_resolution=5m,replicaLabels[]=replica,replicaLabels[]=prometheus_replica",
+				"prometheus_sources":      "ksm=http://ksm:9090;nodes=http://n1:9090,http://n2:9090",
+				"prometheus_routes":       "kube=ksm,node=nodes",
+			},
+		},
+		{
+			name: "top-level properties keys",
+			yaml: `
+prometheus_address: old
+history: 3
+prometheus:
+  address: new
+`,
+			values: map[string]string{"prometheus_address": "new", "history": "3"},
+		},
+		{
+			name: "unknown keys",
+			yaml: `
+config_path: /elsewhere
+prometheus:
+  adress: typo
+  tls:
+    ca_certificate: /ca.crt
+    verify: true
+proxy_host: proxy
+`,
+			values: map[string]string{"ca_certificate": "/ca.crt"},
+			errs: "config_path in the config file is not a known setting\n" +
+				"prometheus.adress in the config file is not a known setting\n" +
+				"prometheus.tls.verify in the config file is not a known setting\n" +
+				"proxy_host in the config file is not a known setting",
+		},
+		{
+			name: "type errors",
+			yaml: `
+collection:
+  interval: {unit: hours}
+entities:
+  include: [[container]]
+filters: kube-*
+prometheus:
+  headers:
+    X-Scope-OrgID: {tenant: 1}
+`,
+			values: map[string]string{},
+			errs: "collection.interval in the config file cannot be a map\n" +
+				"entities.include in the config file is nested too deeply\n" +
+				"filters in the config file is a section, not a value\n" +
+				"prometheus.headers in the config file is nested too deeply",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs Errors
+			values, err := readYAML(writeFile(t, "config.yaml", tt.yaml), &errs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("readYAML() = %v, want %v", values, tt.values)
+			}
+			if got := validationErrors(errs); got != tt.errs {
+				t.Errorf("readYAML() errors %q, want %q", got, tt.errs)
+			}
+		})
+	}
+
+	var errs Errors
+	if _, err := readYAML(writeFile(t, "config.yaml", "prometheus:\n  address: [a\n"), &errs); err == nil {
+		t.Error("readYAML() of invalid YAML succeeded")
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	file := writeFile(t, "config.yml", "collection:\n  history: many\n  interval: days\nhistory: 4\nprometheus:\n  max_qps: x\n")
+	clearEnv(t)
+	c, errs := load(t, "-path", filepath.Dir(file))
+	if c.File() != file || c.Interval != "days" || c.Origin("interval") != "file "+file {
+		t.Errorf("file %s, interval %s from %s", c.File(), c.Interval, c.Origin("interval"))
+	}
+	// the value in its section wins, even if it does not parse; the errors name the settings by their path
+	want := `collection.history in the config file is not an integer: "many"` + "\n" +
+		`prometheus.max_qps in the config file is not a number: "x"`
+	if got := validationErrors(errs); got != want {
+		t.Errorf("Load() errors %q, want %q", got, want)
+	}
+	if c.History != 1 {
+		t.Errorf("history = %d, want the default", c.History)
+	}
+}
+
+// TestLoadExamples loads the config file examples shipped with the docs, the config directory and the example config maps.
+func TestLoadExamples(t *testing.T) {
+	b, err := os.ReadFile("../../docs/Config-Variables.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, doc, found := strings.Cut(string(b), "```yaml\n")
+	doc, _, _ = strings.Cut(doc, "```")
+	if !found {
+		t.Fatal("no YAML example in the docs")
+	}
+	examples := map[string]string{"docs": writeFile(t, "config.yaml", doc)}
+
+	b, err = os.ReadFile("../../config/config.properties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples["config"] = writeFile(t, "config.properties", string(b))
+	configMaps, err := filepath.Glob("../../examples/*/configmap.yml")
+	if err != nil || len(configMaps) == 0 {
+		t.Fatalf("no example config maps: %v", err)
+	}
+	for _, configMap := range configMaps {
+		b, err := os.ReadFile(configMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cm struct {
+			Data map[string]string `yaml:"data"`
+		}
+		if err = yaml.Unmarshal(b, &cm); err != nil {
+			t.Fatalf("%s: %v", configMap, err)
+		}
+		properties, ok := cm.Data["config.properties"]
+		if !ok {
+			t.Fatalf("%s has no config.properties", configMap)
+		}
+		examples[configMap] = writeFile(t, "config.properties", properties)
+	}
+
+	for name, file := range examples {
+		clearEnv(t)
+		c, errs := load(t, "-path", filepath.Dir(file))
+		if len(errs) > 0 {
+			t.Errorf("%s: %v", name, errs)
+			continue
+		}
+		if c.File() != file || c.Address == "" || c.Origin("prometheus_address") != "file "+file {
+			t.Errorf("%s: file %s, address %q from %s", name, c.File(), c.Address, c.Origin("prometheus_address"))
+		}
+	}
+	clearEnv(t)
+	c, _ := load(t, "-path", filepath.Dir(examples["docs"]))
+	if c.Headers != "X-Scope-OrgID=tenant1" || c.ExcludeNamespaces != "kube-*,openshift-*" || c.QueryTimeout.String() != "2m0s" {
+		t.Errorf("docs: headers %q, exclude namespaces %q, query timeout %s", c.Headers, c.ExcludeNamespaces, c.QueryTimeout)
+	}
+}
@@ -2,6 +2,7 @@
 package container2
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -313,11 +314,17 @@ func getNamespaceMetricString(result model.Value, namespace model.LabelName) {
 	}
 }
 
-func getWorkload(fileName, metricName, query, aggregator string, args *common.Parameters) {
+func getWorkload(ctx context.Context, fileName, metricName, query, aggregator string, args *common.Parameters) {
+	if ctx.Err() != nil {
+		return
+	}
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
 	var query2 string
+	// the container metrics are joined with the pod owners of kube-state-metrics by pod
+	containerLabel := model.LabelName("container" + args.LabelSuffix)
+	podMatch := []model.LabelName{"pod", "namespace"}
 
 	//Open the files that will be used for the workload data types and write out there headers.
 	workloadWrite, err := os.Create("./data/container/" + aggregator + `_` + fileName + ".csv")
@@ -326,64 +333,79 @@ func getWorkload(fileName, metricName, query, aggregator string, args *common.Pa
 		fmt.Println("[ERROR] entity=" + entityKind + " metric=" + metricName + " query=" + query + " message=" + err.Error())
 		return
 	}
+	defer workloadWrite.Close()
 	fmt.Fprintf(workloadWrite, "ClusterName,Namespace,EntityName,EntityType,ContainerName,MetricTime,%s\n", metricName)
 
 	//If the History parameter is set to anything but default 1 then will loop through the calls starting with the current day\hour\minute interval and work backwards.
 	//This is done as the farther you go back in time the slpwer prometheus querying becomes and we have seen cases where will not run from timeouts on Prometheus.
 	//As a result if we do hit an issue with timing out on Prometheus side we still can send the current data and data going back to that point vs losing it all.
 	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
+		if ctx.Err() != nil {
+			break
+		}
 		range5Min := common.TimeRange(args, historyInterval)
 
 		//query containers under a pod with no owner
-		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left max(kube_pod_owner{owner_name="<none>"}) by (namespace, pod, container` + args.LabelSuffix + `)) by (pod,namespace,container` + args.LabelSuffix + `)`
-		result, err = common.MetricCollect(args, query2, range5Min)
+		join := &common.Join{Aggregator: aggregator, Query: query, By: []model.LabelName{"pod", "namespace", containerLabel}, Matches: []common.Match{
+			{On: podMatch, Info: `max(kube_pod_owner{owner_name="<none>"}) by (namespace, pod, ` + string(containerLabel) + `)`}}}
+		query2 = join.String()
+		result, err = common.CollectJoin(ctx, args, join, range5Min, entityKind, "pod_"+metricName)
 
 		if err != nil {
 			args.WarnLogger.Println("metric=pod_" + metricName + " query=" + query2 + " message=" + err.Error())
 			fmt.Println("[WARNING] metric=pod_" + metricName + " query=" + query2 + " message=" + err.Error())
 		} else {
-			writeWorkload(workloadWrite, result, "namespace", "pod", model.LabelName("container"+args.LabelSuffix), args, "Pod")
+			writeWorkload(workloadWrite, result, "namespace", "pod", containerLabel, args, "Pod")
 		}
 
 		//query containers under a controller with no owner
-		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (owner_name,owner_kind) max(kube_pod_owner) by (namespace, pod, owner_name, owner_kind)) by (owner_kind,owner_name,namespace,container` + args.LabelSuffix + `)`
-		result, err = common.MetricCollect(args, query2, range5Min)
+		join = &common.Join{Aggregator: aggregator, Query: query, By: []model.LabelName{"owner_kind", "owner_name", "namespace", containerLabel}, Matches: []common.Match{
+			{On: podMatch, Include: []model.LabelName{"owner_name", "owner_kind"}, Info: `max(kube_pod_owner) by (namespace, pod, owner_name, owner_kind)`}}}
+		query2 = join.String()
+		result, err = common.CollectJoin(ctx, args, join, range5Min, entityKind, "controller_"+metricName)
 		if err != nil {
 			args.WarnLogger.Println("metric=controller_" + metricName + " query=" + query2 + " message=" + err.Error())
 			fmt.Println("[WARNING] metric=controller_" + metricName + " query=" + query2 + " message=" + err.Error())
 		} else {
-			writeWorkload(workloadWrite, result, "namespace", "owner_name", model.LabelName("container"+args.LabelSuffix), args, "")
+			writeWorkload(workloadWrite, result, "namespace", "owner_name", containerLabel, args, "")
 		}
 
 		//query containers under a deployment
 		if args.Deployments {
-			query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (replicaset) max(label_replace(kube_pod_owner{owner_kind="ReplicaSet"}, "replicaset", "$1", "owner_name", "(.*)")) by (namespace, pod, replicaset) * on (replicaset, namespace) group_left (owner_name) max(kube_replicaset_owner{owner_kind="Deployment"}) by (namespace, replicaset, owner_name)) by (owner_name,namespace,container` + args.LabelSuffix + `)`
-			result, err = common.MetricCollect(args, query2, range5Min)
+			join = &common.Join{Aggregator: aggregator, Query: query, By: []model.LabelName{"owner_name", "namespace", containerLabel}, Matches: []common.Match{
+				{On: podMatch, Include: []model.LabelName{"replicaset"}, Info: `max(label_replace(kube_pod_owner{owner_kind="ReplicaSet"}, "replicaset", "$1", "owner_name", "(.*)")) by (namespace, pod, replicaset)`},
+				{On: []model.LabelName{"replicaset", "namespace"}, Include: []model.LabelName{"owner_name"}, Info: `max(kube_replicaset_owner{owner_kind="Deployment"}) by (namespace, replicaset, owner_name)`}}}
+			query2 = join.String()
+			result, err = common.CollectJoin(ctx, args, join, range5Min, entityKind, "deployment_"+metricName)
 			if err != nil {
 				args.WarnLogger.Println("metric=deployment_" + metricName + " query=" + query2 + " message=" + err.Error())
 				fmt.Println("[WARNING] metric=deployment_" + metricName + " query=" + query2 + " message=" + err.Error())
 			} else {
-				writeWorkload(workloadWrite, result, "namespace", "owner_name", model.LabelName("container"+args.LabelSuffix), args, "Deployment")
+				writeWorkload(workloadWrite, result, "namespace", "owner_name", containerLabel, args, "Deployment")
 			}
 		}
 
 		//query containers under a cron job
 		if args.CronJobs {
-			query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (job) max(label_replace(kube_pod_owner{owner_kind="Job"}, "job", "$1", "owner_name", "(.*)")) by (namespace, pod, job) * on (job, namespace) group_left (owner_name) max(label_replace(kube_job_owner{owner_kind="CronJob"}, "job", "$1", "job_name", "(.*)")) by (namespace, job, owner_name)) by (owner_name,namespace,container` + args.LabelSuffix + `)`
-			result, err = common.MetricCollect(args, query2, range5Min)
+			join = &common.Join{Aggregator: aggregator, Query: query, By: []model.LabelName{"owner_name", "namespace", containerLabel}, Matches: []common.Match{
+				{On: podMatch, Include: []model.LabelName{"job"}, Info: `max(label_replace(kube_pod_owner{owner_kind="Job"}, "job", "$1", "owner_name", "(.*)")) by (namespace, pod, job)`},
+				{On: []model.LabelName{"job", "namespace"}, Include: []model.LabelName{"owner_name"}, Info: `max(label_replace(kube_job_owner{owner_kind="CronJob"}, "job", "$1", "job_name", "(.*)")) by (namespace, job, owner_name)`}}}
+			query2 = join.String()
+			result, err = common.CollectJoin(ctx, args, join, range5Min, entityKind, "cronJob_"+metricName)
 			if err != nil {
 				args.WarnLogger.Println("metric=cronJob_" + metricName + " query=" + query2 + " message=" + err.Error())
 				fmt.Println("[WARNING] metric=cronJob_" + metricName + " query=" + query2 + " message=" + err.Error())
 			} else {
-				writeWorkload(workloadWrite, result, "namespace", "owner_name", model.LabelName("container"+args.LabelSuffix), args, "CronJob")
+				writeWorkload(workloadWrite, result, "namespace", "owner_name", containerLabel, args, "CronJob")
 			}
 		}
 	}
-	//Close the workload files.
-	workloadWrite.Close()
 }
 
-func getDeploymentWorkload(fileName, metricName, query string, args *common.Parameters) {
+func getDeploymentWorkload(ctx context.Context, fileName, metricName, query string, args *common.Parameters) {
+	if ctx.Err() != nil {
+		return
+	}
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
@@ -395,16 +417,20 @@ func getDeploymentWorkload(fileName, metricName, query string, args *common.Para
 		fmt.Println("[ERROR] metric=" + metricName + " query=" + query + " message=File not found")
 		return
 	}
+	defer workloadWrite.Close()
 	hf, _ := common.GetCsvHeaderFormat(entityKind)
 	fmt.Fprintf(workloadWrite, hf, metricName)
 
 	tempMap := map[int]map[string]map[string][]model.SamplePair{}
 
 	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
+		if ctx.Err() != nil {
+			return
+		}
 		tempMap[int(historyInterval)] = map[string]map[string][]model.SamplePair{}
 		range5Min := common.TimeRange(args, historyInterval)
 
-		result, err = common.MetricCollect(args, query, range5Min)
+		result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, metricName)
 		if err != nil {
 			args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
 			fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
@@ -437,14 +463,16 @@ func getDeploymentWorkload(fileName, metricName, query string, args *common.Para
 			}
 		}
 	}
-	workloadWrite.Close()
 }
 
 const (
 	hpaSuffix = "_hpa"
 )
 
-func getHPAWorkload(fileName, metricName, query string, args *common.Parameters, hpaLabel model.LabelName) {
+func getHPAWorkload(ctx context.Context, fileName, metricName, query string, args *common.Parameters, hpaLabel model.LabelName) {
+	if ctx.Err() != nil {
+		return
+	}
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
@@ -456,12 +484,14 @@ func getHPAWorkload(fileName, metricName, query string, args *common.Parameters,
 		fmt.Println("[ERROR] metric=" + metricName + " query=" + query + " message=File not found")
 		return
 	}
+	defer workloadWrite.Close()
 	workloadWriteExtra, err := os.Create("./data/hpa/hpa_extra_" + fileName + ".csv")
 	if err != nil {
 		args.ErrorLogger.Println("metric=" + metricName + " query=" + query + " message=File not found")
 		fmt.Println("[ERROR] metric=" + metricName + " query=" + query + " message=File not found")
 		return
 	}
+	defer workloadWriteExtra.Close()
 	hf, _ := common.GetCsvHeaderFormat(entityKind + hpaSuffix)
 	fmt.Fprintf(workloadWrite, hf, metricName)
 	fmt.Fprintf(workloadWriteExtra, hf, metricName)
@@ -469,10 +499,13 @@ func getHPAWorkload(fileName, metricName, query string, args *common.Parameters,
 	tempMap := map[int]map[string]map[string][]model.SamplePair{}
 
 	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
+		if ctx.Err() != nil {
+			return
+		}
 		tempMap[int(historyInterval)] = map[string]map[string][]model.SamplePair{}
 		range5Min := common.TimeRange(args, historyInterval)
 
-		result, err = common.MetricCollect(args, query, range5Min)
+		result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, metricName)
 		if err != nil {
 			args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
 			fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
@@ -518,7 +551,6 @@ func getHPAWorkload(fileName, metricName, query string, args *common.Parameters,
 			}
 		}
 	}
-	workloadWrite.Close()
 	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
 		for i := range tempMap {
 			for n := range tempMap[i] {
@@ -530,5 +562,4 @@ func getHPAWorkload(fileName, metricName, query string, args *common.Parameters,
 			}
 		}
 	}
-	workloadWriteExtra.Close()
 }
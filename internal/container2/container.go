@@ -2,6 +2,7 @@
 package container2
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"runtime"
@@ -38,7 +39,7 @@ type container struct {
 }
 
 // Metrics function to collect data related to containers.
-func Metrics(args *common.Parameters) {
+func Metrics(ctx context.Context, args *common.Parameters) {
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -53,6 +54,7 @@ func Metrics(args *common.Parameters) {
 	var jobOwners = map[string]string{}
 
 	range5Min := common.TimeRange(args, historyInterval)
+	configRange := common.ConfigRange(args)
 	if args.Debug {
 		runtime.ReadMemStats(&mem)
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
@@ -60,7 +62,7 @@ func Metrics(args *common.Parameters) {
 	}
 	//querys gathering hierarchy information for the containers
 	query = `sum(kube_pod_owner{owner_name!="<none>"}) by (namespace, pod, owner_name, owner_kind)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "pods")
 	if err != nil {
 		args.ErrorLogger.Println("metric=pods query=" + query + " message=" + err.Error())
 		fmt.Println("[ERROR] metric=pods query=" + query + " message=" + err.Error())
@@ -73,7 +75,7 @@ func Metrics(args *common.Parameters) {
 	}
 
 	query = `sum(kube_replicaset_owner{owner_name!="<none>"}) by (namespace, replicaset, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "replicasets")
 	if err != nil {
 		args.WarnLogger.Println("metric=replicasets query=" + query + " message=" + err.Error())
 		fmt.Println("[WARNING] metric=replicasets query=" + query + " message=" + err.Error())
@@ -85,7 +87,7 @@ func Metrics(args *common.Parameters) {
 	}
 
 	query = `sum(kube_job_owner{owner_name!="<none>"}) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "jobs")
 	if err != nil {
 		args.WarnLogger.Println("metric=jobs query=" + query + " message=" + err.Error())
 		fmt.Println("[WARNING] metric=jobs query=" + query + " message=" + err.Error())
@@ -97,7 +99,7 @@ func Metrics(args *common.Parameters) {
 	}
 
 	query = `max(kube_pod_container_info) by (container, pod, namespace)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "containers")
 	if err != nil {
 		args.ErrorLogger.Println("metric=containers query=" + query + " message=" + err.Error())
 		fmt.Println("[ERROR] metric=containers query=" + query + " message=" + err.Error())
@@ -123,6 +125,9 @@ func Metrics(args *common.Parameters) {
 		var ownerKind string
 
 		namespaceName := string(result.(model.Matrix)[i].Metric["namespace"])
+		if !common.IncludeNamespace(args, namespaceName) {
+			continue
+		}
 		if _, ok := systems[namespaceName]; !ok {
 			systems[namespaceName] = &namespace{pointers: map[string]*midLevel{}, midLevels: map[string]*midLevel{}, cpuRequest: -1, cpuLimit: -1, memRequest: -1, memLimit: -1, podsLimit: -1, labelMap: map[string]string{}}
 		}
@@ -191,84 +196,97 @@ func Metrics(args *common.Parameters) {
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
 	//Container metrics
-	query = `container_spec_memory_limit_bytes{name!~"k8s_POD_.*"}/1024/1024`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=memory query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=memory query=" + query + " message=" + err.Error())
-	} else {
-		if args.LabelSuffix == "" && getContainerMetric(result, "namespace", "pod", "container", "memory") {
-			//Don't do anything
-		} else if getContainerMetric(result, "namespace", "pod_name", "container_name", "memory") {
-			args.LabelSuffix = "_name"
-		}
-	}
-
-	query = `sum(kube_pod_container_resource_limits) by (pod,namespace,container,resource)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_resource_limits_cpu_cores) by (pod,namespace,container)*1000`
-		result, err = common.MetricCollect(args, query, range5Min)
+	//Attribute queries
+	//They only need the latest values, so they are instant queries (see common.ConfigRange).
+	queries := common.NewQueries(ctx, args, configRange, entityKind)
+	queries.Add(`container_spec_memory_limit_bytes{name!~"k8s_POD_.*"}/1024/1024`, "memory", func(query string, result model.Value, err error) {
 		if err != nil {
-			args.WarnLogger.Println("metric=cpuLimit query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cpuLimit query=" + query + " message=" + err.Error())
+			args.WarnLogger.Println("metric=memory query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=memory query=" + query + " message=" + err.Error())
+		} else {
+			getContainerMetric(result, "namespace", model.LabelName("pod"+args.LabelSuffix), model.LabelName("container"+args.LabelSuffix), "memory")
+		}
+	})
+
+	//kube-state-metrics 1.x reports the limits in a metric per resource.
+	if common.FamilyAvailable(args, "the container limits", "kube_pod_container_resource_limits", "kube_pod_container_resource_limits_cpu_cores", "kube_pod_container_resource_limits_memory_bytes") {
+		if common.Available(args, "kube_pod_container_resource_limits") {
+			queries.Add(`sum(kube_pod_container_resource_limits) by (pod,namespace,container,resource)`, "limits", func(query string, result model.Value, err error) {
+				if err != nil {
+					args.WarnLogger.Println("metric=limits query=" + query + " message=" + err.Error())
+					fmt.Println("[WARNING] metric=limits query=" + query + " message=" + err.Error())
+				} else {
+					getContainerMetric(result, "namespace", "pod", "container", "limits")
+				}
+			})
 		} else {
-			getContainerMetric(result, "namespace", "pod", "container", "cpuLimit")
+			queries.AddIfAvailable("kube_pod_container_resource_limits_cpu_cores", `sum(kube_pod_container_resource_limits_cpu_cores) by (pod,namespace,container)*1000`, "cpuLimit", func(query string, result model.Value, err error) {
+				if err != nil {
+					args.WarnLogger.Println("metric=cpuLimit query=" + query + " message=" + err.Error())
+					fmt.Println("[WARNING] metric=cpuLimit query=" + query + " message=" + err.Error())
+				} else {
+					getContainerMetric(result, "namespace", "pod", "container", "cpuLimit")
+				}
+			})
+			queries.AddIfAvailable("kube_pod_container_resource_limits_memory_bytes", `sum(kube_pod_container_resource_limits_memory_bytes) by (pod,namespace,container)/1024/1024`, "memLimit", func(query string, result model.Value, err error) {
+				if err != nil {
+					args.WarnLogger.Println("metric=memLimit query=" + query + " message=" + err.Error())
+					fmt.Println("[WARNING] metric=memLimit query=" + query + " message=" + err.Error())
+				} else {
+					getContainerMetric(result, "namespace", "pod", "container", "memLimit")
+				}
+			})
 		}
+	}
 
-		query = `sum(kube_pod_container_resource_limits_memory_bytes) by (pod,namespace,container)/1024/1024`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=memLimit query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=memLimit query=" + query + " message=" + err.Error())
+	//kube-state-metrics 1.x reports the requests in a metric per resource.
+	if common.FamilyAvailable(args, "the container requests", "kube_pod_container_resource_requests", "kube_pod_container_resource_requests_cpu_cores", "kube_pod_container_resource_requests_memory_bytes") {
+		if common.Available(args, "kube_pod_container_resource_requests") {
+			queries.Add(`sum(kube_pod_container_resource_requests) by (pod,namespace,container,resource)`, "requests", func(query string, result model.Value, err error) {
+				if err != nil {
+					args.WarnLogger.Println("metric=requests query=" + query + " message=" + err.Error())
+					fmt.Println("[WARNING] metric=requests query=" + query + " message=" + err.Error())
+				} else {
+					getContainerMetric(result, "namespace", "pod", "container", "requests")
+				}
+			})
 		} else {
-			getContainerMetric(result, "namespace", "pod", "container", "memLimit")
+			queries.AddIfAvailable("kube_pod_container_resource_requests_cpu_cores", `sum(kube_pod_container_resource_requests_cpu_cores) by (pod,namespace,container)*1000`, "cpuRequest", func(query string, result model.Value, err error) {
+				if err != nil {
+					args.WarnLogger.Println("metric=cpuRequest query=" + query + " message=" + err.Error())
+					fmt.Println("[WARNING] metric=cpuRequest query=" + query + " message=" + err.Error())
+				} else {
+					getContainerMetric(result, "namespace", "pod", "container", "cpuRequest")
+				}
+			})
+			queries.AddIfAvailable("kube_pod_container_resource_requests_memory_bytes", `sum(kube_pod_container_resource_requests_memory_bytes) by (pod,namespace,container)/1024/1024`, "memRequest", func(query string, result model.Value, err error) {
+				if err != nil {
+					args.WarnLogger.Println("metric=memRequest query=" + query + " message=" + err.Error())
+					fmt.Println("[WARNING] metric=memRequest query=" + query + " message=" + err.Error())
+				} else {
+					getContainerMetric(result, "namespace", "pod", "container", "memRequest")
+				}
+			})
 		}
-	} else {
-		getContainerMetric(result, "namespace", "pod", "container", "limits")
 	}
 
-	query = `sum(kube_pod_container_resource_requests) by (pod,namespace,container,resource)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_resource_requests_cpu_cores) by (pod,namespace,container)*1000`
-		result, err = common.MetricCollect(args, query, range5Min)
+	queries.Add(`container_spec_cpu_shares{name!~"k8s_POD_.*"}`, "conLabel", func(query string, result model.Value, err error) {
 		if err != nil {
-			args.WarnLogger.Println("metric=cpuRequest query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cpuRequest query=" + query + " message=" + err.Error())
+			args.WarnLogger.Println("metric=conLabel query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=conLabel query=" + query + " message=" + err.Error())
 		} else {
-			getContainerMetric(result, "namespace", "pod", "container", "cpuRequest")
+			getContainerMetricString(result, "namespace", model.LabelName("pod"+args.LabelSuffix), model.LabelName("container"+args.LabelSuffix))
 		}
+	})
 
-		query = `sum(kube_pod_container_resource_requests_memory_bytes) by (pod,namespace,container)/1024/1024`
-		result, err = common.MetricCollect(args, query, range5Min)
+	queries.Add(`kube_pod_container_info`, "conInfo", func(query string, result model.Value, err error) {
 		if err != nil {
-			args.WarnLogger.Println("metric=memRequest query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=memRequest query=" + query + " message=" + err.Error())
+			args.WarnLogger.Println("metric=conInfo query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=conInfo query=" + query + " message=" + err.Error())
 		} else {
-			getContainerMetric(result, "namespace", "pod", "container", "memRequest")
+			getContainerMetricString(result, "namespace", "pod", "container")
 		}
-	} else {
-		getContainerMetric(result, "namespace", "pod", "container", "requests")
-	}
-
-	query = `container_spec_cpu_shares{name!~"k8s_POD_.*"}`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=conLabel query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=conLabel query=" + query + " message=" + err.Error())
-	} else {
-		getContainerMetricString(result, "namespace", model.LabelName("pod"+args.LabelSuffix), model.LabelName("container"+args.LabelSuffix))
-	}
-
-	query = `kube_pod_container_info`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=conInfo query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=conInfo query=" + query + " message=" + err.Error())
-	} else {
-		getContainerMetricString(result, "namespace", "pod", "container")
-	}
+	})
 
 	//Pod metrics
 	if args.Debug {
@@ -278,56 +296,55 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_pod_info`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=podInfo query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=podInfo query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "pod", "Pod")
-	}
+	queries.Add(`kube_pod_info`, "podInfo", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=podInfo query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=podInfo query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "pod", "Pod")
+		}
+	})
 
-	query = `kube_pod_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=podLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=podLabels query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "pod", "Pod")
-	}
+	queries.Add(`kube_pod_labels`, "podLabels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=podLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=podLabels query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "pod", "Pod")
+		}
+	})
 
-	query = `sum(kube_pod_container_status_restarts_total) by (pod,namespace,container)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=restarts query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=restarts query=" + query + " message=" + err.Error())
-	} else {
-		getContainerMetric(result, "namespace", "pod", "container", "restarts")
-	}
+	queries.Add(`sum(kube_pod_container_status_restarts_total) by (pod,namespace,container)`, "restarts", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=restarts query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=restarts query=" + query + " message=" + err.Error())
+		} else {
+			getContainerMetric(result, "namespace", "pod", "container", "restarts")
+		}
+	})
 
-	query = `sum(kube_pod_container_status_terminated) by (pod,namespace,container)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_status_terminated_reason) by (pod,namespace,container)`
-		result, err = common.MetricCollect(args, query, range5Min)
+	//Older versions of kube-state-metrics only have the terminated reasons.
+	powerStateMetric := "kube_pod_container_status_terminated"
+	if !common.Available(args, powerStateMetric) {
+		powerStateMetric = "kube_pod_container_status_terminated_reason"
+	}
+	queries.AddIfAvailable(powerStateMetric, `sum(`+powerStateMetric+`) by (pod,namespace,container)`, "powerState", func(query string, result model.Value, err error) {
 		if err != nil {
 			args.WarnLogger.Println("metric=powerState query=" + query + " message=" + err.Error())
 			fmt.Println("[WARNING] metric=powerState query=" + query + " message=" + err.Error())
 		} else {
 			getContainerMetric(result, "namespace", "pod", "container", "powerState")
 		}
-	} else {
-		getContainerMetric(result, "namespace", "pod", "container", "powerState")
-	}
+	})
 
-	query = `kube_pod_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=podCreationTime query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=podCreationTime query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "pod", "creationTime", "Pod")
-	}
+	queries.Add(`kube_pod_created`, "podCreationTime", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=podCreationTime query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=podCreationTime query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "pod", "creationTime", "Pod")
+		}
+	})
 
 	//Namespace metrics
 	if args.Debug {
@@ -337,33 +354,33 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_namespace_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=namespaceLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=namespaceLabels query=" + query + " message=" + err.Error())
-	} else {
-		getNamespaceMetricString(result, "namespace")
-	}
+	queries.Add(`kube_namespace_labels`, "namespaceLabels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=namespaceLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=namespaceLabels query=" + query + " message=" + err.Error())
+		} else {
+			getNamespaceMetricString(result, "namespace")
+		}
+	})
 
-	query = `kube_namespace_annotations`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=namespaceAnnotations query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=namespaceAnnotations query=" + query + " message=" + err.Error())
-	} else {
-		getNamespaceMetricString(result, "namespace")
-	}
+	queries.Add(`kube_namespace_annotations`, "namespaceAnnotations", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=namespaceAnnotations query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=namespaceAnnotations query=" + query + " message=" + err.Error())
+		} else {
+			getNamespaceMetricString(result, "namespace")
+		}
+	})
 
 	//This is min as want to know what the most restrictive quota is if there are multiple.
-	query = `min(kube_resourcequota{type="hard"}) by (resource, namespace)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=namespaceResourceQuota query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=namespaceResourceQuota query=" + query + " message=" + err.Error())
-	} else {
-		getNamespacelimits(result, "namespace")
-	}
+	queries.Add(`min(kube_resourcequota{type="hard"}) by (resource, namespace)`, "namespaceResourceQuota", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=namespaceResourceQuota query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=namespaceResourceQuota query=" + query + " message=" + err.Error())
+		} else {
+			getNamespacelimits(result, "namespace")
+		}
+	})
 
 	//Deployment metrics
 	if args.Debug {
@@ -373,50 +390,50 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_deployment_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=labels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=labels query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "deployment", "Deployment")
-	}
+	queries.Add(`kube_deployment_labels`, "labels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=labels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=labels query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "deployment", "Deployment")
+		}
+	})
 
-	query = `kube_deployment_spec_strategy_rollingupdate_max_surge`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=maxSurge query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=maxSurge query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "deployment", "maxSurge", "Deployment")
-	}
+	queries.Add(`kube_deployment_spec_strategy_rollingupdate_max_surge`, "maxSurge", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=maxSurge query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=maxSurge query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "deployment", "maxSurge", "Deployment")
+		}
+	})
 
-	query = `kube_deployment_spec_strategy_rollingupdate_max_unavailable`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=maxUnavailable query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=maxUnavailable query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "deployment", "maxUnavailable", "Deployment")
-	}
+	queries.Add(`kube_deployment_spec_strategy_rollingupdate_max_unavailable`, "maxUnavailable", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=maxUnavailable query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=maxUnavailable query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "deployment", "maxUnavailable", "Deployment")
+		}
+	})
 
-	query = `kube_deployment_metadata_generation`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=metadataGeneration query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=metadataGeneration query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "deployment", "metadataGeneration", "Deployment")
-	}
+	queries.Add(`kube_deployment_metadata_generation`, "metadataGeneration", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=metadataGeneration query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=metadataGeneration query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "deployment", "metadataGeneration", "Deployment")
+		}
+	})
 
-	query = `kube_deployment_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=deploymentCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=deploymentCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "deployment", "creationTime", "Deployment")
-	}
+	queries.Add(`kube_deployment_created`, "deploymentCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=deploymentCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=deploymentCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "deployment", "creationTime", "Deployment")
+		}
+	})
 
 	//ReplicaSet metrics
 	if args.Debug {
@@ -426,23 +443,23 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_replicaset_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=replicaSetLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=replicaSetLabels query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "replicaset", "ReplicaSet")
-	}
+	queries.Add(`kube_replicaset_labels`, "replicaSetLabels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=replicaSetLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=replicaSetLabels query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "replicaset", "ReplicaSet")
+		}
+	})
 
-	query = `kube_replicaset_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=replicaSetCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=replicaSetCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "replicaset", "creationTime", "ReplicaSet")
-	}
+	queries.Add(`kube_replicaset_created`, "replicaSetCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=replicaSetCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=replicaSetCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "replicaset", "creationTime", "ReplicaSet")
+		}
+	})
 
 	//ReplicationController metrics
 	if args.Debug {
@@ -452,14 +469,14 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_replicationcontroller_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=replicationControllerCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=replicationControllerCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "replicationcontroller", "creationTime", "ReplicationController")
-	}
+	queries.Add(`kube_replicationcontroller_created`, "replicationControllerCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=replicationControllerCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=replicationControllerCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "replicationcontroller", "creationTime", "ReplicationController")
+		}
+	})
 
 	//DaemonSet metrics
 	if args.Debug {
@@ -469,23 +486,23 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_daemonset_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=daemonSetLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=daemonSetLabels query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "daemonset", "DaemonSet")
-	}
+	queries.Add(`kube_daemonset_labels`, "daemonSetLabels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=daemonSetLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=daemonSetLabels query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "daemonset", "DaemonSet")
+		}
+	})
 
-	query = `kube_daemonset_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=daemonSetCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=daemonSetCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "daemonset", "creationTime", "DaemonSet")
-	}
+	queries.Add(`kube_daemonset_created`, "daemonSetCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=daemonSetCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=daemonSetCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "daemonset", "creationTime", "DaemonSet")
+		}
+	})
 
 	//StatefulSet metrics
 	if args.Debug {
@@ -495,23 +512,23 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_statefulset_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=statefulSetLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=statefulSetLabels query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "statefulset", "StatefulSet")
-	}
+	queries.Add(`kube_statefulset_labels`, "statefulSetLabels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=statefulSetLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=statefulSetLabels query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "statefulset", "StatefulSet")
+		}
+	})
 
-	query = `kube_statefulset_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=statefulSetCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=statefulSetCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "statefulset", "creationTime", "StatefulSet")
-	}
+	queries.Add(`kube_statefulset_created`, "statefulSetCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=statefulSetCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=statefulSetCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "statefulset", "creationTime", "StatefulSet")
+		}
+	})
 
 	//Job metrics
 	if args.Debug {
@@ -521,68 +538,68 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_job_info * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobInfo query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobInfo query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "job_name", "Job")
-	}
+	queries.Add(`kube_job_info * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`, "jobInfo", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobInfo query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobInfo query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "job_name", "Job")
+		}
+	})
 
-	query = `kube_job_labels * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobLabel query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobLabel query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "job_name", "Job")
-	}
+	queries.Add(`kube_job_labels * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`, "jobLabel", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobLabel query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobLabel query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "job_name", "Job")
+		}
+	})
 
-	query = `kube_job_spec_completions * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobSpecCompletions query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobSpecCompletions query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "job_name", "specCompletions", "Job")
-	}
+	queries.Add(`kube_job_spec_completions * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`, "jobSpecCompletions", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobSpecCompletions query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobSpecCompletions query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "job_name", "specCompletions", "Job")
+		}
+	})
 
-	query = `kube_job_spec_parallelism * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobSpecParallelism query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobSpecParallelism query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "job_name", "specParallelism", "Job")
-	}
+	queries.Add(`kube_job_spec_parallelism * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`, "jobSpecParallelism", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobSpecParallelism query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobSpecParallelism query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "job_name", "specParallelism", "Job")
+		}
+	})
 
-	query = `kube_job_status_completion_time * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobStatusCompletionTime query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobStatusCompletionTime query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "job_name", "statusCompletionTime", "Job")
-	}
+	queries.Add(`kube_job_status_completion_time * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`, "jobStatusCompletionTime", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobStatusCompletionTime query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobStatusCompletionTime query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "job_name", "statusCompletionTime", "Job")
+		}
+	})
 
-	query = `kube_job_status_start_time * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobStatusStartTime query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobStatusStartTime query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "job_name", "statusStartTime", "Job")
-	}
+	queries.Add(`kube_job_status_start_time * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`, "jobStatusStartTime", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobStatusStartTime query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobStatusStartTime query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "job_name", "statusStartTime", "Job")
+		}
+	})
 
-	query = `kube_job_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=jobCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=jobCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "job", "creationTime", "Job")
-	}
+	queries.Add(`kube_job_created`, "jobCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=jobCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=jobCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "job", "creationTime", "Job")
+		}
+	})
 
 	//CronJob metrics
 	if args.Debug {
@@ -592,59 +609,59 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_cronjob_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=cronJobLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=cronJobLabels query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "cronjob", "CronJob")
-	}
+	queries.Add(`kube_cronjob_labels`, "cronJobLabels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=cronJobLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=cronJobLabels query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "cronjob", "CronJob")
+		}
+	})
 
-	query = `kube_cronjob_info`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=cronJobInfo query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=cronJobInfo query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetricString(result, "namespace", "cronjob", "CronJob")
-	}
+	queries.Add(`kube_cronjob_info`, "cronJobInfo", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=cronJobInfo query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=cronJobInfo query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetricString(result, "namespace", "cronjob", "CronJob")
+		}
+	})
 
-	query = `kube_cronjob_next_schedule_time`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=cronJobNextScheduleTime query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=cronJobNextScheduleTime query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "cronjob", "nextScheduleTime", "CronJob")
-	}
+	queries.Add(`kube_cronjob_next_schedule_time`, "cronJobNextScheduleTime", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=cronJobNextScheduleTime query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=cronJobNextScheduleTime query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "cronjob", "nextScheduleTime", "CronJob")
+		}
+	})
 
-	query = `kube_cronjob_status_last_schedule_time`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=cronJobStatusLastScheduleTime query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=cronJobStatusLastScheduleTime query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "cronjob", "lastScheduleTime", "CronJob")
-	}
+	queries.Add(`kube_cronjob_status_last_schedule_time`, "cronJobStatusLastScheduleTime", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=cronJobStatusLastScheduleTime query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=cronJobStatusLastScheduleTime query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "cronjob", "lastScheduleTime", "CronJob")
+		}
+	})
 
-	query = `kube_cronjob_status_active`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=cronJobStatusActive query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=cronJobStatusActive query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "cronjob", "statusActive", "CronJob")
-	}
+	queries.Add(`kube_cronjob_status_active`, "cronJobStatusActive", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=cronJobStatusActive query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=cronJobStatusActive query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "cronjob", "statusActive", "CronJob")
+		}
+	})
 
-	query = `kube_cronjob_created`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=cronJobCreated query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=cronJobCreated query=" + query + " message=" + err.Error())
-	} else {
-		getMidMetric(result, "namespace", "cronjob", "creationTime", "CronJob")
-	}
+	queries.Add(`kube_cronjob_created`, "cronJobCreated", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=cronJobCreated query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=cronJobCreated query=" + query + " message=" + err.Error())
+		} else {
+			getMidMetric(result, "namespace", "cronjob", "creationTime", "CronJob")
+		}
+	})
 
 	//HPA metrics
 	if args.Debug {
@@ -654,27 +671,25 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
-	query = `kube_hpa_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-
-	var hpaName string
-	var hpaLabel model.LabelName
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		hpaName = "horizontalpodautoscaler"
-		hpaLabel = "horizontalpodautoscaler"
-		query = `kube_` + hpaName + `_labels`
-		result, err = common.MetricCollect(args, query, range5Min)
-	} else {
+	//Newer versions of kube-state-metrics name the HPA metrics horizontalpodautoscaler instead of hpa, they are used unless
+	//Prometheus is known to have the older ones.
+	hasHPA := common.FamilyAvailable(args, "the HPA metrics", "kube_hpa_labels", "kube_horizontalpodautoscaler_labels")
+	hpaName := "horizontalpodautoscaler"
+	if common.Discovered(args, "kube_hpa_labels") && common.Available(args, "kube_hpa_labels") {
 		hpaName = "hpa"
-		hpaLabel = "hpa"
 	}
-
-	if err != nil {
-		args.WarnLogger.Println("metric=hpaLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=hpaLabels query=" + query + " message=" + err.Error())
-	} else {
-		getHPAMetricString(result, "namespace", hpaLabel, args)
+	hpaLabel := model.LabelName(hpaName)
+	if hasHPA {
+		queries.Add(`kube_`+hpaName+`_labels`, "hpaLabels", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=hpaLabels query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=hpaLabels query=" + query + " message=" + err.Error())
+			} else {
+				getHPAMetricString(result, "namespace", hpaLabel, args)
+			}
+		})
 	}
+	queries.Run()
 
 	//Current size workloads
 	if args.Debug {
@@ -692,79 +707,86 @@ func Metrics(args *common.Parameters) {
 		hf, _ := common.GetCsvHeaderFormat(entityKind)
 		fmt.Fprintf(currentSizeWrite, hf, "CurrentSize")
 
-		query = `kube_replicaset_spec_replicas`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "replicaset", "currentSize", "ReplicaSet")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "replicaset", args, "ReplicaSet")
-		}
+		//The current sizes are workloads, over the whole range.
+		queries = common.NewQueries(ctx, args, range5Min, entityKind)
+		queries.Add(`kube_replicaset_spec_replicas`, "replicaSetSpecReplicas", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "replicaset", "currentSize", "ReplicaSet")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "replicaset", args, "ReplicaSet")
+			}
+		})
 
-		query = `kube_replicationcontroller_spec_replicas`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=replicationcontroller_spec_replicas query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=replicationcontroller_spec_replicas query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "replicationcontroller", "currentSize", "ReplicationController")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "replicationcontroller", args, "ReplicationController")
-		}
+		queries.Add(`kube_replicationcontroller_spec_replicas`, "replicationcontroller_spec_replicas", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=replicationcontroller_spec_replicas query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=replicationcontroller_spec_replicas query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "replicationcontroller", "currentSize", "ReplicationController")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "replicationcontroller", args, "ReplicationController")
+			}
+		})
 
-		query = `kube_daemonset_status_number_available`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=daemonSetStatusNumberAvailable query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=daemonSetStatusNumberAvailable query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "daemonset", "currentSize", "DaemonSet")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "daemonset", args, "DaemonSet")
-		}
+		queries.Add(`kube_daemonset_status_number_available`, "daemonSetStatusNumberAvailable", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=daemonSetStatusNumberAvailable query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=daemonSetStatusNumberAvailable query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "daemonset", "currentSize", "DaemonSet")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "daemonset", args, "DaemonSet")
+			}
+		})
 
-		query = `kube_statefulset_replicas`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=statefulSetReplicas query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statefulSetReplicas query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "statefulset", "currentSize", "StatefulSet")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "statefulset", args, "StatefulSet")
-		}
+		queries.Add(`kube_statefulset_replicas`, "statefulSetReplicas", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=statefulSetReplicas query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=statefulSetReplicas query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "statefulset", "currentSize", "StatefulSet")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "statefulset", args, "StatefulSet")
+			}
+		})
 
-		query = `kube_job_spec_parallelism`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=jobSpecParallelism query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=jobSpecParallelism query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "job_name", "currentSize", "Job")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "job_name", args, "Job")
-		}
+		queries.Add(`kube_job_spec_parallelism`, "jobSpecParallelism", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=jobSpecParallelism query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=jobSpecParallelism query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "job_name", "currentSize", "Job")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "job_name", args, "Job")
+			}
+		})
 
-		query = `max(max(kube_job_spec_parallelism) by (namespace,job_name) * on (namespace,job_name) group_right max(kube_job_owner) by (namespace, job_name, owner_name)) by (owner_name, namespace)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=cronJobSpecParallelism query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cronJobSpecParallelism query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "owner_name", "currentSize", "CronJob")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "owner_name", args, "CronJob")
-		}
+		queries.Add(`max(max(kube_job_spec_parallelism) by (namespace,job_name) * on (namespace,job_name) group_right max(kube_job_owner) by (namespace, job_name, owner_name)) by (owner_name, namespace)`, "cronJobSpecParallelism", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=cronJobSpecParallelism query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=cronJobSpecParallelism query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "owner_name", "currentSize", "CronJob")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "owner_name", args, "CronJob")
+			}
+		})
 
-		query = `max(max(kube_replicaset_spec_replicas) by (namespace,replicaset) * on (namespace,replicaset) group_right max(kube_replicaset_owner) by (namespace, replicaset, owner_name)) by (owner_name, namespace)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
-		} else {
-			getMidMetric(result, "namespace", "owner_name", "currentSize", "Deployment")
-			writeWorkloadMid(currentSizeWrite, result, "namespace", "owner_name", args, "Deployment")
-		}
+		queries.Add(`max(max(kube_replicaset_spec_replicas) by (namespace,replicaset) * on (namespace,replicaset) group_right max(kube_replicaset_owner) by (namespace, replicaset, owner_name)) by (owner_name, namespace)`, "replicaSetSpecReplicas", func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=replicaSetSpecReplicas query=" + query + " message=" + err.Error())
+			} else {
+				getMidMetric(result, "namespace", "owner_name", "currentSize", "Deployment")
+				writeWorkloadMid(currentSizeWrite, result, "namespace", "owner_name", args, "Deployment")
+			}
+		})
 
+		queries.Run()
 		currentSizeWrite.Close()
 	}
 
+	//The run is being stopped, do not write the files out of partially collected data.
+	if ctx.Err() != nil {
+		return
+	}
 	writeAttributes(args)
 	writeConfig(args)
 
@@ -783,46 +805,72 @@ func Metrics(args *common.Parameters) {
 		args.DebugLogger.Printf("Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 		fmt.Printf("[DEBUG] Alloc = %v MiB\tTotalAlloc = %v MiB\tSys = %v MiB\tNumGC = %v\n", mem.Alloc/1024/1024, mem.TotalAlloc/1024/1024, mem.Sys/1024/1024, mem.NumGC)
 	}
+	//Workloads
+	workloads := common.NewPool(args)
+	addWorkload := func(fileName, metricName, query, aggregator string) {
+		workloads.Go(func() { getWorkload(ctx, fileName, metricName, query, aggregator, args) })
+	}
+	addHPAWorkload := func(fileName, metricName, query string) {
+		workloads.Go(func() { getHPAWorkload(ctx, fileName, metricName, query, args, hpaLabel) })
+	}
+	//With remote read, the gauges are computed from their raw samples instead, falling back to the range queries if the read fails.
+	o := &owners{pods: podOwners, podKinds: podOwnersKind, replicaSets: replicaSetOwners, jobs: jobOwners}
+	addGaugeWorkload := func(fileName, metricName, query, aggregator, metric string, scale float64) {
+		if !args.RemoteRead {
+			addWorkload(fileName, metricName, query, aggregator)
+			return
+		}
+		workloads.Go(func() {
+			if err := getRawWorkload(ctx, fileName, metricName, metric, scale, aggregator, args, o); err != nil {
+				args.WarnLogger.Println("entity=" + entityKind + " metric=" + metricName + " message=remote read failed, using range queries: " + err.Error())
+				fmt.Println("[WARNING] entity=" + entityKind + " metric=" + metricName + " message=remote read failed, using range queries: " + err.Error())
+				getWorkload(ctx, fileName, metricName, query, aggregator, args)
+			}
+		})
+	}
 	query = queryPrefix + `round(max(irate(container_cpu_usage_seconds_total{name!~"k8s_POD_.*"}[` + args.SampleRateString + `m])) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)*1000,1)` + querySuffix
-	getWorkload("cpu_mCores_workload", "MaxCpuMcores", query, "max", args)
-	getWorkload("cpu_mCores_workload", "AvgCpuMcores", query, "avg", args)
+	addWorkload("cpu_mCores_workload", "MaxCpuMcores", query, "max")
+	addWorkload("cpu_mCores_workload", "AvgCpuMcores", query, "avg")
 
 	query = queryPrefix + `max(container_memory_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)` + querySuffix
-	getWorkload("mem_workload", "MaxMem", query, "max", args)
+	addGaugeWorkload("mem_workload", "MaxMem", query, "max", "container_memory_usage_bytes", 1)
 	query = queryPrefix + `max(container_memory_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `) / (1024 * 1024)` + querySuffix
-	getWorkload("mem_workload", "AvgMem", query, "avg", args)
+	addGaugeWorkload("mem_workload", "AvgMem", query, "avg", "container_memory_usage_bytes", 1.0/(1024*1024))
 
 	query = queryPrefix + `max(container_memory_rss{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)` + querySuffix
-	getWorkload("rss_workload", "MaxRss", query, "max", args)
+	addGaugeWorkload("rss_workload", "MaxRss", query, "max", "container_memory_rss", 1)
 	query = queryPrefix + `max(container_memory_rss{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `) / (1024 * 1024)` + querySuffix
-	getWorkload("rss_workload", "AvgRss", query, "avg", args)
+	addGaugeWorkload("rss_workload", "AvgRss", query, "avg", "container_memory_rss", 1.0/(1024*1024))
 
 	query = queryPrefix + `max(container_fs_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)` + querySuffix
-	getWorkload("disk_workload", "MaxDisk", query, "max", args)
-	getWorkload("disk_workload", "AvgDisk", query, "avg", args)
+	addGaugeWorkload("disk_workload", "MaxDisk", query, "max", "container_fs_usage_bytes", 1)
+	addGaugeWorkload("disk_workload", "AvgDisk", query, "avg", "container_fs_usage_bytes", 1)
 
 	if args.LabelSuffix != "" {
 		queryPrefix = `label_replace(`
 		querySuffix = `, "container_name", "$1", "container", "(.*)")`
 	}
 	query = queryPrefix + `max(round(increase(kube_pod_container_status_restarts_total{name!~"k8s_POD_.*"}[` + args.SampleRateString + `m]),1)) by (instance,pod,namespace,container)` + querySuffix
-	getWorkload("restarts", "MaxRestarts", query, "max", args)
+	addWorkload("restarts", "MaxRestarts", query, "max")
 
-	if args.LabelSuffix == "" {
-		query = `kube_` + hpaName + `_status_condition{status="true",condition="ScalingLimited"}`
-	} else {
-		query = `kube_` + hpaName + `_status_condition{status="ScalingLimited",condition="true"}`
-	}
-	getHPAWorkload("condition_scaling_limited", "HpaConditionScalingLimited", query, args, hpaLabel)
+	if hasHPA {
+		if args.LabelSuffix == "" {
+			query = `kube_` + hpaName + `_status_condition{status="true",condition="ScalingLimited"}`
+		} else {
+			query = `kube_` + hpaName + `_status_condition{status="ScalingLimited",condition="true"}`
+		}
+		addHPAWorkload("condition_scaling_limited", "HpaConditionScalingLimited", query)
 
-	//HPA workloads
-	query = `kube_` + hpaName + `_spec_max_replicas`
-	getHPAWorkload("max_replicas", "HpaMaxReplicas", query, args, hpaLabel)
+		//HPA workloads
+		query = `kube_` + hpaName + `_spec_max_replicas`
+		addHPAWorkload("max_replicas", "HpaMaxReplicas", query)
 
-	query = `kube_` + hpaName + `_spec_min_replicas`
-	getHPAWorkload("min_replicas", "HpaMinReplicas", query, args, hpaLabel)
+		query = `kube_` + hpaName + `_spec_min_replicas`
+		addHPAWorkload("min_replicas", "HpaMinReplicas", query)
 
-	query = `kube_` + hpaName + `_status_current_replicas`
-	getHPAWorkload("current_replicas", "HpaCurrentReplicas", query, args, hpaLabel)
+		query = `kube_` + hpaName + `_status_current_replicas`
+		addHPAWorkload("current_replicas", "HpaCurrentReplicas", query)
+	}
 
+	workloads.Wait()
 }
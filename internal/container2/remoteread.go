@@ -0,0 +1,223 @@
+package container2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/remoteread"
+	"github.com/prometheus/common/model"
+)
+
+// lookbackDelta is how far back PromQL looks for the latest sample of a series at each step, the default of Prometheus.
+const lookbackDelta = 5 * time.Minute
+
+// owners holds the owners of the pods, replica sets and jobs, keyed by name__namespace, as read from kube-state-metrics.
+type owners struct {
+	pods, podKinds, replicaSets, jobs map[string]string
+}
+
+// Levels of the owners the containers are reported under, in the order getWorkload queries and writes them.
+const (
+	levelPod = iota
+	levelController
+	levelDeployment
+	levelCronJob
+)
+
+// ownersOf returns the owners the containers of the pod are reported under by the range queries of getWorkload: the pod if it
+// has no owner, its controller otherwise, and the deployment owning its replica set and the cron job owning its job, if the
+// workloads of deployments and cron jobs are collected (args.Deployments and args.CronJobs).
+func (o *owners) ownersOf(args *common.Parameters, namespace, pod string) []ownerKey {
+	controller, ok := o.pods[pod+"__"+namespace]
+	if !ok {
+		return []ownerKey{{level: levelPod, namespace: namespace, kind: "Pod", name: pod}}
+	}
+	kind := o.podKinds[pod+"__"+namespace]
+	keys := []ownerKey{{level: levelController, namespace: namespace, kind: kind, name: controller}}
+	if deployment, ok := o.replicaSets[controller+"__"+namespace]; ok && kind == "ReplicaSet" && args.Deployments {
+		keys = append(keys, ownerKey{level: levelDeployment, namespace: namespace, kind: "Deployment", name: deployment})
+	}
+	if cronJob, ok := o.jobs[controller+"__"+namespace]; ok && kind == "Job" && args.CronJobs {
+		keys = append(keys, ownerKey{level: levelCronJob, namespace: namespace, kind: "CronJob", name: cronJob})
+	}
+	return keys
+}
+
+// rawKey identifies a container, the way the range queries group the series before joining them with their owners.
+type rawKey struct {
+	instance, namespace, pod, container string
+}
+
+// ownerKey identifies a container of an owner, at one of the levels of the owners.
+type ownerKey struct {
+	level                            int
+	namespace, kind, name, container string
+}
+
+// getRawWorkload is the remote-read counterpart of getWorkload for the gauges of cAdvisor: the raw samples of the metric are read
+// and the workload is computed in the forwarder. The value of every container at each step is the latest sample within the
+// lookback delta (the maximum over its series), multiplied by scale, then the containers of each owner are aggregated with the
+// aggregator (max or avg), like the range queries do, and written for the same owners and in the same order: see ownersOf. It
+// fails, without writing the workload file, if any read fails.
+func getRawWorkload(ctx context.Context, fileName, metricName, metric string, scale float64, aggregator string, args *common.Parameters, o *owners) error {
+	type interval struct {
+		steps  []int64
+		values map[ownerKey][]float64
+	}
+	var intervals []interval
+	for historyInterval := time.Duration(0); int(historyInterval) < *args.History; historyInterval++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		range5Min := common.TimeRange(args, historyInterval)
+		var steps []int64
+		for t := range5Min.Start; !t.After(range5Min.End); t = t.Add(range5Min.Step) {
+			steps = append(steps, t.UnixMilli())
+		}
+		q := remoteread.Query{
+			Start: range5Min.Start.Add(-lookbackDelta),
+			End:   range5Min.End,
+			Step:  range5Min.Step,
+			Matchers: []remoteread.Matcher{
+				{Type: remoteread.MatchEqual, Name: "__name__", Value: metric},
+				{Type: remoteread.MatchNotRegexp, Name: "name", Value: "k8s_POD_.*"},
+			},
+		}
+		containers := map[rawKey][]float64{}
+		err := common.RemoteRead(ctx, args, q, entityKind, metricName, func(s *remoteread.Series) error {
+			key := rawKey{instance: s.Get("instance"), namespace: s.Get("namespace"), pod: s.Get("pod" + args.LabelSuffix), container: s.Get("container" + args.LabelSuffix)}
+			if _, ok := systems[key.namespace]; !ok || key.pod == "" {
+				return nil
+			}
+			values, ok := containers[key]
+			if !ok {
+				values = make([]float64, len(steps))
+				for i := range values {
+					values[i] = math.NaN()
+				}
+				containers[key] = values
+			}
+			for i, v := range valuesAt(s.Samples, steps) {
+				if !math.IsNaN(v) && (math.IsNaN(values[i]) || v > values[i]) {
+					values[i] = v
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		intervals = append(intervals, interval{steps: steps, values: aggregate(args, containers, o, aggregator, scale, len(steps))})
+	}
+
+	//Open the files that will be used for the workload data types and write out there headers.
+	workloadWrite, err := os.Create("./data/container/" + aggregator + `_` + fileName + ".csv")
+	if err != nil {
+		args.ErrorLogger.Println("entity=" + entityKind + " metric=" + metricName + " message=" + err.Error())
+		fmt.Println("[ERROR] entity=" + entityKind + " metric=" + metricName + " message=" + err.Error())
+		return nil
+	}
+	defer workloadWrite.Close()
+	fmt.Fprintf(workloadWrite, "ClusterName,Namespace,EntityName,EntityType,ContainerName,MetricTime,%s\n", metricName)
+	for _, in := range intervals {
+		keys := make([]ownerKey, 0, len(in.values))
+		for key := range in.values {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			if a.level != b.level {
+				return a.level < b.level
+			}
+			if a.namespace != b.namespace {
+				return a.namespace < b.namespace
+			}
+			if a.kind != b.kind {
+				return a.kind < b.kind
+			}
+			if a.name != b.name {
+				return a.name < b.name
+			}
+			return a.container < b.container
+		})
+		for _, key := range keys {
+			mid, ok := systems[key.namespace].midLevels[key.kind+"__"+key.name]
+			if !ok {
+				continue
+			}
+			if _, ok := mid.containers[key.container]; !ok {
+				continue
+			}
+			for i, v := range in.values[key] {
+				if !math.IsNaN(v) {
+					fmt.Fprintf(workloadWrite, "%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, key.namespace, mid.name, mid.kind, strings.Replace(key.container, ":", ".", -1), common.FormatTime(model.Time(in.steps[i])), v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// valuesAt returns the value of the series at each step: its latest sample within the lookback delta, whose start is excluded
+// as in PromQL, NaN if there is none or if the series is stale.
+func valuesAt(samples []remoteread.Sample, steps []int64) []float64 {
+	values := make([]float64, len(steps))
+	j := -1
+	for i, t := range steps {
+		for j+1 < len(samples) && samples[j+1].T <= t {
+			j++
+		}
+		if j < 0 || samples[j].T <= t-lookbackDelta.Milliseconds() || remoteread.IsStale(samples[j].V) {
+			values[i] = math.NaN()
+		} else {
+			values[i] = samples[j].V
+		}
+	}
+	return values
+}
+
+// aggregate groups the containers by owner and aggregates their values at each step.
+func aggregate(args *common.Parameters, containers map[rawKey][]float64, o *owners, aggregator string, scale float64, steps int) map[ownerKey][]float64 {
+	values := map[ownerKey][]float64{}
+	counts := map[ownerKey][]int{}
+	for key, cv := range containers {
+		for _, owner := range o.ownersOf(args, key.namespace, key.pod) {
+			owner.container = key.container
+			if _, found := values[owner]; !found {
+				values[owner] = make([]float64, steps)
+				counts[owner] = make([]int, steps)
+			}
+			for i, v := range cv {
+				if math.IsNaN(v) {
+					continue
+				}
+				v *= scale
+				switch {
+				case counts[owner][i] == 0:
+					values[owner][i] = v
+				case aggregator == "avg":
+					values[owner][i] += v
+				case v > values[owner][i]:
+					values[owner][i] = v
+				}
+				counts[owner][i]++
+			}
+		}
+	}
+	for key := range values {
+		for i, n := range counts[key] {
+			if n == 0 {
+				values[key][i] = math.NaN()
+			} else if aggregator == "avg" {
+				values[key][i] /= float64(n)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,336 @@
+package container2
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/remoteread"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testPod is a pod of the test cluster with its owners, as kube-state-metrics reports them.
+type testPod struct {
+	name, kind, controller, top string
+	containers                  []string
+}
+
+var testPods = []testPod{
+	{name: "solo", containers: []string{"app"}},
+	{name: "web-1", kind: "ReplicaSet", controller: "web-abc", top: "web", containers: []string{"app", "sidecar"}},
+	{name: "web-2", kind: "ReplicaSet", controller: "web-abc", top: "web", containers: []string{"app", "sidecar"}},
+	{name: "lone-1", kind: "ReplicaSet", controller: "lone", containers: []string{"app"}},
+	{name: "agent-x", kind: "DaemonSet", controller: "agent", containers: []string{"app"}},
+	{name: "agent-y", kind: "DaemonSet", controller: "agent", containers: []string{"app"}},
+	{name: "backup-1", kind: "Job", controller: "backup-123", top: "backup", containers: []string{"app"}},
+}
+
+// testValue is the memory of the container of the pod at the time, in milliseconds, an integer so its averages are exact.
+func testValue(pod, container int, t int64) float64 {
+	return float64(1000*pod + 100*container + int(t/60000%50))
+}
+
+// testOwners builds the hierarchy of the test cluster: the owners read from kube-state-metrics and the top owners of the containers.
+func testOwners() *owners {
+	o := &owners{pods: map[string]string{}, podKinds: map[string]string{}, replicaSets: map[string]string{}, jobs: map[string]string{}}
+	systems = map[string]*namespace{"default": {midLevels: map[string]*midLevel{}, pointers: map[string]*midLevel{}}}
+	for _, p := range testPods {
+		kind, name := "Pod", p.name
+		if p.kind != "" {
+			o.pods[p.name+"__default"], o.podKinds[p.name+"__default"] = p.controller, p.kind
+			kind, name = p.kind, p.controller
+		}
+		switch {
+		case p.top != "" && p.kind == "ReplicaSet":
+			o.replicaSets[p.controller+"__default"] = p.top
+			kind, name = "Deployment", p.top
+		case p.top != "" && p.kind == "Job":
+			o.jobs[p.controller+"__default"] = p.top
+			kind, name = "CronJob", p.top
+		}
+		mid, ok := systems["default"].midLevels[kind+"__"+name]
+		if !ok {
+			mid = &midLevel{name: name, kind: kind, containers: map[string]*container{}}
+			systems["default"].midLevels[kind+"__"+name] = mid
+		}
+		for _, c := range p.containers {
+			mid.containers[c] = &container{name: c}
+		}
+	}
+	return o
+}
+
+// encodeReadResponse encodes the series as a ReadResponse message of raw samples, the response of remote-read servers which
+// do not stream XOR chunks.
+func encodeReadResponse(series []remoteread.Series) []byte {
+	var result []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, sample := range s.Samples {
+			var b []byte
+			b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(sample.V))
+			b = protowire.AppendTag(b, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(sample.T))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, b)
+		}
+		result = protowire.AppendTag(result, 1, protowire.BytesType)
+		result = protowire.AppendBytes(result, ts)
+	}
+	var resp []byte
+	resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+	return protowire.AppendBytes(resp, result)
+}
+
+// newTestPrometheus serves the raw samples of the test containers, a sample a minute, over remote read, and answers the range
+// queries of getWorkload with the workloads of the owners they group the containers by, computed from the same samples.
+func newTestPrometheus(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == remoteread.Path {
+			body, _ := io.ReadAll(r.Body)
+			if _, err := snappy.Decode(nil, body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var series []remoteread.Series
+			for i, p := range testPods {
+				for j, c := range p.containers {
+					s := remoteread.Series{Labels: []remoteread.Label{{Name: "__name__", Value: "container_memory_usage_bytes"}, {Name: "container", Value: c}, {Name: "instance", Value: "node-" + strconv.Itoa(i%2)}, {Name: "namespace", Value: "default"}, {Name: "pod", Value: p.name}}}
+					for ts := int64(1704153600000); ts <= 1704164400000; ts += 60000 {
+						s.Samples = append(s.Samples, remoteread.Sample{T: ts, V: testValue(i, j, ts)})
+					}
+					series = append(series, s)
+				}
+			}
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.Write(snappy.Encode(nil, encodeReadResponse(series)))
+			return
+		}
+
+		r.ParseForm()
+		query := r.Form.Get("query")
+		start, _ := strconv.ParseFloat(r.Form.Get("start"), 64)
+		end, _ := strconv.ParseFloat(r.Form.Get("end"), 64)
+		step, _ := strconv.ParseFloat(r.Form.Get("step"), 64)
+		scale := 1.0
+		if strings.Contains(query, "/ (1024 * 1024)") {
+			scale = 1.0 / (1024 * 1024)
+		}
+		// the labels each query groups the containers by, empty for the pods it leaves out
+		group := func(p testPod) map[string]string {
+			switch {
+			case strings.Contains(query, `owner_name="<none>"`):
+				if p.kind == "" {
+					return map[string]string{"namespace": "default", "pod": p.name}
+				}
+			case strings.Contains(query, "kube_replicaset_owner"):
+				if p.kind == "ReplicaSet" && p.top != "" {
+					return map[string]string{"namespace": "default", "owner_name": p.top}
+				}
+			case strings.Contains(query, "kube_job_owner"):
+				if p.kind == "Job" && p.top != "" {
+					return map[string]string{"namespace": "default", "owner_name": p.top}
+				}
+			case p.kind != "":
+				return map[string]string{"namespace": "default", "owner_kind": p.kind, "owner_name": p.controller}
+			}
+			return nil
+		}
+		type series struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+			sums   []float64
+			counts []int
+			max    []float64
+		}
+		grouped := map[string]*series{}
+		var keys []string
+		for i, p := range testPods {
+			labels := group(p)
+			if labels == nil {
+				continue
+			}
+			for j, c := range p.containers {
+				metric := map[string]string{"container": c}
+				for k, v := range labels {
+					metric[k] = v
+				}
+				b, _ := json.Marshal(metric)
+				s, ok := grouped[string(b)]
+				if !ok {
+					s = &series{Metric: metric}
+					grouped[string(b)] = s
+					keys = append(keys, string(b))
+				}
+				for k, ts := 0, start; ts <= end; k, ts = k+1, ts+step {
+					if k == len(s.sums) {
+						s.sums, s.counts, s.max = append(s.sums, 0), append(s.counts, 0), append(s.max, math.Inf(-1))
+					}
+					v := testValue(i, j, int64(ts)*1000) * scale
+					s.sums[k] += v
+					s.counts[k]++
+					s.max[k] = math.Max(s.max[k], v)
+				}
+			}
+		}
+		sort.Strings(keys)
+		result := []*series{}
+		for _, key := range keys {
+			s := grouped[key]
+			for k := range s.sums {
+				v := s.max[k]
+				if strings.HasPrefix(query, "avg(") {
+					v = s.sums[k] / float64(s.counts[k])
+				}
+				s.Values = append(s.Values, [2]interface{}{start + float64(k)*step, strconv.FormatFloat(v, 'f', -1, 64)})
+			}
+			result = append(result, s)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": map[string]interface{}{"resultType": "matrix", "result": result}})
+	}))
+}
+
+// readWorkload returns the header and the sorted rows of the workload file.
+func readWorkload(t *testing.T, file string) (string, []string) {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	rows := lines[1:]
+	sort.Strings(rows)
+	return lines[0], rows
+}
+
+func TestRawWorkload(t *testing.T) {
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.MkdirAll(dir+"/data/container", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func() { systems = map[string]*namespace{} }()
+
+	srv := newTestPrometheus(t)
+	defer srv.Close()
+	interval, clusterName, promURL := "hours", "test", srv.URL
+	intervalSize, history, offset := 1, 1, 0
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	logger := log.New(io.Discard, "", 0)
+	args := &common.Parameters{
+		ClusterName: &clusterName, PromURL: &promURL, PromAddress: &clusterName, Interval: &interval, IntervalSize: &intervalSize,
+		History: &history, Offset: &offset, CurrentTime: &now, SampleRate: 5,
+		InfoLogger: logger, WarnLogger: logger, ErrorLogger: logger, DebugLogger: logger,
+	}
+	if err := common.InitPromApi(args); err != nil {
+		t.Fatal(err)
+	}
+	o := testOwners()
+
+	tests := []struct {
+		metricName, aggregator, query string
+		scale                         float64
+		deployments, cronJobs         bool
+		owners                        []string
+		containers                    int
+	}{
+		{"MaxMem", "max", `max(container_memory_usage_bytes) by (instance,pod,namespace,container)`, 1, true, true,
+			[]string{"CronJob backup", "DaemonSet agent", "Deployment web", "Pod solo", "ReplicaSet lone"}, 6},
+		{"AvgMem", "avg", `max(container_memory_usage_bytes) by (instance,pod,namespace,container) / (1024 * 1024)`, 1.0 / (1024 * 1024), true, true,
+			[]string{"CronJob backup", "DaemonSet agent", "Deployment web", "Pod solo", "ReplicaSet lone"}, 6},
+		// without the workloads of deployments and cron jobs, their containers are not reported
+		{"MaxMem", "max", `max(container_memory_usage_bytes) by (instance,pod,namespace,container)`, 1, false, false,
+			[]string{"DaemonSet agent", "Pod solo", "ReplicaSet lone"}, 3},
+	}
+	for _, test := range tests {
+		args.Deployments, args.CronJobs = test.deployments, test.cronJobs
+		getWorkload(context.Background(), "range", test.metricName, test.query, test.aggregator, args)
+		if err := getRawWorkload(context.Background(), "raw", test.metricName, "container_memory_usage_bytes", test.scale, test.aggregator, args, o); err != nil {
+			t.Fatal(err)
+		}
+		rangeHeader, rangeRows := readWorkload(t, "data/container/"+test.aggregator+"_range.csv")
+		rawHeader, rawRows := readWorkload(t, "data/container/"+test.aggregator+"_raw.csv")
+		if rawHeader != rangeHeader {
+			t.Errorf("%s: raw header %q, range header %q", test.metricName, rawHeader, rangeHeader)
+		}
+		if !reflect.DeepEqual(rawRows, rangeRows) {
+			t.Errorf("%s: %d raw rows differ from the %d range rows:\n%s\n---\n%s", test.metricName, len(rawRows), len(rangeRows), strings.Join(rawRows, "\n"), strings.Join(rangeRows, "\n"))
+		}
+		seen := map[string]bool{}
+		for _, row := range rawRows {
+			fields := strings.Split(row, ",")
+			seen[fields[3]+" "+fields[2]] = true
+		}
+		var owners []string
+		for owner := range seen {
+			owners = append(owners, owner)
+		}
+		sort.Strings(owners)
+		if !reflect.DeepEqual(owners, test.owners) {
+			t.Errorf("%s: rows of %v, want %v", test.metricName, owners, test.owners)
+		}
+		// the 13 steps of the hour of each container of each owner
+		if n := 13 * test.containers; len(rawRows) != n {
+			t.Errorf("%s: %d rows, want %d", test.metricName, len(rawRows), n)
+		}
+	}
+}
+
+func TestValuesAt(t *testing.T) {
+	stale := math.Float64frombits(0x7ff0000000000002)
+	delta := lookbackDelta.Milliseconds()
+	samples := []remoteread.Sample{{T: 1000, V: 1}, {T: 2000, V: 2}, {T: 3000, V: stale}, {T: 4000, V: 4}}
+	tests := []struct {
+		step int64
+		want float64
+	}{
+		{999, math.NaN()},
+		{1000, 1},
+		{1999, 1},
+		{2000, 2},
+		{3000, math.NaN()},
+		{3999, math.NaN()},
+		{4000, 4},
+		// the sample exactly at the start of the lookback is not used, as in PromQL
+		{4000 + delta - 1, 4},
+		{4000 + delta, math.NaN()},
+	}
+	var steps []int64
+	for _, test := range tests {
+		steps = append(steps, test.step)
+	}
+	values := valuesAt(samples, steps)
+	for i, test := range tests {
+		if v := values[i]; v != test.want && !(math.IsNaN(v) && math.IsNaN(test.want)) {
+			t.Errorf("value at %d = %v, want %v", test.step, v, test.want)
+		}
+	}
+}
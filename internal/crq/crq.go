@@ -1,6 +1,7 @@
 package crq
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -244,7 +245,7 @@ func writeAttributes(args *common.Parameters) {
 }
 
 // Metrics a global func for collecting quota level metrics in prometheus
-func Metrics(args *common.Parameters) {
+func Metrics(ctx context.Context, args *common.Parameters) {
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -254,9 +255,14 @@ func Metrics(args *common.Parameters) {
 
 	//Start and end time + the prometheus address used for querying
 	range5Min := common.TimeRange(args, historyInterval)
+	configRange := common.ConfigRange(args)
 
+	//Cluster resource quotas are only found on OpenShift.
+	if !common.FamilyAvailable(args, "the cluster resource quotas of OpenShift", "openshift_clusterresourcequota_created") {
+		return
+	}
 	query = `max(openshift_clusterresourcequota_created) by (namespace,name)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "clusterResourceQuotas")
 
 	if err != nil {
 		args.WarnLogger.Println("metric=clusterResourceQuotas query=" + query + " message=" + err.Error())
@@ -280,60 +286,71 @@ func Metrics(args *common.Parameters) {
 			}
 	}
 
-	query = `max(openshift_clusterresourcequota_selector) by (name, key, type, value)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=openshift_clusterresourcequota_selector query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=openshift_clusterresourcequota_selector query=" + query + " message=" + err.Error())
-	} else {
-		extractCRQAttributes(result)
-	}
+	//Attribute queries
+	queries := common.NewQueries(ctx, args, configRange, entityKind)
+	queries.Add(`max(openshift_clusterresourcequota_selector) by (name, key, type, value)`, "openshift_clusterresourcequota_selector", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=openshift_clusterresourcequota_selector query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=openshift_clusterresourcequota_selector query=" + query + " message=" + err.Error())
+		} else {
+			extractCRQAttributes(result)
+		}
+	})
 
-	query = `openshift_clusterresourcequota_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=openshift_clusterresourcequota_labels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=openshift_clusterresourcequota_labels query=" + query + " message=" + err.Error())
-	} else {
-		populateLabelMap(result, "name")
-	}
+	queries.Add(`openshift_clusterresourcequota_labels`, "openshift_clusterresourcequota_labels", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=openshift_clusterresourcequota_labels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=openshift_clusterresourcequota_labels query=" + query + " message=" + err.Error())
+		} else {
+			populateLabelMap(result, "name")
+		}
+	})
 
-	query = `max(openshift_clusterresourcequota_usage) by (name, resource, type)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=openshift_clusterresourcequota_usage query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=openshift_clusterresourcequota_usage query=" + query + " message=" + err.Error())
-	} else {
-		getExistingQuotas(result)
-	}
+	queries.Add(`max(openshift_clusterresourcequota_usage) by (name, resource, type)`, "openshift_clusterresourcequota_usage", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=openshift_clusterresourcequota_usage query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=openshift_clusterresourcequota_usage query=" + query + " message=" + err.Error())
+		} else {
+			getExistingQuotas(result)
+		}
+	})
 
-	query = `max(openshift_clusterresourcequota_namespace_usage) by (name, namespace)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=openshift_clusterresourcequota_namespace_usage query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=openshift_clusterresourcequota_namespace_usage query=" + query + " message=" + err.Error())
-	} else {
-		extractCRQAttributes(result)
-	}
+	queries.Add(`max(openshift_clusterresourcequota_namespace_usage) by (name, namespace)`, "openshift_clusterresourcequota_namespace_usage", func(query string, result model.Value, err error) {
+		if err != nil {
+			args.WarnLogger.Println("metric=openshift_clusterresourcequota_namespace_usage query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=openshift_clusterresourcequota_namespace_usage query=" + query + " message=" + err.Error())
+		} else {
+			extractCRQAttributes(result)
+		}
+	})
 
+	queries.Run()
+
+	//The run is being stopped, do not write the files out of partially collected data.
+	if ctx.Err() != nil {
+		return
+	}
 	writeAttributes(args)
 	writeConfig(args)
 
 	var metricField []model.LabelName
 	metricField = append(metricField, "name")
+	//Workloads
+	workloads := common.NewPool(args)
 	query = `sum(openshift_clusterresourcequota_usage{type="used", resource="limits.cpu"}) by (name) * 1000`
-	common.GetWorkload("cpu_limits", "CpuLimits", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "cpu_limits", "CpuLimits", query, metricField, args, entityKind)
 
 	query = `sum(openshift_clusterresourcequota_usage{type="used", resource=~"cpu|requests\\.cpu"}) by (name) * 1000`
-	common.GetWorkload("cpu_requests", "CpuRequests", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "cpu_requests", "CpuRequests", query, metricField, args, entityKind)
 
 	query = `sum(openshift_clusterresourcequota_usage{type="used", resource="limits.memory"}) by (name)`
-	common.GetWorkload("mem_limits", "MemLimits", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "mem_limits", "MemLimits", query, metricField, args, entityKind)
 
 	query = `sum(openshift_clusterresourcequota_usage{type="used", resource=~"memory|requests\\.memory"}) by (name) / (1024 * 1024)`
-	common.GetWorkload("mem_requests", "MemRequests", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "mem_requests", "MemRequests", query, metricField, args, entityKind)
 
 	query = `sum(openshift_clusterresourcequota_usage{type="used", resource="pods"}) by (name)`
-	common.GetWorkload("pods", "PodsLimits", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "pods", "PodsLimits", query, metricField, args, entityKind)
 
+	workloads.Wait()
 }
@@ -2,6 +2,7 @@
 package node
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -28,7 +29,7 @@ var nodes = map[string]*node{}
 var entityKind = "node"
 
 // Metrics a global func for collecting node level metrics in prometheus
-func Metrics(args *common.Parameters) {
+func Metrics(ctx context.Context, args *common.Parameters) {
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -39,10 +40,11 @@ func Metrics(args *common.Parameters) {
 
 	//Start and end time + the prometheus address used for querying
 	range5Min := common.TimeRange(args, historyInterval)
+	configRange := common.ConfigRange(args)
 
 	//Query and store kubernetes node information/labels
 	query = "max(kube_node_labels) by (instance, node)"
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "nodes")
 	if err != nil {
 		args.ErrorLogger.Println("metric=nodes query=" + query + " message=" + err.Error())
 		fmt.Println("[ERROR] metric=nodes query=" + query + " message=" + err.Error())
@@ -65,188 +67,117 @@ func Metrics(args *common.Parameters) {
 	}
 
 	//Additonal config/attribute queries
-	query = `kube_node_labels`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=nodeLabels query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=nodeLabels query=" + query + " message=" + err.Error())
-	} else {
-		getNodeMetricString(result, "node")
-	}
-
-	//Additonal config/attribute queries
-	query = `kube_node_info`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=nodeInfo query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=nodeInfo query=" + query + " message=" + err.Error())
-	} else {
-		getNodeMetricString(result, "node")
-	}
-
-	query = `kube_node_role`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=nodeInfo query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=nodeInfo query=" + query + " message=" + err.Error())
-	} else {
-		getNodeMetricString(result, "node")
-	}
-
-	//Gets the network speed in bytes as an attribute/config value for each node
-	query = `label_replace(node_network_speed_bytes, "pod_ip", "$1", "instance", "(.*):.*")`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if err != nil {
-		args.WarnLogger.Println("metric=networkSpeedBytes query=" + query + " message=" + err.Error())
-		fmt.Println("[WARNING] metric=networkSpeedBytes query=" + query + " message=" + err.Error())
-	} else {
-		if mat, ok := result.(model.Matrix); ok && mat.Len() != 0 {
-			hasNodeExporter = true
-			getNodeMetric(result, "node", "netSpeedBytes")
-		}
-	}
-
-	//Queries the capacity fields of all nodes
-	query = `kube_node_status_capacity`
-	result, err = common.MetricCollect(args, query, range5Min)
-
-	/*
-	  Some older versions of kube-state-metrics don't support kube_node_status_capacity.
-	  If this is the case then we can use the older queries, which query the individual
-	  metrics that kube_node_status_capacity returns.
-
-	  NOTE: Not all queries from kube_node_status_capacity can be found in these
-	  individual queries. If you see missing fields in the config/attribute files,
-	  that is why.
-	*/
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		//capacity_cpu_cores query
-		query = `kube_node_status_capacity_cpu_cores`
-		result, err = common.MetricCollect(args, query, range5Min)
+	queries := common.NewQueries(ctx, args, configRange, entityKind)
+	queries.Add(`kube_node_labels`, "nodeLabels", func(query string, result model.Value, err error) {
 		if err != nil {
-			args.WarnLogger.Println("metric=statusCapacityCpuCores query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statusCapacityCpuCores query=" + query + " message=" + err.Error())
+			args.WarnLogger.Println("metric=nodeLabels query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=nodeLabels query=" + query + " message=" + err.Error())
 		} else {
-			getNodeMetric(result, "node", "capacity_cpu")
+			getNodeMetricString(result, "node")
 		}
+	})
 
-		//capacity_memory_bytes query
-		query = `kube_node_status_capacity_memory_bytes`
-		result, err = common.MetricCollect(args, query, range5Min)
+	//Additonal config/attribute queries
+	queries.Add(`kube_node_info`, "nodeInfo", func(query string, result model.Value, err error) {
 		if err != nil {
-			args.WarnLogger.Println("metric=statusCapacityMemoryBytes query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statusCapacityMemoryBytes query=" + query + " message=" + err.Error())
+			args.WarnLogger.Println("metric=nodeInfo query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=nodeInfo query=" + query + " message=" + err.Error())
 		} else {
-			getNodeMetric(result, "node", "capacity_mem")
+			getNodeMetricString(result, "node")
 		}
+	})
 
-		//capacity_pods query
-		query = `kube_node_status_capacity_pods`
-		result, err = common.MetricCollect(args, query, range5Min)
+	queries.Add(`kube_node_role`, "nodeInfo", func(query string, result model.Value, err error) {
 		if err != nil {
-			args.WarnLogger.Println("metric=statusCapacityPods query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statusCapacityPods query=" + query + " message=" + err.Error())
+			args.WarnLogger.Println("metric=nodeInfo query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=nodeInfo query=" + query + " message=" + err.Error())
 		} else {
-			getNodeMetric(result, "node", "capacity_pod")
+			getNodeMetricString(result, "node")
 		}
+	})
 
-	} else {
-		getNodeMetric(result, "node", "capacity")
+	//Gets the network speed in bytes as an attribute/config value for each node
+	queries.AddIfAvailable("node_network_speed_bytes", `label_replace(node_network_speed_bytes, "pod_ip", "$1", "instance", "(.*):.*")`, "networkSpeedBytes", func(query string, result model.Value, err error) {
+		if err == common.ErrNotAvailable {
+			//Reported below, as Node Exporter is not installed
+		} else if err != nil {
+			args.WarnLogger.Println("metric=networkSpeedBytes query=" + query + " message=" + err.Error())
+			fmt.Println("[WARNING] metric=networkSpeedBytes query=" + query + " message=" + err.Error())
+		} else {
+			if mat, ok := result.(model.Matrix); ok && mat.Len() != 0 {
+				hasNodeExporter = true
+				getNodeMetric(result, "node", "netSpeedBytes")
+			}
+		}
+	})
+
+	//addNodeMetric adds the query of a node field, if Prometheus has its metric.
+	addNodeMetric := func(metric, query, metricName, field string) {
+		queries.AddIfAvailable(metric, query, metricName, func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
+			} else {
+				getNodeMetric(result, "node", field)
+			}
+		})
 	}
 
-	//Queries the allocatable metric fields of all the nodes
-	query = `kube_node_status_allocatable`
-	result, err = common.MetricCollect(args, query, range5Min)
-
 	/*
-	  Some older versions of kube-state-metrics don't support kube_node_status_allocatable.
-	  If this is the case then we can use the older queries, which query the individual
-	  metrics that kube_node_status_allocatable returns.
+	  Older versions of kube-state-metrics don't support kube_node_status_capacity and kube_node_status_allocatable,
+	  they have the individual metrics instead.
 
-	  NOTE: Not all queries from kube_node_status_allocatable can be found in these
-	  individual queries. If you see missing fields in the config/attribute files,
-	  that is why.
+	  NOTE: Not all fields of kube_node_status_capacity can be found in these individual metrics.
+	  If you see missing fields in the config/attribute files, that is why.
 	*/
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `kube_node_status_allocatable_cpu_cores`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=statusAllocatableCpuCores query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statusAllocatableCpuCores query=" + query + " message=" + err.Error())
-		} else {
-			getNodeMetric(result, "node", "allocatable_cpu")
-		}
-
-		query = `kube_node_status_allocatable_memory_bytes`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=statusAllocatableMemoryBytes query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statusAllocatableMemoryBytes query=" + query + " message=" + err.Error())
-		} else {
-			getNodeMetric(result, "node", "allocatable_mem")
-		}
-
-		query = `kube_node_status_allocatable_pods`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=statusAllocatablePods query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=statusAllocatablePods query=" + query + " message=" + err.Error())
+	//Queries the capacity fields of all nodes
+	if common.FamilyAvailable(args, "the node capacity", "kube_node_status_capacity", "kube_node_status_capacity_cpu_cores", "kube_node_status_capacity_memory_bytes", "kube_node_status_capacity_pods") {
+		if common.Available(args, "kube_node_status_capacity") {
+			addNodeMetric("kube_node_status_capacity", `kube_node_status_capacity`, "statusCapacity", "capacity")
 		} else {
-			getNodeMetric(result, "node", "allocatable_pod")
+			addNodeMetric("kube_node_status_capacity_cpu_cores", `kube_node_status_capacity_cpu_cores`, "statusCapacityCpuCores", "capacity_cpu")
+			addNodeMetric("kube_node_status_capacity_memory_bytes", `kube_node_status_capacity_memory_bytes`, "statusCapacityMemoryBytes", "capacity_mem")
+			addNodeMetric("kube_node_status_capacity_pods", `kube_node_status_capacity_pods`, "statusCapacityPods", "capacity_pod")
 		}
-
-	} else {
-		getNodeMetric(result, "node", "allocatable")
 	}
 
-	query = `sum(kube_pod_container_resource_limits) by (node, resource)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_resource_limits_cpu_cores) by (node)*1000`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=cpuLimit query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cpuLimit query=" + query + " message=" + err.Error())
-		} else {
-			getNodeMetric(result, "node", "cpuLimit")
-		}
-		query = `sum(kube_pod_container_resource_limits_memory_bytes) by (node)/1024/1024`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=memLimit query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=memLimit query=" + query + " message=" + err.Error())
+	//Queries the allocatable metric fields of all the nodes
+	if common.FamilyAvailable(args, "the node allocatable", "kube_node_status_allocatable", "kube_node_status_allocatable_cpu_cores", "kube_node_status_allocatable_memory_bytes", "kube_node_status_allocatable_pods") {
+		if common.Available(args, "kube_node_status_allocatable") {
+			addNodeMetric("kube_node_status_allocatable", `kube_node_status_allocatable`, "statusAllocatable", "allocatable")
 		} else {
-			getNodeMetric(result, "node", "memLimit")
+			addNodeMetric("kube_node_status_allocatable_cpu_cores", `kube_node_status_allocatable_cpu_cores`, "statusAllocatableCpuCores", "allocatable_cpu")
+			addNodeMetric("kube_node_status_allocatable_memory_bytes", `kube_node_status_allocatable_memory_bytes`, "statusAllocatableMemoryBytes", "allocatable_mem")
+			addNodeMetric("kube_node_status_allocatable_pods", `kube_node_status_allocatable_pods`, "statusAllocatablePods", "allocatable_pod")
 		}
-	} else {
-		getNodeMetric(result, "node", "limits")
 	}
 
-	query = `sum(kube_pod_container_resource_requests) by (node,resource)`
-	result, err = common.MetricCollect(args, query, range5Min)
-	if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-		query = `sum(kube_pod_container_resource_requests_cpu_cores) by (node)*1000`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=cpuRequest query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=cpuRequest query=" + query + " message=" + err.Error())
+	//kube-state-metrics 1.x reports the limits and requests in a metric per resource.
+	if common.FamilyAvailable(args, "the node limits", "kube_pod_container_resource_limits", "kube_pod_container_resource_limits_cpu_cores", "kube_pod_container_resource_limits_memory_bytes") {
+		if common.Available(args, "kube_pod_container_resource_limits") {
+			addNodeMetric("kube_pod_container_resource_limits", `sum(kube_pod_container_resource_limits) by (node, resource)`, "limits", "limits")
 		} else {
-			getNodeMetric(result, "node", "cpuRequest")
+			addNodeMetric("kube_pod_container_resource_limits_cpu_cores", `sum(kube_pod_container_resource_limits_cpu_cores) by (node)*1000`, "cpuLimit", "cpuLimit")
+			addNodeMetric("kube_pod_container_resource_limits_memory_bytes", `sum(kube_pod_container_resource_limits_memory_bytes) by (node)/1024/1024`, "memLimit", "memLimit")
 		}
+	}
 
-		query = `sum(kube_pod_container_resource_requests_memory_bytes) by (node)/1024/1024`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if err != nil {
-			args.WarnLogger.Println("metric=memRequest query=" + query + " message=" + err.Error())
-			fmt.Println("[WARNING] metric=memRequest query=" + query + " message=" + err.Error())
+	if common.FamilyAvailable(args, "the node requests", "kube_pod_container_resource_requests", "kube_pod_container_resource_requests_cpu_cores", "kube_pod_container_resource_requests_memory_bytes") {
+		if common.Available(args, "kube_pod_container_resource_requests") {
+			addNodeMetric("kube_pod_container_resource_requests", `sum(kube_pod_container_resource_requests) by (node,resource)`, "requests", "requests")
 		} else {
-			getNodeMetric(result, "node", "memRequest")
+			addNodeMetric("kube_pod_container_resource_requests_cpu_cores", `sum(kube_pod_container_resource_requests_cpu_cores) by (node)*1000`, "cpuRequest", "cpuRequest")
+			addNodeMetric("kube_pod_container_resource_requests_memory_bytes", `sum(kube_pod_container_resource_requests_memory_bytes) by (node)/1024/1024`, "memRequest", "memRequest")
 		}
-	} else {
-		getNodeMetric(result, "node", "requests")
 	}
 
+	queries.Run()
+
 	//Writes the config and attribute files
+	//The run is being stopped, do not write the files out of partially collected data.
+	if ctx.Err() != nil {
+		return
+	}
 	writeConfig(args)
 	writeAttributes(args)
 
@@ -258,83 +189,87 @@ func Metrics(args *common.Parameters) {
 	}
 
 	var metricField []model.LabelName
-	queryPrefix := ``
-	queryPrefixSum := `sum(`
-	querySuffix := ``
-	querySuffixSum := `) by (instance)`
+	joined := false
 	metricField = append(metricField, "instance")
 
 	//Check to see which disk queries to use if instance is IP address that need to link to pod to get name or if instance = node name.
-	query = `max(max(label_replace(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	join := nodeExporterJoin("max", `sum(irate(node_cpu_seconds_total{mode!="idle"}[`+args.SampleRateString+`m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100`)
+	result, err = common.CollectJoin(ctx, args, join, range5Min, entityKind, "nodeExporterInstance")
 
 	if mat, ok := result.(model.Matrix); err == nil && ok && mat.Len() != 0 {
-		queryPrefix = `max(max(label_replace(`
-		queryPrefixSum = `max(sum(label_replace(`
-		querySuffix = `, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
-		querySuffixSum = querySuffix
+		joined = true
 		metricField[0] = "node"
 	}
+
+	//Workloads
+	workloads := common.NewPool(args)
+	//The aggregator sums the series of an instance, or takes the max for the queries returning one.
+	getWorkload := func(fileName, metricName, aggregator, query string) {
+		if joined {
+			workloads.GetJoinWorkload(ctx, fileName, metricName, nodeExporterJoin(aggregator, query), metricField, args, entityKind)
+		} else if aggregator == "sum" {
+			workloads.GetWorkload(ctx, fileName, metricName, `sum(`+query+`) by (instance)`, metricField, args, entityKind)
+		} else {
+			workloads.GetWorkload(ctx, fileName, metricName, query, metricField, args, entityKind)
+		}
+	}
 	//Query and store prometheus total cpu uptime in seconds
-	query = queryPrefix + `sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100` + querySuffix
-	common.GetWorkload("cpu_utilization", "CpuUtilization", query, metricField, args, entityKind)
+	getWorkload("cpu_utilization", "CpuUtilization", "max", `sum(irate(node_cpu_seconds_total{mode!="idle"}[`+args.SampleRateString+`m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100`)
 
 	//Query and store prometheus node memory total in bytes
-	query = queryPrefix + `node_memory_MemTotal_bytes - node_memory_MemFree_bytes` + querySuffix
-	common.GetWorkload("memory_raw_bytes", "MemoryBytes", query, metricField, args, entityKind)
+	getWorkload("memory_raw_bytes", "MemoryBytes", "max", `node_memory_MemTotal_bytes - node_memory_MemFree_bytes`)
 
 	//Query and store prometheus node memory total free in bytes
-	query = queryPrefix + `node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes)` + querySuffix
-	common.GetWorkload("memory_actual_workload", "MemoryActualWorkload", query, metricField, args, entityKind)
+	getWorkload("memory_actual_workload", "MemoryActualWorkload", "max", `node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes)`)
 
 	//Query and store prometheus node disk write in bytes
-	query = queryPrefixSum + `irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_write_bytes", "DiskWriteBytes", query, metricField, args, entityKind)
+	getWorkload("disk_write_bytes", "DiskWriteBytes", "sum", `irate(node_disk_written_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_read_bytes", "DiskReadBytes", query, metricField, args, entityKind)
+	getWorkload("disk_read_bytes", "DiskReadBytes", "sum", `irate(node_disk_read_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_read_ops", "DiskReadOps", query, metricField, args, entityKind)
+	getWorkload("disk_read_ops", "DiskReadOps", "sum", `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total disk write uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_write_ops", "DiskWriteOps", query, metricField, args, entityKind)
+	getWorkload("disk_write_ops", "DiskWriteOps", "sum", `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Total disk values
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_total_bytes", "DiskTotalBytes", query, metricField, args, entityKind)
+	getWorkload("disk_total_bytes", "DiskTotalBytes", "sum", `irate(node_disk_read_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_total_ops", "DiskTotalOps", query, metricField, args, entityKind)
+	getWorkload("disk_total_ops", "DiskTotalOps", "sum", `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus node recieved network data in bytes
-	query = queryPrefixSum + `irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_received_bytes", "NetReceivedBytes", query, metricField, args, entityKind)
+	getWorkload("net_received_bytes", "NetReceivedBytes", "sum", `irate(node_network_receive_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus recieved network data in packets
-	query = queryPrefixSum + `irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_received_packets", "NetReceivedPackets", query, metricField, args, entityKind)
+	getWorkload("net_received_packets", "NetReceivedPackets", "sum", `irate(node_network_receive_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total transmitted network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_sent_bytes", "NetSentBytes", query, metricField, args, entityKind)
+	getWorkload("net_sent_bytes", "NetSentBytes", "sum", `irate(node_network_transmit_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total transmitted network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_sent_packets", "NetSentPackets", query, metricField, args, entityKind)
+	getWorkload("net_sent_packets", "NetSentPackets", "sum", `irate(node_network_transmit_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Total values network
 	//Query and store prometheus total network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_total_bytes", "NetTotalBytes", query, metricField, args, entityKind)
+	getWorkload("net_total_bytes", "NetTotalBytes", "sum", `irate(node_network_transmit_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_total_packets", "NetTotalPackets", query, metricField, args, entityKind)
+	getWorkload("net_total_packets", "NetTotalPackets", "sum", `irate(node_network_transmit_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
+	workloads.Wait()
+}
+
+// nodeExporterJoin returns the join naming the series of the node exporter query by node instead of by instance, through the pod
+// info of the node exporter pods.
+func nodeExporterJoin(aggregator, query string) *common.Join {
+	return &common.Join{
+		Aggregator: "max",
+		Query:      common.ByPodIP(aggregator, query),
+		Matches:    []common.Match{common.NodeExporterPods},
+		By:         []model.LabelName{"node"},
+	}
 }
@@ -2,6 +2,7 @@
 package nodegroup
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -202,8 +203,12 @@ func checkNodeGroups(nodeGroupLabels []model.LabelName, labelName model.LabelNam
 	return false
 }
 
-// getWorkload used to query for the workload data and then calls write workload
-func getWorkload(fileName, metricName, query string, nodeGroupLabels []model.LabelName, args *common.Parameters, entityKind string) {
+// getWorkload collects the workload of each node group label, replacing stringToBeReplaced in the query by the label, or
+// collecting the join returned for the label if join is set.
+func getWorkload(ctx context.Context, fileName, metricName, query string, join func(model.LabelName) *common.Join, nodeGroupLabels []model.LabelName, args *common.Parameters, entityKind string) {
+	if ctx.Err() != nil {
+		return
+	}
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
@@ -214,6 +219,7 @@ func getWorkload(fileName, metricName, query string, nodeGroupLabels []model.Lab
 		fmt.Println("entity=" + entityKind + " message=" + err.Error())
 		return
 	}
+	defer workloadWrite.Close()
 	if csvHeaderFormat, f := common.GetCsvHeaderFormat(entityKind); f {
 		fmt.Fprintf(workloadWrite, csvHeaderFormat, metricName)
 	} else {
@@ -226,17 +232,29 @@ func getWorkload(fileName, metricName, query string, nodeGroupLabels []model.Lab
 	for _, metricField := range nodeGroupLabels {
 
 		query2 := strings.ReplaceAll(query, "stringToBeReplaced", string(metricField))
+		var j *common.Join
+		if join != nil {
+			j = join(metricField)
+			query2 = j.String()
+		}
 
 		//If the History parameter is set to anything but default 1 then will loop through the calls starting with the current day\hour\minute interval and work backwards.
 		//This is done as the farther you go back in time the slower prometheus querying becomes and we have seen cases where will not run from timeouts on Prometheus.
 		//As a result if we do hit an issue with timing out on Prometheus side we still can send the current data and data going back to that point vs losing it all.
 		for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
+			if ctx.Err() != nil {
+				return
+			}
 			range5Min := common.TimeRange(args, historyInterval)
 
-			result, err = common.MetricCollect(args, query2, range5Min)
+			if j != nil {
+				result, err = common.CollectJoin(ctx, args, j, range5Min, entityKind, metricName)
+			} else {
+				result, err = common.MetricCollect(ctx, args, query2, range5Min, entityKind, metricName)
+			}
 			if err != nil {
-				args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
+				args.WarnLogger.Println("metric=" + metricName + " query=" + query2 + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=" + metricName + " query=" + query2 + " message=" + err.Error())
 			} else {
 				var field []model.LabelName
 				field = append(field, metricField)
@@ -244,12 +262,10 @@ func getWorkload(fileName, metricName, query string, nodeGroupLabels []model.Lab
 			}
 		}
 	}
-	//Close the workload files.
-	workloadWrite.Close()
 }
 
 // Metrics a global func for collecting node level metrics in prometheus
-func Metrics(args *common.Parameters) {
+func Metrics(ctx context.Context, args *common.Parameters) {
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -259,12 +275,13 @@ func Metrics(args *common.Parameters) {
 
 	//Start and end time + the prometheus address used for querying
 	range5Min := common.TimeRange(args, historyInterval)
+	configRange := common.ConfigRange(args)
 
 	// Node group set of queries
 	var nodeGroupLabels []model.LabelName
 
 	query = `avg(kube_node_labels) by (` + args.NodeGroupList + `)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "nodeGroup")
 	if err != nil {
 		args.WarnLogger.Println("metric=nodeGroup query=" + query + " message=" + err.Error())
 		fmt.Println("[WARNING] metric=nodeGroup query=" + query + " message=" + err.Error())
@@ -285,10 +302,30 @@ func Metrics(args *common.Parameters) {
 	}
 	var nodeGroupSuffix string
 	var requestsLabel string
+	//Limits, requests and capacity queries of the node groups
+	queries := common.NewQueries(ctx, args, configRange, entityKind)
+	addNodeGroupMetric := func(nodeGroupLabel model.LabelName, metric, query, metricName, field string) {
+		queries.AddIfAvailable(metric, query, metricName, func(query string, result model.Value, err error) {
+			if err != nil {
+				args.WarnLogger.Println("metric=" + metricName + " query=" + query + " message=" + err.Error())
+				fmt.Println("[WARNING] metric=" + metricName + " query=" + query + " message=" + err.Error())
+			} else {
+				getNodeGroupMetric(result, nodeGroupLabel, field)
+			}
+		})
+	}
+
+	//kube-state-metrics 1.x reports the limits, requests and capacity in a metric per resource.
+	hasLimits := common.FamilyAvailable(args, "the node group limits", "kube_pod_container_resource_limits", "kube_pod_container_resource_limits_cpu_cores", "kube_pod_container_resource_limits_memory_bytes")
+	hasRequests := common.FamilyAvailable(args, "the node group requests", "kube_pod_container_resource_requests", "kube_pod_container_resource_requests_cpu_cores", "kube_pod_container_resource_requests_memory_bytes")
+	hasCapacity := common.FamilyAvailable(args, "the node group capacity", "kube_node_status_capacity", "kube_node_status_capacity_cpu_cores", "kube_node_status_capacity_memory_bytes")
+	if hasRequests && common.Available(args, "kube_pod_container_resource_requests") {
+		requestsLabel = "unified"
+	}
 
 	for ng := range nodeGroupLabels {
 		query = `kube_node_labels{` + string(nodeGroupLabels[ng]) + `=~".+"}`
-		result, err = common.MetricCollect(args, query, range5Min)
+		result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "groupedNodes")
 		if err != nil {
 			args.ErrorLogger.Println("metric=groupedNodes query=" + query + " message=" + err.Error())
 			fmt.Println("[ERROR] metric=groupedNodes query=" + query + " message=" + err.Error())
@@ -306,238 +343,190 @@ func Metrics(args *common.Parameters) {
 
 		getNodeMetricString(result, nodeGroupLabels[ng])
 
-		nodeGroupSuffix = ` * on (node) group_left (` + string(nodeGroupLabels[ng]) + `) kube_node_labels{` + string(nodeGroupLabels[ng]) + `=~".+"}) by (` + string(nodeGroupLabels[ng]) + `)`
-
-		query = `sum(kube_pod_container_resource_limits) by (node, resource)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-			query = `avg(sum(kube_pod_container_resource_limits_cpu_cores*1000) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=cpuLimit query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=cpuLimit query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "cpuLimit")
-			}
-
-			query = `avg(sum(kube_pod_container_resource_limits_memory_bytes/1024/1024) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=memLimit query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=memLimit query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "memLimit")
-			}
+		label := nodeGroupLabels[ng]
+		nodeGroupSuffix = ` * on (node) group_left (` + string(label) + `) kube_node_labels{` + string(label) + `=~".+"}) by (` + string(label) + `)`
 
-		} else {
-			query = `avg(sum(kube_pod_container_resource_limits{resource="cpu"}*1000) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=cpuLimit query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=cpuLimit query=" + query + " message=" + err.Error())
+		if hasLimits {
+			if common.Available(args, "kube_pod_container_resource_limits") {
+				addNodeGroupMetric(label, "kube_pod_container_resource_limits", `avg(sum(kube_pod_container_resource_limits{resource="cpu"}*1000) by (node)`+nodeGroupSuffix, "cpuLimit", "cpuLimit")
+				addNodeGroupMetric(label, "kube_pod_container_resource_limits", `avg(sum(kube_pod_container_resource_limits{resource="memory"}/1024/1024) by (node)`+nodeGroupSuffix, "memLimit", "memLimit")
 			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "cpuLimit")
+				addNodeGroupMetric(label, "kube_pod_container_resource_limits_cpu_cores", `avg(sum(kube_pod_container_resource_limits_cpu_cores*1000) by (node)`+nodeGroupSuffix, "cpuLimit", "cpuLimit")
+				addNodeGroupMetric(label, "kube_pod_container_resource_limits_memory_bytes", `avg(sum(kube_pod_container_resource_limits_memory_bytes/1024/1024) by (node)`+nodeGroupSuffix, "memLimit", "memLimit")
 			}
-
-			query = `avg(sum(kube_pod_container_resource_limits{resource="memory"}/1024/1024) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=memLimit query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=memLimit query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "memLimit")
-			}
-
 		}
 
-		query = `sum(kube_pod_container_resource_requests) by (node, resource)`
-		result, err = common.MetricCollect(args, query, range5Min)
-		if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-			query = `avg(sum(kube_pod_container_resource_requests_cpu_cores*1000) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=cpuRequest query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=cpuRequest query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "cpuRequest")
-			}
-
-			query = `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=memRequest query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=memRequest query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "memRequest")
-			}
-		} else {
-			query = `avg(sum(kube_pod_container_resource_requests{resource="cpu"}*1000) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=cpuRequest query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=cpuRequest query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "cpuRequest")
-			}
-
-			query = `avg(sum(kube_pod_container_resource_requests{resource="memory"}/1024/1024) by (node)` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=memRequest query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=memRequest query=" + query + " message=" + err.Error())
+		if hasRequests {
+			if requestsLabel == "unified" {
+				addNodeGroupMetric(label, "kube_pod_container_resource_requests", `avg(sum(kube_pod_container_resource_requests{resource="cpu"}*1000) by (node)`+nodeGroupSuffix, "cpuRequest", "cpuRequest")
+				addNodeGroupMetric(label, "kube_pod_container_resource_requests", `avg(sum(kube_pod_container_resource_requests{resource="memory"}/1024/1024) by (node)`+nodeGroupSuffix, "memRequest", "memRequest")
 			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "memRequest")
+				addNodeGroupMetric(label, "kube_pod_container_resource_requests_cpu_cores", `avg(sum(kube_pod_container_resource_requests_cpu_cores*1000) by (node)`+nodeGroupSuffix, "cpuRequest", "cpuRequest")
+				addNodeGroupMetric(label, "kube_pod_container_resource_requests_memory_bytes", `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024) by (node)`+nodeGroupSuffix, "memRequest", "memRequest")
 			}
-			requestsLabel = "unified"
 		}
 
-		query = `avg(kube_node_status_capacity * on (node) group_left (` + string(nodeGroupLabels[ng]) + `) kube_node_labels{` + string(nodeGroupLabels[ng]) + `=~".+"}) by (` + string(nodeGroupLabels[ng]) + `,resource)`
-		result, err = common.MetricCollect(args, query, range5Min)
-
-		if mat, ok := result.(model.Matrix); err != nil || !ok || mat.Len() == 0 {
-			query = `avg(kube_node_status_capacity_cpu_cores` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=cpuCapacity query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=cpuCapacity query=" + query + " message=" + err.Error())
+		if hasCapacity {
+			if common.Available(args, "kube_node_status_capacity") {
+				query = `avg(kube_node_status_capacity * on (node) group_left (` + string(label) + `) kube_node_labels{` + string(label) + `=~".+"}) by (` + string(label) + `,resource)`
+				addNodeGroupMetric(label, "kube_node_status_capacity", query, "statusCapacity", "capacity")
 			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "cpuCapacity")
-			}
-
-			query = `avg(kube_node_status_capacity_memory_bytes/1024/1024` + nodeGroupSuffix
-			result, err = common.MetricCollect(args, query, range5Min)
-			if err != nil {
-				args.WarnLogger.Println("metric=memCapacity query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=memCapacity query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "memCapacity")
-			}
-		} else {
-			if err != nil {
-				args.WarnLogger.Println("metric=statusCapacity query=" + query + " message=" + err.Error())
-				fmt.Println("[WARNING] metric=statusCapacity query=" + query + " message=" + err.Error())
-			} else {
-				getNodeGroupMetric(result, nodeGroupLabels[ng], "capacity")
+				addNodeGroupMetric(label, "kube_node_status_capacity_cpu_cores", `avg(kube_node_status_capacity_cpu_cores`+nodeGroupSuffix, "cpuCapacity", "cpuCapacity")
+				addNodeGroupMetric(label, "kube_node_status_capacity_memory_bytes", `avg(kube_node_status_capacity_memory_bytes/1024/1024`+nodeGroupSuffix, "memCapacity", "memCapacity")
 			}
 		}
 	}
+	queries.Run()
+	//The run is being stopped, do not write the files out of partially collected data.
+	if ctx.Err() != nil {
+		return
+	}
 	writeAttributes(args)
 	writeConfig(args)
 
+	//Workloads
+	workloads := common.NewPool(args)
+	addWorkload := func(fileName, metricName, query string) {
+		workloads.Go(func() { getWorkload(ctx, fileName, metricName, query, nil, nodeGroupLabels, args, entityKind) })
+	}
+
 	//reset the nodeGroupSuffix with value that can be searched for and replaced easily as go through each workload.
 	nodeGroupSuffix = ` * on (node) group_right kube_node_labels{stringToBeReplaced=~".+"}) by (stringToBeReplaced)`
 
 	if requestsLabel == "unified" {
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="cpu"})  by (node)` + nodeGroupSuffix
-		getWorkload("cpu_requests", "CpuRequests", query, nodeGroupLabels, args, entityKind)
+		addWorkload("cpu_requests", "CpuRequests", query)
 
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="cpu"}) by (node) / sum(kube_node_status_capacity{resource="cpu"}) by (node)` + nodeGroupSuffix + ` * 100`
-		getWorkload("cpu_reservation_percent", "CpuReservationPercent", query, nodeGroupLabels, args, entityKind)
+		addWorkload("cpu_reservation_percent", "CpuReservationPercent", query)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="memory"}/1024/1024) by (node)` + nodeGroupSuffix
-		getWorkload("memory_requests", "MemoryRequests", query, nodeGroupLabels, args, entityKind)
+		addWorkload("memory_requests", "MemoryRequests", query)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests{resource="memory"}/1024/1024) by (node) / sum(kube_node_status_capacity{resource="memory"}/1024/1024) by (node)` + nodeGroupSuffix + ` * 100`
-		getWorkload("memory_reservation_percent", "MemoryReservationPercent", query, nodeGroupLabels, args, entityKind)
-	} else {
+		addWorkload("memory_reservation_percent", "MemoryReservationPercent", query)
+	} else if hasRequests {
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests_cpu_cores)  by (node)` + nodeGroupSuffix
-		getWorkload("cpu_requests", "CpuRequests", query, nodeGroupLabels, args, entityKind)
+		addWorkload("cpu_requests", "CpuRequests", query)
 
 		//Query and store prometheus CPU requests
 		query = `avg(sum(kube_pod_container_resource_requests_cpu_cores) by (node) / sum(kube_node_status_capacity_cpu_cores) by (node)` + nodeGroupSuffix + ` * 100`
-		getWorkload("cpu_reservation_percent", "CpuReservationPercent", query, nodeGroupLabels, args, entityKind)
+		addWorkload("cpu_reservation_percent", "CpuReservationPercent", query)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024) by (node)` + nodeGroupSuffix
-		getWorkload("memory_requests", "MemoryRequests", query, nodeGroupLabels, args, entityKind)
+		addWorkload("memory_requests", "MemoryRequests", query)
 
 		//Query and store prometheus Memory requests
 		query = `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024) by (node) / sum(kube_node_status_capacity_memory_bytes/1024/1024) by (node)` + nodeGroupSuffix + ` * 100`
-		getWorkload("memory_reservation_percent", "MemoryReservationPercent", query, nodeGroupLabels, args, entityKind)
+		addWorkload("memory_reservation_percent", "MemoryReservationPercent", query)
 	}
 
-	//Check to see which disk queries to use if instance is IP address that need to link to pod to get name or if instance = node name.
-	query = `max(max(label_replace(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	query = `sum(kube_node_labels{stringToBeReplaced=~".+"}) by (stringToBeReplaced)`
+	addWorkload("current_size", "CurrentSize", query)
 
-	queryPrefix := `avg(label_replace(`
-	queryPrefixSum := `avg(label_replace(sum(`
-	querySuffix := `, "node", "$1", "instance", "(.*):*")` + nodeGroupSuffix
-	querySuffixSum := `) by (instance), "node", "$1", "instance", "(.*):*")` + nodeGroupSuffix
-	if mat, ok := result.(model.Matrix); err == nil && ok && mat.Len() != 0 {
-		queryPrefix = `avg(max(label_replace(`
-		queryPrefixSum = `avg(sum(label_replace(`
-		querySuffix = `, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}` + nodeGroupSuffix
-		querySuffixSum = querySuffix
+	//The other workloads are those of Node Exporter.
+	if !common.FamilyAvailable(args, "the Node Exporter metrics", "node_cpu_seconds_total") {
+		workloads.Wait()
+		return
 	}
 
-	query = `sum(kube_node_labels{stringToBeReplaced=~".+"}) by (stringToBeReplaced)`
-	getWorkload("current_size", "CurrentSize", query, nodeGroupLabels, args, entityKind)
+	//Check to see which disk queries to use if instance is IP address that need to link to pod to get name or if instance = node name.
+	podInfo := false
+	join := &common.Join{
+		Aggregator: "max",
+		Query:      common.ByPodIP("max", `sum(irate(node_cpu_seconds_total{mode!="idle"}[`+args.SampleRateString+`m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100`),
+		Matches:    []common.Match{common.NodeExporterPods},
+		By:         []model.LabelName{"node"},
+	}
+	result, err = common.CollectJoin(ctx, args, join, range5Min, entityKind, "nodeExporterInstance")
+	if mat, ok := result.(model.Matrix); err == nil && ok && mat.Len() != 0 {
+		podInfo = true
+	}
+	//The aggregator sums the series of an instance, or takes the max for the queries returning one.
+	addJoinWorkload := func(fileName, metricName, aggregator, query string) {
+		workloads.Go(func() {
+			getWorkload(ctx, fileName, metricName, "", func(nodeGroupLabel model.LabelName) *common.Join {
+				return nodeExporterJoin(aggregator, query, podInfo, nodeGroupLabel)
+			}, nodeGroupLabels, args, entityKind)
+		})
+	}
 
 	//Query and store prometheus total cpu uptime in seconds
-	query = queryPrefix + `sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100` + querySuffix
-	getWorkload("cpu_utilization", "CpuUtilization", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("cpu_utilization", "CpuUtilization", "max", `sum(irate(node_cpu_seconds_total{mode!="idle"}[`+args.SampleRateString+`m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100`)
 
 	//Query and store prometheus node memory total in bytes
-	query = queryPrefix + `node_memory_MemTotal_bytes - node_memory_MemFree_bytes` + querySuffix
-	getWorkload("memory_raw_bytes", "MemoryBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("memory_raw_bytes", "MemoryBytes", "max", `node_memory_MemTotal_bytes - node_memory_MemFree_bytes`)
 
 	//Query and store prometheus node memory total free in bytes
-	query = queryPrefix + `node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes)` + querySuffix
-	getWorkload("memory_actual_workload", "MemoryActualWorkload", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("memory_actual_workload", "MemoryActualWorkload", "max", `node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes)`)
 
 	//Query and store prometheus node disk write in bytes
-	query = queryPrefixSum + `irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("disk_write_bytes", "DiskWriteBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("disk_write_bytes", "DiskWriteBytes", "sum", `irate(node_disk_written_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("disk_read_bytes", "DiskReadBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("disk_read_bytes", "DiskReadBytes", "sum", `irate(node_disk_read_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("disk_read_ops", "DiskReadOps", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("disk_read_ops", "DiskReadOps", "sum", `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total disk write uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("disk_write_ops", "DiskWriteOps", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("disk_write_ops", "DiskWriteOps", "sum", `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Total disk values
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("disk_total_bytes", "DiskTotalBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("disk_total_bytes", "DiskTotalBytes", "sum", `irate(node_disk_read_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("disk_total_ops", "DiskTotalOps", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("disk_total_ops", "DiskTotalOps", "sum", `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus node recieved network data in bytes
-	query = queryPrefixSum + `irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("net_received_bytes", "NetReceivedBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("net_received_bytes", "NetReceivedBytes", "sum", `irate(node_network_receive_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus recieved network data in packets
-	query = queryPrefixSum + `irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("net_received_packets", "NetReceivedPackets", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("net_received_packets", "NetReceivedPackets", "sum", `irate(node_network_receive_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total transmitted network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("net_sent_bytes", "NetSentBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("net_sent_bytes", "NetSentBytes", "sum", `irate(node_network_transmit_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total transmitted network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("net_sent_packets", "NetSentPackets", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("net_sent_packets", "NetSentPackets", "sum", `irate(node_network_transmit_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Total values network
 	//Query and store prometheus total network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("net_total_bytes", "NetTotalBytes", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("net_total_bytes", "NetTotalBytes", "sum", `irate(node_network_transmit_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
 
 	//Query and store prometheus total network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	getWorkload("net_total_packets", "NetTotalPackets", query, nodeGroupLabels, args, entityKind)
+	addJoinWorkload("net_total_packets", "NetTotalPackets", "sum", `irate(node_network_transmit_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[`+args.SampleRateString+`m])`)
+
+	workloads.Wait()
+}
+
+// nodeExporterJoin returns the join of the node exporter query with the labels of the nodes, averaged by the node group label.
+// The series of an instance are aggregated and named by node, either matching their pod IP with the pod info of the node
+// exporter pods if podInfo is set, or taking the instance for the node name otherwise.
+func nodeExporterJoin(aggregator, query string, podInfo bool, nodeGroupLabel model.LabelName) *common.Join {
+	nodeLabels := common.Match{On: []model.LabelName{"node"}, Right: true, Info: `kube_node_labels{` + string(nodeGroupLabel) + `=~".+"}`}
+	if podInfo {
+		return &common.Join{
+			Aggregator: "avg",
+			Query:      common.ByPodIP(aggregator, query),
+			Matches:    []common.Match{common.NodeExporterPods, nodeLabels},
+			By:         []model.LabelName{nodeGroupLabel},
+		}
+	}
+	if aggregator == "sum" {
+		query = `sum(` + query + `) by (instance)`
+	}
+	return &common.Join{
+		Aggregator: "avg",
+		Query:      `label_replace(` + query + `, "node", "$1", "instance", "(.*):*")`,
+		Matches:    []common.Match{nodeLabels},
+		By:         []model.LabelName{nodeGroupLabel},
+	}
 }
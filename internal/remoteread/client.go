@@ -0,0 +1,176 @@
+// Package remoteread implements the client side of the Prometheus remote-read protocol: snappy compressed protobuf requests,
+// answered with streamed frames of XOR encoded chunks (or, by older servers, with a single message of raw samples). It reads
+// raw samples much faster and with less memory than range queries returning JSON.
+package remoteread
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+// Path is the path of the remote-read endpoint, relative to the Prometheus base URL.
+const Path = "/api/v1/read"
+
+// Headers and content types of the protocol.
+const (
+	version             = "0.1.0"
+	contentTypeRequest  = "application/x-protobuf"
+	contentTypeStreamed = "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse"
+)
+
+// maxFrameSize bounds the size of a frame of a streamed response, so a corrupted length cannot exhaust the memory.
+const maxFrameSize = 50 * 1024 * 1024
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// StatusError is returned when the server answers with another status than 200 OK.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remote read failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Read sends the query to the remote-read endpoint and calls f for every series of the response, with its samples decoded and
+// restricted to the range of the query. Streamed responses are decoded as they arrive, so only one series is held in memory at
+// a time. It stops at the first error returned by f.
+func Read(ctx context.Context, client *http.Client, url string, q Query, f func(*Series) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(snappy.Encode(nil, encodeReadRequest(q))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Accept-Encoding", "snappy")
+	req.Header.Set("Content-Type", contentTypeRequest)
+	req.Header.Set("X-Prometheus-Remote-Read-Version", version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	start, end := q.Start.UnixMilli(), q.End.UnixMilli()
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-streamed-protobuf") {
+		return readStreamed(resp.Body, start, end, f)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if b, err = snappy.Decode(nil, b); err != nil {
+		return fmt.Errorf("cannot decompress remote read response: %w", err)
+	}
+	series, err := decodeReadResponse(b)
+	if err != nil {
+		return fmt.Errorf("cannot decode remote read response: %w", err)
+	}
+	for i := range series {
+		series[i].Samples = inRange(series[i].Samples, start, end)
+		if err = f(&series[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStreamed decodes the frames of a streamed response: the size of the message as a uvarint, its CRC32 (Castagnoli) and
+// the ChunkedReadResponse message. The chunks of a series may be spread over consecutive frames.
+func readStreamed(body io.Reader, start, end int64, f func(*Series) error) error {
+	r := bufio.NewReader(body)
+	var current *Series
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		s := current
+		current = nil
+		s.Samples = inRange(s.Samples, start, end)
+		return f(s)
+	}
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return flush()
+		} else if err != nil {
+			return err
+		}
+		if size > maxFrameSize {
+			return fmt.Errorf("remote read frame of %d bytes exceeds the limit of %d bytes", size, maxFrameSize)
+		}
+		var crc [4]byte
+		if _, err = io.ReadFull(r, crc[:]); err != nil {
+			return err
+		}
+		frame := make([]byte, size)
+		if _, err = io.ReadFull(r, frame); err != nil {
+			return err
+		}
+		if crc32.Checksum(frame, castagnoli) != binary.BigEndian.Uint32(crc[:]) {
+			return errors.New("remote read frame has a wrong checksum")
+		}
+		series, err := decodeChunkedReadResponse(frame)
+		if err != nil {
+			return fmt.Errorf("cannot decode remote read frame: %w", err)
+		}
+		for _, cs := range series {
+			if current != nil && !sameLabels(current.Labels, cs.labels) {
+				if err = flush(); err != nil {
+					return err
+				}
+			}
+			if current == nil {
+				current = &Series{Labels: cs.labels}
+			}
+			for _, c := range cs.chunks {
+				if c.MaxTime < start || c.MinTime > end {
+					continue
+				}
+				samples, err := DecodeXORChunk(c)
+				if err != nil {
+					return err
+				}
+				current.Samples = append(current.Samples, samples...)
+			}
+		}
+	}
+}
+
+// inRange returns the samples between start and end, included.
+func inRange(samples []Sample, start, end int64) []Sample {
+	i := 0
+	for i < len(samples) && samples[i].T < start {
+		i++
+	}
+	j := len(samples)
+	for j > i && samples[j-1].T > end {
+		j--
+	}
+	return samples[i:j]
+}
+
+func sameLabels(a, b []Label) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
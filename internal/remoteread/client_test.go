@@ -0,0 +1,184 @@
+package remoteread
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSeries returns container series of two pods, with a sample every 15 seconds over an hour and a half, so their samples
+// span several chunks.
+func testSeries() []Series {
+	var series []Series
+	for i, pod := range []string{"a", "b"} {
+		for j, container := range []string{"app", "sidecar"} {
+			series = append(series, Series{
+				Labels: []Label{{"__name__", "container_memory_usage_bytes"}, {"container", container}, {"namespace", "default"}, {"pod", pod}},
+				Samples: regularSamples(360, 15000, func(k int) float64 {
+					return float64(1000*i + 100*j + k)
+				}),
+			})
+		}
+	}
+	series = append(series, Series{
+		Labels:  []Label{{"__name__", "container_memory_usage_bytes"}, {"name", "k8s_POD_a"}, {"namespace", "default"}, {"pod", "a"}},
+		Samples: regularSamples(360, 15000, func(int) float64 { return 1 }),
+	})
+	return series
+}
+
+func TestRead(t *testing.T) {
+	series := testSeries()
+	q := Query{
+		Start: time.UnixMilli(600000),
+		End:   time.UnixMilli(3600000),
+		Step:  5 * time.Minute,
+		Matchers: []Matcher{
+			{Type: MatchEqual, Name: "__name__", Value: "container_memory_usage_bytes"},
+			{Type: MatchNotRegexp, Name: "name", Value: "k8s_POD_.*"},
+			{Type: MatchRegexp, Name: "container", Value: "app|side.*"},
+			{Type: MatchNotEqual, Name: "pod", Value: "b"},
+		},
+	}
+	want, err := selectSeries(series, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 2 || len(want[0].Samples) != 201 {
+		t.Fatalf("%d series selected, want the 2 containers of pod a with 201 samples", len(want))
+	}
+
+	for _, streamed := range []bool{true, false} {
+		var received Query
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var accepted bool
+			var err error
+			if received, accepted, err = parseRequest(r); err != nil || !accepted {
+				http.Error(w, fmt.Sprintf("streamed %t: %v", accepted, err), http.StatusBadRequest)
+				return
+			}
+			selected, _ := selectSeries(series, received)
+			_ = writeResponse(w, selected, streamed)
+		}))
+		var got []Series
+		err := Read(context.Background(), srv.Client(), srv.URL+Path, q, func(s *Series) error {
+			got = append(got, *s)
+			return nil
+		})
+		srv.Close()
+		if err != nil {
+			t.Fatalf("streamed %t: %v", streamed, err)
+		}
+		if !received.Start.Equal(q.Start) || !received.End.Equal(q.End) || received.Step != q.Step || !reflect.DeepEqual(received.Matchers, q.Matchers) {
+			t.Errorf("streamed %t: server received %+v, want %+v", streamed, received, q)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("streamed %t: read %v, want %v", streamed, got, want)
+		}
+	}
+}
+
+// writeFrame writes a frame of a streamed response, with the checksum of its content.
+func writeFrame(w http.ResponseWriter, frame []byte, checksum uint32) {
+	buf := make([]byte, binary.MaxVarintLen64+4)
+	n := binary.PutUvarint(buf, uint64(len(frame)))
+	binary.BigEndian.PutUint32(buf[n:], checksum)
+	w.Write(buf[:n+4])
+	w.Write(frame)
+}
+
+func TestReadFrames(t *testing.T) {
+	series := testSeries()[:2]
+	q := Query{Start: time.UnixMilli(0), End: time.UnixMilli(10000000)}
+	read := func(h http.HandlerFunc) ([]Series, error) {
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		var got []Series
+		err := Read(context.Background(), srv.Client(), srv.URL+Path, q, func(s *Series) error {
+			got = append(got, *s)
+			return nil
+		})
+		return got, err
+	}
+
+	// the chunks of a series spread over consecutive frames are merged
+	got, err := read(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentTypeStreamed)
+		for _, s := range series {
+			for _, c := range encodeXORChunks(s.Samples) {
+				frame := encodeChunkedReadResponse(s.Labels, []Chunk{c})
+				writeFrame(w, frame, crc32.Checksum(frame, castagnoli))
+			}
+		}
+	})
+	if err != nil || !reflect.DeepEqual(got, series) {
+		t.Errorf("series in frames of one chunk: %v, read %d series", err, len(got))
+	}
+
+	frame := encodeChunkedReadResponse(series[0].Labels, encodeXORChunks(series[0].Samples))
+	tests := []struct {
+		name string
+		h    http.HandlerFunc
+		err  string
+	}{
+		{"wrong checksum", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeStreamed)
+			writeFrame(w, frame, crc32.Checksum(frame, castagnoli)+1)
+		}, "wrong checksum"},
+		{"truncated frame", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeStreamed)
+			writeFrame(w, frame, crc32.Checksum(frame, castagnoli))
+			w.Write([]byte{100, 0, 0, 0, 0, 1, 2})
+		}, "EOF"},
+		{"frame too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeStreamed)
+			buf := binary.AppendUvarint(nil, maxFrameSize+1)
+			w.Write(append(buf, 0, 0, 0, 0))
+		}, "exceeds the limit"},
+		{"histogram chunk", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeStreamed)
+			chunks := encodeXORChunks(series[0].Samples)
+			chunks[1].Encoding = 2
+			frame := encodeChunkedReadResponse(series[0].Labels, chunks)
+			writeFrame(w, frame, crc32.Checksum(frame, castagnoli))
+		}, "chunk encoding 2 is not supported"},
+		{"not compressed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentTypeRequest)
+			w.Write(encodeReadResponse(series))
+		}, "cannot decompress"},
+		{"status", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "remote read is disabled", http.StatusNotImplemented)
+		}, "status 501: remote read is disabled"},
+	}
+	for _, test := range tests {
+		if _, err := read(test.h); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+	var statusErr *StatusError
+	if _, err := read(tests[len(tests)-1].h); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotImplemented {
+		t.Errorf("status: error %v is not a StatusError", err)
+	}
+}
+
+func TestReadStops(t *testing.T) {
+	srv := httptest.NewServer(handler(testSeries()))
+	defer srv.Close()
+	stop := errors.New("stop")
+	var n int
+	err := Read(context.Background(), srv.Client(), srv.URL+Path, Query{Start: time.UnixMilli(0), End: time.UnixMilli(10000000)}, func(*Series) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Read returned %v after %d series, want to stop after the first", err, n)
+	}
+}
@@ -0,0 +1,256 @@
+package remoteread
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MatchType is the type of a label matcher.
+type MatchType int
+
+// Label matcher types, with the values of the remote-read protocol.
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// Response types of the remote-read protocol.
+const (
+	responseSamples           = 0
+	responseStreamedXORChunks = 1
+)
+
+// ChunkXOR is the encoding of the chunks of float samples, the only one decoded; the other encodings hold native histograms.
+const ChunkXOR = 1
+
+// Matcher selects series by the value of one of their labels, like the label matchers of PromQL.
+type Matcher struct {
+	Type        MatchType
+	Name, Value string
+}
+
+// Query is a remote-read query: the raw samples of the series matching all the matchers, between the start and the end.
+// Step is only a hint for the server.
+type Query struct {
+	Start, End time.Time
+	Step       time.Duration
+	Matchers   []Matcher
+}
+
+// Label is a label of a series.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is a raw sample, with its timestamp in milliseconds.
+type Sample struct {
+	T int64
+	V float64
+}
+
+// Chunk holds encoded samples, XOR encoded for float samples.
+type Chunk struct {
+	MinTime, MaxTime int64
+	Encoding         int
+	Data             []byte
+}
+
+// Series is a series with its raw samples, sorted by time.
+type Series struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Get returns the value of the label, empty if the series does not have it.
+func (s *Series) Get(name string) string {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// chunkedSeries is a series of a streamed response, with its samples still encoded.
+type chunkedSeries struct {
+	labels []Label
+	chunks []Chunk
+}
+
+// encodeReadRequest encodes a ReadRequest with the query, asking for streamed XOR chunks or, from older servers, samples.
+func encodeReadRequest(q Query) []byte {
+	var query []byte
+	query = protowire.AppendTag(query, 1, protowire.VarintType)
+	query = protowire.AppendVarint(query, uint64(q.Start.UnixMilli()))
+	query = protowire.AppendTag(query, 2, protowire.VarintType)
+	query = protowire.AppendVarint(query, uint64(q.End.UnixMilli()))
+	for _, m := range q.Matchers {
+		var matcher []byte
+		matcher = protowire.AppendTag(matcher, 1, protowire.VarintType)
+		matcher = protowire.AppendVarint(matcher, uint64(m.Type))
+		matcher = protowire.AppendTag(matcher, 2, protowire.BytesType)
+		matcher = protowire.AppendString(matcher, m.Name)
+		matcher = protowire.AppendTag(matcher, 3, protowire.BytesType)
+		matcher = protowire.AppendString(matcher, m.Value)
+		query = protowire.AppendTag(query, 3, protowire.BytesType)
+		query = protowire.AppendBytes(query, matcher)
+	}
+	var hints []byte
+	hints = protowire.AppendTag(hints, 1, protowire.VarintType)
+	hints = protowire.AppendVarint(hints, uint64(q.Step.Milliseconds()))
+	hints = protowire.AppendTag(hints, 3, protowire.VarintType)
+	hints = protowire.AppendVarint(hints, uint64(q.Start.UnixMilli()))
+	hints = protowire.AppendTag(hints, 4, protowire.VarintType)
+	hints = protowire.AppendVarint(hints, uint64(q.End.UnixMilli()))
+	query = protowire.AppendTag(query, 4, protowire.BytesType)
+	query = protowire.AppendBytes(query, hints)
+
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, query)
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendBytes(req, protowire.AppendVarint(protowire.AppendVarint(nil, responseStreamedXORChunks), responseSamples))
+	return req
+}
+
+// decodeChunkedReadResponse decodes the series of a ChunkedReadResponse frame.
+func decodeChunkedReadResponse(b []byte) (series []chunkedSeries, err error) {
+	err = forEachField(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		var s chunkedSeries
+		err := forEachField(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				l, err := decodeLabel(data)
+				s.labels = append(s.labels, l)
+				return err
+			case num == 2 && typ == protowire.BytesType:
+				c, err := decodeChunk(data)
+				if err == nil {
+					s.chunks = append(s.chunks, c)
+				}
+				return err
+			}
+			return nil
+		})
+		series = append(series, s)
+		return err
+	})
+	return
+}
+
+// decodeChunk decodes a chunk, of any encoding.
+func decodeChunk(b []byte) (c Chunk, err error) {
+	err = forEachField(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			c.MinTime = int64(v)
+		case num == 2 && typ == protowire.VarintType:
+			c.MaxTime = int64(v)
+		case num == 3 && typ == protowire.VarintType:
+			c.Encoding = int(v)
+		case num == 4 && typ == protowire.BytesType:
+			c.Data = data
+		}
+		return nil
+	})
+	return
+}
+
+func decodeLabel(b []byte) (l Label, err error) {
+	err = forEachField(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			l.Name = string(data)
+		case num == 2 && typ == protowire.BytesType:
+			l.Value = string(data)
+		}
+		return nil
+	})
+	return
+}
+
+// decodeReadResponse decodes the series of the first query result of a (non streamed) ReadResponse.
+func decodeReadResponse(b []byte) (series []Series, err error) {
+	found := false
+	err = forEachField(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		if num != 1 || typ != protowire.BytesType || found {
+			return nil
+		}
+		found = true
+		return forEachField(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+			if num != 1 || typ != protowire.BytesType {
+				return nil
+			}
+			var s Series
+			err := forEachField(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					l, err := decodeLabel(data)
+					s.Labels = append(s.Labels, l)
+					return err
+				case num == 2 && typ == protowire.BytesType:
+					var sample Sample
+					err := forEachField(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+						switch {
+						case num == 1 && typ == protowire.Fixed64Type:
+							sample.V = math.Float64frombits(v)
+						case num == 2 && typ == protowire.VarintType:
+							sample.T = int64(v)
+						}
+						return nil
+					})
+					s.Samples = append(s.Samples, sample)
+					return err
+				}
+				return nil
+			})
+			series = append(series, s)
+			return err
+		})
+	})
+	return
+}
+
+// forEachField calls f with every field of the message: v holds the value of varint and fixed fields, data the content of
+// length-delimited ones. Groups are not used by the protocol and are rejected.
+func forEachField(b []byte, f func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			return fmt.Errorf("unexpected protobuf wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, typ, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package remoteread
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The server side of the protocol, serving series held in memory the way Prometheus does, to test the client against.
+
+// maxSamplesPerChunk is the number of samples Prometheus stores in a chunk.
+const maxSamplesPerChunk = 120
+
+// handler serves the series over the remote-read protocol, as a Prometheus holding them would: the series matching the
+// matchers of the query are returned, with their samples in the range of the query, streamed if the client accepts it.
+// Only the first query of a request is answered.
+func handler(series []Series) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, streamed, err := parseRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		selected, err := selectSeries(series, q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = writeResponse(w, selected, streamed)
+	})
+}
+
+// parseRequest decodes the (first) query of a remote-read request and tells whether the client accepts streamed responses.
+func parseRequest(r *http.Request) (q Query, streamed bool, err error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if b, err = snappy.Decode(nil, b); err != nil {
+		return q, false, fmt.Errorf("cannot decompress remote read request: %w", err)
+	}
+	return decodeReadRequest(b)
+}
+
+// selectSeries returns the series matching all the matchers of the query, with their samples in its range, sorted by labels.
+func selectSeries(series []Series, q Query) ([]Series, error) {
+	type compiled struct {
+		Matcher
+		re *regexp.Regexp
+	}
+	var matchers []compiled
+	for _, m := range q.Matchers {
+		c := compiled{Matcher: m}
+		if m.Type == MatchRegexp || m.Type == MatchNotRegexp {
+			var err error
+			// matchers are fully anchored, like in PromQL
+			if c.re, err = regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+				return nil, err
+			}
+		}
+		matchers = append(matchers, c)
+	}
+	var selected []Series
+	for i := range series {
+		s := &series[i]
+		match := true
+		for _, m := range matchers {
+			v := s.Get(m.Name)
+			switch m.Type {
+			case MatchEqual:
+				match = match && v == m.Value
+			case MatchNotEqual:
+				match = match && v != m.Value
+			case MatchRegexp:
+				match = match && m.re.MatchString(v)
+			case MatchNotRegexp:
+				match = match && !m.re.MatchString(v)
+			}
+		}
+		if samples := inRange(s.Samples, q.Start.UnixMilli(), q.End.UnixMilli()); match && len(samples) > 0 {
+			selected = append(selected, Series{Labels: s.Labels, Samples: samples})
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return fmt.Sprint(selected[i].Labels) < fmt.Sprint(selected[j].Labels) })
+	return selected, nil
+}
+
+// writeResponse writes the series as a streamed response of XOR chunks, one frame per series, or as a single snappy compressed
+// message of raw samples.
+func writeResponse(w http.ResponseWriter, series []Series, streamed bool) error {
+	if !streamed {
+		w.Header().Set("Content-Type", contentTypeRequest)
+		w.Header().Set("Content-Encoding", "snappy")
+		_, err := w.Write(snappy.Encode(nil, encodeReadResponse(series)))
+		return err
+	}
+	w.Header().Set("Content-Type", contentTypeStreamed)
+	buf := make([]byte, binary.MaxVarintLen64+4)
+	for _, s := range series {
+		frame := encodeChunkedReadResponse(s.Labels, encodeXORChunks(s.Samples))
+		n := binary.PutUvarint(buf, uint64(len(frame)))
+		binary.BigEndian.PutUint32(buf[n:], crc32.Checksum(frame, castagnoli))
+		if _, err := w.Write(buf[:n+4]); err != nil {
+			return err
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return nil
+}
+
+// decodeReadRequest decodes the first query of a ReadRequest, and whether streamed XOR chunks are accepted.
+func decodeReadRequest(b []byte) (q Query, streamed bool, err error) {
+	found := false
+	err = forEachField(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType && !found:
+			found = true
+			return decodeQuery(data, &q)
+		case num == 2 && typ == protowire.VarintType:
+			streamed = streamed || v == responseStreamedXORChunks
+		case num == 2 && typ == protowire.BytesType:
+			// packed
+			for len(data) > 0 {
+				t, n := protowire.ConsumeVarint(data)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				streamed = streamed || t == responseStreamedXORChunks
+				data = data[n:]
+			}
+		}
+		return nil
+	})
+	if err == nil && !found {
+		err = errors.New("read request has no query")
+	}
+	return
+}
+
+func decodeQuery(b []byte, q *Query) error {
+	return forEachField(b, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			q.Start = time.UnixMilli(int64(v))
+		case num == 2 && typ == protowire.VarintType:
+			q.End = time.UnixMilli(int64(v))
+		case num == 3 && typ == protowire.BytesType:
+			var m Matcher
+			err := forEachField(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					m.Type = MatchType(v)
+				case num == 2 && typ == protowire.BytesType:
+					m.Name = string(data)
+				case num == 3 && typ == protowire.BytesType:
+					m.Value = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			q.Matchers = append(q.Matchers, m)
+		case num == 4 && typ == protowire.BytesType:
+			return forEachField(data, func(num protowire.Number, typ protowire.Type, v uint64, data []byte) error {
+				if num == 1 && typ == protowire.VarintType {
+					q.Step = time.Duration(v) * time.Millisecond
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// encodeChunkedReadResponse encodes a ChunkedReadResponse frame holding one series.
+func encodeChunkedReadResponse(labels []Label, chunks []Chunk) []byte {
+	var series []byte
+	series = appendLabels(series, labels)
+	for _, c := range chunks {
+		var chunk []byte
+		chunk = protowire.AppendTag(chunk, 1, protowire.VarintType)
+		chunk = protowire.AppendVarint(chunk, uint64(c.MinTime))
+		chunk = protowire.AppendTag(chunk, 2, protowire.VarintType)
+		chunk = protowire.AppendVarint(chunk, uint64(c.MaxTime))
+		chunk = protowire.AppendTag(chunk, 3, protowire.VarintType)
+		chunk = protowire.AppendVarint(chunk, uint64(c.Encoding))
+		chunk = protowire.AppendTag(chunk, 4, protowire.BytesType)
+		chunk = protowire.AppendBytes(chunk, c.Data)
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, chunk)
+	}
+	var resp []byte
+	resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+	resp = protowire.AppendBytes(resp, series)
+	return resp
+}
+
+// encodeReadResponse encodes a ReadResponse with a single query result.
+func encodeReadResponse(series []Series) []byte {
+	var result []byte
+	for _, s := range series {
+		var ts []byte
+		ts = appendLabels(ts, s.Labels)
+		for _, sample := range s.Samples {
+			var b []byte
+			b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(sample.V))
+			b = protowire.AppendTag(b, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(sample.T))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, b)
+		}
+		result = protowire.AppendTag(result, 1, protowire.BytesType)
+		result = protowire.AppendBytes(result, ts)
+	}
+	var resp []byte
+	resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+	resp = protowire.AppendBytes(resp, result)
+	return resp
+}
+
+func appendLabels(b []byte, labels []Label) []byte {
+	for _, l := range labels {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, l.Name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, l.Value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	return b
+}
+
+// bitWriter is the counterpart of bitReader.
+type bitWriter struct {
+	b   []byte
+	pos int // in bits
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	if w.pos%8 == 0 {
+		w.b = append(w.b, 0)
+	}
+	if bit != 0 {
+		w.b[len(w.b)-1] |= 1 << (7 - uint(w.pos%8))
+	}
+	w.pos++
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v >> uint(i) & 1)
+	}
+}
+
+func (w *bitWriter) writeBytes(b []byte) {
+	for _, c := range b {
+		w.writeBits(uint64(c), 8)
+	}
+}
+
+// encodeXORChunks encodes the samples, sorted by time, in XOR chunks of at most 120 samples like the Prometheus TSDB does.
+func encodeXORChunks(samples []Sample) []Chunk {
+	var chunks []Chunk
+	for len(samples) > 0 {
+		n := len(samples)
+		if n > maxSamplesPerChunk {
+			n = maxSamplesPerChunk
+		}
+		chunks = append(chunks, Chunk{MinTime: samples[0].T, MaxTime: samples[n-1].T, Encoding: ChunkXOR, Data: encodeXORChunk(samples[:n])})
+		samples = samples[n:]
+	}
+	return chunks
+}
+
+func encodeXORChunk(samples []Sample) []byte {
+	w := &bitWriter{b: make([]byte, 0, 2+len(samples)*2)}
+	w.writeBits(uint64(len(samples)), 16)
+	var tDelta int64
+	var leading, trailing = 0xff, 0
+	buf := make([]byte, binary.MaxVarintLen64)
+	for i, s := range samples {
+		v := math.Float64bits(s.V)
+		switch i {
+		case 0:
+			w.writeBytes(buf[:binary.PutVarint(buf, s.T)])
+			w.writeBits(v, 64)
+		case 1:
+			tDelta = s.T - samples[0].T
+			w.writeBytes(buf[:binary.PutUvarint(buf, uint64(tDelta))])
+			leading, trailing = writeXORValue(w, v, math.Float64bits(samples[0].V), leading, trailing)
+		default:
+			d := s.T - samples[i-1].T
+			dod := d - tDelta
+			tDelta = d
+			switch {
+			case dod == 0:
+				w.writeBit(0)
+			case fitsIn(dod, 14):
+				w.writeBits(0b10, 2)
+				w.writeBits(uint64(dod), 14)
+			case fitsIn(dod, 17):
+				w.writeBits(0b110, 3)
+				w.writeBits(uint64(dod), 17)
+			case fitsIn(dod, 20):
+				w.writeBits(0b1110, 4)
+				w.writeBits(uint64(dod), 20)
+			default:
+				w.writeBits(0b1111, 4)
+				w.writeBits(uint64(dod), 64)
+			}
+			leading, trailing = writeXORValue(w, v, math.Float64bits(samples[i-1].V), leading, trailing)
+		}
+	}
+	return w.b
+}
+
+// fitsIn tells whether x can be written on n bits the way readDoD reads it back.
+func fitsIn(x int64, n int) bool {
+	return -(1<<(n-1))+1 <= x && x <= 1<<(n-1)
+}
+
+func writeXORValue(w *bitWriter, v, prev uint64, leading, trailing int) (int, int) {
+	x := v ^ prev
+	if x == 0 {
+		w.writeBit(0)
+		return leading, trailing
+	}
+	w.writeBit(1)
+	l, t := bits.LeadingZeros64(x), bits.TrailingZeros64(x)
+	// the number of leading zeros is written on 5 bits
+	if l >= 32 {
+		l = 31
+	}
+	if leading != 0xff && l >= leading && t >= trailing {
+		w.writeBit(0)
+		w.writeBits(x>>uint(trailing), 64-leading-trailing)
+		return leading, trailing
+	}
+	w.writeBit(1)
+	w.writeBits(uint64(l), 5)
+	// 64 meaningful bits are written as 0, which cannot happen otherwise
+	w.writeBits(uint64(64-l-t), 6)
+	w.writeBits(x>>uint(t), 64-l-t)
+	return l, t
+}
@@ -0,0 +1,172 @@
+package remoteread
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// staleNaN is the value Prometheus stores to mark a series as stale; it is not a sample.
+const staleNaN uint64 = 0x7ff0000000000002
+
+var errChunkTruncated = errors.New("XOR chunk is truncated")
+
+// IsStale tells whether the value is the staleness marker of Prometheus.
+func IsStale(v float64) bool {
+	return math.Float64bits(v) == staleNaN
+}
+
+// bitReader reads the bit stream of a chunk, most significant bit first.
+type bitReader struct {
+	b   []byte
+	pos int // in bits
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos >= len(r.b)*8 {
+		return 0, errChunkTruncated
+	}
+	bit := uint64(r.b[r.pos/8]>>(7-uint(r.pos%8))) & 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+// ReadByte makes the reader an io.ByteReader, for the varints which are written in the bit stream.
+func (r *bitReader) ReadByte() (byte, error) {
+	v, err := r.readBits(8)
+	return byte(v), err
+}
+
+// DecodeXORChunk decodes a chunk in the Gorilla XOR encoding of the Prometheus TSDB: the number of samples on 2 bytes, the first
+// sample in full, then delta-of-delta encoded timestamps and XOR encoded values. Chunks of other encodings are rejected.
+func DecodeXORChunk(c Chunk) ([]Sample, error) {
+	if c.Encoding != ChunkXOR {
+		return nil, fmt.Errorf("chunk encoding %d is not supported, only XOR chunks of float samples are", c.Encoding)
+	}
+	data := c.Data
+	if len(data) < 2 {
+		return nil, errChunkTruncated
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	samples := make([]Sample, 0, n)
+	r := &bitReader{b: data[2:]}
+	var t, tDelta int64
+	var v uint64
+	var leading, trailing int
+	for i := 0; i < n; i++ {
+		switch i {
+		case 0:
+			ts, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, errChunkTruncated
+			}
+			if v, err = r.readBits(64); err != nil {
+				return nil, err
+			}
+			t = ts
+		case 1:
+			d, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errChunkTruncated
+			}
+			tDelta = int64(d)
+			t += tDelta
+			if v, leading, trailing, err = readXORValue(r, v, leading, trailing); err != nil {
+				return nil, err
+			}
+		default:
+			dod, err := readDoD(r)
+			if err != nil {
+				return nil, err
+			}
+			tDelta += dod
+			t += tDelta
+			if v, leading, trailing, err = readXORValue(r, v, leading, trailing); err != nil {
+				return nil, err
+			}
+		}
+		samples = append(samples, Sample{T: t, V: math.Float64frombits(v)})
+	}
+	return samples, nil
+}
+
+// readDoD reads a delta of delta of timestamps: its size is given by a prefix of up to 4 bits.
+func readDoD(r *bitReader) (int64, error) {
+	var prefix uint64
+	for i := 0; i < 4; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		prefix = prefix<<1 | bit
+		if bit == 0 {
+			break
+		}
+	}
+	var size int
+	switch prefix {
+	case 0b0:
+		return 0, nil
+	case 0b10:
+		size = 14
+	case 0b110:
+		size = 17
+	case 0b1110:
+		size = 20
+	default:
+		v, err := r.readBits(64)
+		return int64(v), err
+	}
+	v, err := r.readBits(size)
+	if err != nil {
+		return 0, err
+	}
+	// negative values come back as large unsigned ones
+	if v > 1<<(size-1) {
+		return int64(v) - 1<<size, nil
+	}
+	return int64(v), nil
+}
+
+func readXORValue(r *bitReader, v uint64, leading, trailing int) (uint64, int, int, error) {
+	bit, err := r.readBit()
+	if err != nil || bit == 0 {
+		return v, leading, trailing, err
+	}
+	if bit, err = r.readBit(); err != nil {
+		return v, leading, trailing, err
+	}
+	if bit == 1 {
+		// new window of meaningful bits
+		l, err := r.readBits(5)
+		if err != nil {
+			return v, leading, trailing, err
+		}
+		m, err := r.readBits(6)
+		if err != nil {
+			return v, leading, trailing, err
+		}
+		if m == 0 {
+			m = 64
+		}
+		leading, trailing = int(l), 64-int(l)-int(m)
+	}
+	x, err := r.readBits(64 - leading - trailing)
+	if err != nil {
+		return v, leading, trailing, err
+	}
+	return v ^ x<<uint(trailing), leading, trailing, nil
+}
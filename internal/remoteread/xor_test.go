@@ -0,0 +1,121 @@
+package remoteread
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestXORChunks(t *testing.T) {
+	stale := math.Float64frombits(staleNaN)
+	tests := []struct {
+		name    string
+		samples []Sample
+	}{
+		{"one sample", []Sample{{T: 1000, V: 1}}},
+		{"regular", regularSamples(300, 15000, func(i int) float64 { return float64(i % 7) })},
+		{"constant", regularSamples(130, 30000, func(int) float64 { return 42 })},
+		{"jitter", []Sample{{T: 0, V: 1.5}, {T: 15001, V: 1.25}, {T: 29999, V: -3}, {T: 45000, V: 1e-9}, {T: 45001, V: 1e300}}},
+		{"gaps", []Sample{{T: 0, V: 1}, {T: 10, V: 2}, {T: 8000, V: 3}, {T: 200000, V: 4}, {T: 1 << 40, V: 5}, {T: 1<<40 + 1, V: 6}}},
+		{"negative time", []Sample{{T: -60000, V: 1}, {T: -30000, V: 2}, {T: 0, V: 3}}},
+		{"stale", []Sample{{T: 0, V: 1}, {T: 15000, V: stale}, {T: 30000, V: 2}}},
+		{"special values", []Sample{{T: 0, V: math.Inf(1)}, {T: 1, V: math.Inf(-1)}, {T: 2, V: 0}, {T: 3, V: math.MaxFloat64}, {T: 4, V: math.SmallestNonzeroFloat64}}},
+	}
+	for _, test := range tests {
+		chunks := encodeXORChunks(test.samples)
+		if want := (len(test.samples) + maxSamplesPerChunk - 1) / maxSamplesPerChunk; len(chunks) != want {
+			t.Errorf("%s: %d chunks, want %d", test.name, len(chunks), want)
+		}
+		var decoded []Sample
+		for _, c := range chunks {
+			samples, err := DecodeXORChunk(c)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if len(samples) == 0 || samples[0].T != c.MinTime || samples[len(samples)-1].T != c.MaxTime {
+				t.Errorf("%s: chunk %d - %d holds samples %v", test.name, c.MinTime, c.MaxTime, samples)
+			}
+			decoded = append(decoded, samples...)
+		}
+		if len(decoded) != len(test.samples) {
+			t.Fatalf("%s: %d samples decoded, want %d", test.name, len(decoded), len(test.samples))
+		}
+		for i, s := range decoded {
+			if s.T != test.samples[i].T || math.Float64bits(s.V) != math.Float64bits(test.samples[i].V) {
+				t.Errorf("%s: sample %d is %v, want %v", test.name, i, s, test.samples[i])
+			}
+		}
+	}
+	if !IsStale(stale) || IsStale(math.NaN()) {
+		t.Error("IsStale does not tell the staleness marker from other NaNs")
+	}
+}
+
+// The chunks were encoded by the XORChunk of tsdb/chunkenc of Prometheus v0.45.0, which is not a dependency of the module.
+func TestDecodeChunkencChunks(t *testing.T) {
+	stale := math.Float64frombits(staleNaN)
+	regular := regularSamples(120, 15000, func(i int) float64 { return float64(i%7) * 1.5 })
+	for i := range regular {
+		regular[i].T += 1704160800000
+	}
+	tests := []struct {
+		name    string
+		data    string
+		samples []Sample
+	}{
+		{"jitter", "000780b4b1fe98633fe00000000000009975d80efff7017ffe000f001fc325c17d04dad2bb8ad12013651bb0135184e1d4b4038fc8791000eb3d00031ff800000000000100",
+			[]Sample{{T: 1704160800000, V: 0.5}, {T: 1704160815001, V: 0.75}, {T: 1704160829999, V: -3}, {T: 1704160845000, V: 1e-9}, {T: 1704160845001, V: 1e300}, {T: 1704160860000, V: stale}, {T: 1704160875000, V: 2}}},
+		{"regular", "007880b4b1fe986300000000000000009875c45fff612fffb589a951b509ec2740229ffc5ffc20035000a8003400f280453ff8bff84006a00150006801e5008a7ff17ff0800d4002a000d003ca0114ffe2ffe1001a80054001a007940229ffc5ffc20035000a8003400f280453ff8bff84006a00150006801e5008a7ff17ff0800d4002a000d003ca0114ffe2ffe1001a80054001a007940229ffc5ffc20035000a8003400f280453ff8bff84006a00150006801e5008a7ff17ff0800d4002a000d003ca0114ffe2ffe1001a80054001a007940229ffc5ffc20035000a8003400f280453ff8bff84006a00150006801e5008a7ff17ff0800d4002a000d003ca0114ffe2ffe1001a80054001a00794022",
+			regular},
+		{"gaps", "0008003ff00000000000000ac25fff9f2cd80f8b3b2b585f8000007ffffd02606d07ffffffe0000061a83b02fc000003fffffffff7117ff7ffffffc000000000f5ebfffffffffffff0",
+			[]Sample{{T: 0, V: 1}, {T: 10, V: 2}, {T: 8000, V: 3}, {T: 200000, V: 4}, {T: 1 << 40, V: 5}, {T: 1<<40 + 1, V: 6}, {T: 1<<41 - 1, V: math.Inf(1)}, {T: 1 << 41, V: math.MaxFloat64}}},
+	}
+	for _, test := range tests {
+		data, err := hex.DecodeString(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples, err := DecodeXORChunk(Chunk{Encoding: ChunkXOR, Data: data})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(samples) != len(test.samples) {
+			t.Fatalf("%s: %d samples decoded, want %d", test.name, len(samples), len(test.samples))
+		}
+		for i, sample := range samples {
+			if sample.T != test.samples[i].T || math.Float64bits(sample.V) != math.Float64bits(test.samples[i].V) {
+				t.Errorf("%s: sample %d = %v, want %v", test.name, i, sample, test.samples[i])
+			}
+		}
+		// the encoder of the forwarder writes the same bytes
+		if chunks := encodeXORChunks(test.samples); len(chunks) != 1 || hex.EncodeToString(chunks[0].Data) != test.data {
+			t.Errorf("%s: encoded %x", test.name, chunks[0].Data)
+		}
+	}
+}
+
+func TestDecodeXORChunkErrors(t *testing.T) {
+	c := encodeXORChunks(regularSamples(10, 1000, func(i int) float64 { return float64(i) }))[0]
+	histogram := c
+	histogram.Encoding = 2
+	if _, err := DecodeXORChunk(histogram); err == nil || !strings.Contains(err.Error(), "chunk encoding 2 is not supported") {
+		t.Errorf("histogram chunk decoded, error %v", err)
+	}
+	for _, n := range []int{0, 1, 2, len(c.Data) / 2, len(c.Data) - 1} {
+		truncated := c
+		truncated.Data = c.Data[:n]
+		if _, err := DecodeXORChunk(truncated); err == nil {
+			t.Errorf("chunk truncated to %d bytes decoded", n)
+		}
+	}
+}
+
+// regularSamples returns n samples every interval milliseconds, with the values of f.
+func regularSamples(n int, interval int64, f func(int) float64) []Sample {
+	samples := make([]Sample, n)
+	for i := range samples {
+		samples[i] = Sample{T: int64(i) * interval, V: f(i)}
+	}
+	return samples
+}
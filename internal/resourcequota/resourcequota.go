@@ -1,6 +1,7 @@
 package resourcequota
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -196,7 +197,7 @@ func writeAttributes(args *common.Parameters) {
 }
 
 // Metrics a global func for collecting quota level metrics in prometheus
-func Metrics(args *common.Parameters) {
+func Metrics(ctx context.Context, args *common.Parameters) {
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -208,7 +209,7 @@ func Metrics(args *common.Parameters) {
 	range5Min := common.TimeRange(args, historyInterval)
 
 	query = `max(kube_resourcequota_created) by (namespace,resourcequota)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, range5Min, entityKind, "resourceQuotas")
 
 	if err != nil {
 		args.WarnLogger.Println("metric=resourceQuotas query=" + query + " message=" + err.Error())
@@ -219,6 +220,9 @@ func Metrics(args *common.Parameters) {
 	for i := 0; i < rsltIndex.Len(); i++ {
 
 		namespaceName := string(result.(model.Matrix)[i].Metric["namespace"])
+		if !common.IncludeNamespace(args, namespaceName) {
+			continue
+		}
 		if _, ok := resourceQuotas[namespaceName]; !ok {
 			resourceQuotas[namespaceName] = &namespace{rqs: map[string]*resourceQuota{}}
 		}
@@ -236,7 +240,7 @@ func Metrics(args *common.Parameters) {
 	}
 
 	query = `max(kube_resourcequota) by (resourcequota, resource, namespace, type)`
-	result, err = common.MetricCollect(args, query, range5Min)
+	result, err = common.MetricCollect(ctx, args, query, common.ConfigRange(args), entityKind, "resourceQuotaLimits")
 	if err != nil {
 		args.WarnLogger.Println("metric=resourceQuotaLimits query=" + query + " message=" + err.Error())
 		fmt.Println("[WARNING] metric=resourceQuotaLimits query=" + query + " message=" + err.Error())
@@ -244,26 +248,33 @@ func Metrics(args *common.Parameters) {
 		getExistingQuotas(result)
 	}
 
+	//The run is being stopped, do not write the files out of partially collected data.
+	if ctx.Err() != nil {
+		return
+	}
 	writeAttributes(args)
 	writeConfig(args)
 
 	var metricField []model.LabelName
 	metricField = append(metricField, "namespace")
 	metricField = append(metricField, "resourcequota")
+	//Workloads
+	workloads := common.NewPool(args)
 
 	query = `sum(kube_resourcequota{type="used", resource="limits.cpu"}) by (resourcequota,namespace) * 1000`
-	common.GetWorkload("cpu_limits", "CpuLimits", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "cpu_limits", "CpuLimits", query, metricField, args, entityKind)
 
 	query = `sum(kube_resourcequota{type="used", resource=~"cpu|requests\\.cpu"}) by (resourcequota,namespace) * 1000`
-	common.GetWorkload("cpu_requests", "CpuRequests", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "cpu_requests", "CpuRequests", query, metricField, args, entityKind)
 
 	query = `sum(kube_resourcequota{type="used", resource="limits.memory"}) by (resourcequota,namespace)`
-	common.GetWorkload("mem_limits", "MemLimits", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "mem_limits", "MemLimits", query, metricField, args, entityKind)
 
 	query = `sum(kube_resourcequota{type="used", resource=~"memory|requests\\.memory"}) by (resourcequota,namespace) / (1024 * 1024)`
-	common.GetWorkload("mem_requests", "MemRequests", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "mem_requests", "MemRequests", query, metricField, args, entityKind)
 
 	query = `sum(kube_resourcequota{type="used", resource=~"pods|count\\/pods"}) by (resourcequota,namespace)`
-	common.GetWorkload("pods", "PodsLimits", query, metricField, args, entityKind)
+	workloads.GetWorkload(ctx, "pods", "PodsLimits", query, metricField, args, entityKind)
 
+	workloads.Wait()
 }